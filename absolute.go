@@ -0,0 +1,6 @@
+package main
+
+// tabletDeviceName names the absolute pointer used in absolute output mode.
+func tabletDeviceName(base, seat string) string {
+	return virtualDeviceName(base, seat) + " Absolute"
+}
@@ -0,0 +1,8 @@
+package main
+
+// Paths on Android, whose userspace has no /etc to configure and no /run for
+// the socket. /data/adb is where root add-ons keep their files.
+const (
+	AndroidControlSocketPath = "/data/local/tmp/touchpad2mouse.sock"
+	AndroidStatePath         = "/data/adb/touchpad2mouse/state.json"
+)
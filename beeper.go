@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+
+	"touchpad/device"
+	"touchpad/engine"
+)
+
+const (
+	// BeepTone and BeepLength shape the feedback beep.
+	BeepTone   = 880 // Hz
+	BeepLength = 40 * time.Millisecond
+)
+
+// beeper gives audible feedback through an input device that takes sound
+// events, usually the PC speaker, so it works without a sound server.
+type beeper struct {
+	f *os.File
+}
+
+func openBeeper() (*beeper, error) {
+	devices, _ := evdev.ListInputDevices()
+	defer func() {
+		for _, dev := range devices {
+			dev.File.Close()
+		}
+	}()
+	for _, dev := range devices {
+		if len(dev.CapabilitiesFlat[evdev.EV_SND]) == 0 {
+			continue
+		}
+		f, err := os.OpenFile(dev.Fn, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &beeper{f: f}, nil
+	}
+	return nil, errors.New("no PC speaker or other sound-capable input device")
+}
+
+// Beep starts a tone and stops it from loop after BeepLength. A nil beeper
+// does nothing.
+func (b *beeper) Beep(loop *engine.Loop) {
+	if b == nil {
+		return
+	}
+	b.tone(BeepTone)
+	loop.After(BeepLength, func() { b.tone(0) })
+}
+
+func (b *beeper) tone(hz int32) {
+	var buf [2 * device.EventSize]byte
+	device.PutEvent(buf[:], evdev.EV_SND, evdev.SND_TONE, hz)
+	device.PutEvent(buf[device.EventSize:], device.EV_SYN, device.SYN_REPORT, 0)
+	b.f.Write(buf[:])
+}
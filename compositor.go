@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Default compositor commands, matching what the key chords do on a
+// traditional desktop as closely as each compositor allows.
+var (
+	hyprlandCommands = map[string]string{
+		"swipe-right": "cyclenext prev",
+		"swipe-left":  "cyclenext",
+		"swipe-up":    "togglespecialworkspace",
+		"swipe-down":  "workspace empty",
+
+		"four-finger-swipe-left":  "workspace e+1",
+		"four-finger-swipe-right": "workspace e-1",
+	}
+	swayCommands = map[string]string{
+		"swipe-right": "focus prev",
+		"swipe-left":  "focus next",
+		"swipe-up":    "scratchpad show",
+		"swipe-down":  "workspace back_and_forth",
+
+		"four-finger-swipe-left":  "workspace next",
+		"four-finger-swipe-right": "workspace prev",
+	}
+)
+
+const compositorTimeout = 200 * time.Millisecond
+
+// CompositorQueue bounds the gestures waiting to be sent to the compositor.
+const CompositorQueue = 8
+
+// compositorBackend dispatches gestures as Hyprland or sway IPC commands,
+// which works regardless of how the user has bound global shortcuts. The
+// IPC is done by a worker, off the event loop, to the compositor of the
+// session active on the seat.
+type compositorBackend struct {
+	kind      string // "hyprland", "sway" or "auto"
+	seat      string
+	overrides map[string]string
+	// target is the compositor found, nil until there is one.
+	target atomic.Pointer[compositorTarget]
+	jobs   chan compositorJob
+	// bus is the worker's connection to logind, for a system service.
+	bus *dbus.Conn
+}
+
+// compositorTarget is a compositor's IPC socket and the user whose session
+// it runs.
+type compositorTarget struct {
+	kind, socket string
+	uid          int
+}
+
+// compositorJob is a command to send to target, or, without one, a request
+// to look for the compositor again.
+type compositorJob struct {
+	target *compositorTarget
+	cmd    string
+}
+
+func newCompositorBackend(kind, seat string, overrides map[string]string) *compositorBackend {
+	c := &compositorBackend{kind: kind, seat: seat, overrides: overrides, jobs: make(chan compositorJob, CompositorQueue)}
+	go c.run()
+	return c
+}
+
+// Dispatch hands the gesture's command to the worker. Until the compositor
+// is found it reports false, so the key chord is sent instead.
+func (c *compositorBackend) Dispatch(gesture string) bool {
+	t := c.target.Load()
+	if t == nil {
+		c.queue(compositorJob{})
+		return false
+	}
+	cmd, ok := c.overrides[gesture]
+	if !ok {
+		if t.kind == "hyprland" {
+			cmd = hyprlandCommands[gesture]
+		} else {
+			cmd = swayCommands[gesture]
+		}
+	}
+	if cmd == "" {
+		return false
+	}
+	c.queue(compositorJob{t, cmd})
+	return true
+}
+
+// queue passes job to the worker without ever blocking the event loop.
+func (c *compositorBackend) queue(job compositorJob) {
+	select {
+	case c.jobs <- job:
+	default:
+		if job.cmd != "" {
+			slog.Warn("compositor IPC backed up, gesture dropped", "seat", c.seat, "command", job.cmd)
+		}
+	}
+}
+
+func (c *compositorBackend) run() {
+	c.target.Store(c.find())
+	for job := range c.jobs {
+		if job.target == nil {
+			if c.target.Load() == nil {
+				c.target.Store(c.find())
+			}
+			continue
+		}
+		// Once another user's session is active on the seat, the gesture
+		// isn't sent to the compositor it was meant for, nor to theirs.
+		if os.Getuid() == 0 {
+			if uid, ok := c.sessionUser(); !ok || uid != job.target.uid {
+				c.target.Store(c.find())
+				continue
+			}
+		}
+		var err error
+		if job.target.kind == "hyprland" {
+			err = hyprlandDispatch(job.target.socket, job.cmd)
+		} else {
+			err = swayCommand(job.target.socket, job.cmd)
+		}
+		if err != nil {
+			slog.Warn("compositor IPC failed", "seat", c.seat, "compositor", job.target.kind, "err", err)
+			// The compositor may have been restarted; look again.
+			c.target.CompareAndSwap(job.target, nil)
+		}
+	}
+}
+
+// find looks for the compositor of the session active on the seat: for a
+// system service, that of the user logind says it belongs to; for a user
+// service, its own.
+func (c *compositorBackend) find() *compositorTarget {
+	uid := os.Getuid()
+	if uid == 0 {
+		var ok bool
+		if uid, ok = c.sessionUser(); !ok {
+			return nil
+		}
+	}
+	kinds := []string{c.kind}
+	if c.kind == "auto" {
+		kinds = []string{"hyprland", "sway"}
+	}
+	for _, kind := range kinds {
+		if socket := compositorSocket(kind, uid); socket != "" {
+			slog.Info("found compositor", "seat", c.seat, "compositor", kind, "socket", socket)
+			return &compositorTarget{kind, socket, uid}
+		}
+	}
+	return nil
+}
+
+// sessionUser returns the uid of the user whose session is active on the
+// seat, connecting to the system bus the first time.
+func (c *compositorBackend) sessionUser() (int, bool) {
+	if c.bus == nil {
+		conn, err := dbus.ConnectSystemBus()
+		if err != nil {
+			slog.Warn("cannot find the seat's session for the compositor", "seat", c.seat, "err", err)
+			return 0, false
+		}
+		c.bus = conn
+	}
+	return sessionUser(c.bus, c.seat)
+}
+
+func runtimeDir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return dir
+	}
+	return "/run/user/" + strconv.Itoa(os.Getuid())
+}
+
+// compositorSocket returns the IPC socket of uid's compositor of the given
+// kind, or "" if there is none. A user service has the session's
+// environment; a system service has to find the socket by looking.
+func compositorSocket(kind string, uid int) string {
+	if uid == os.Getuid() {
+		if sig := os.Getenv("HYPRLAND_INSTANCE_SIGNATURE"); kind == "hyprland" && sig != "" {
+			return filepath.Join(runtimeDir(), "hypr", sig, ".socket.sock")
+		}
+		if sock := os.Getenv("SWAYSOCK"); kind == "sway" && sock != "" {
+			return sock
+		}
+	}
+	dir := "/run/user/" + strconv.Itoa(uid)
+	if kind == "hyprland" {
+		return newestOwned(uid, dir+"/hypr/*/.socket.sock", "/tmp/hypr/*/.socket.sock")
+	}
+	return newestOwned(uid, dir+"/sway-ipc.*.sock")
+}
+
+// newestOwned returns the most recently modified file owned by uid matching
+// any pattern.
+func newestOwned(uid int, patterns ...string) string {
+	var best string
+	var bestTime time.Time
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil || fi.Sys().(*syscall.Stat_t).Uid != uint32(uid) {
+				continue
+			}
+			if fi.ModTime().After(bestTime) {
+				best, bestTime = m, fi.ModTime()
+			}
+		}
+	}
+	return best
+}
+
+// hyprlandDispatch is the equivalent of `hyprctl dispatch <cmd>`.
+func hyprlandDispatch(socket, cmd string) error {
+	conn, err := net.DialTimeout("unix", socket, compositorTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(compositorTimeout))
+
+	if _, err := conn.Write([]byte("dispatch " + cmd)); err != nil {
+		return err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(bytes.TrimSpace(reply), []byte("ok")) {
+		return fmt.Errorf("dispatch %s: %s", cmd, reply)
+	}
+	return nil
+}
+
+// swayCommand is the equivalent of `swaymsg <cmd>`, speaking the i3 IPC
+// protocol: magic, payload length, message type, payload.
+func swayCommand(socket, cmd string) error {
+	const ipcMagic = "i3-ipc"
+	const runCommand = 0
+
+	conn, err := net.DialTimeout("unix", socket, compositorTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(compositorTimeout))
+
+	var msg bytes.Buffer
+	msg.WriteString(ipcMagic)
+	binary.Write(&msg, binary.LittleEndian, uint32(len(cmd)))
+	binary.Write(&msg, binary.LittleEndian, uint32(runCommand))
+	msg.WriteString(cmd)
+	if _, err := conn.Write(msg.Bytes()); err != nil {
+		return err
+	}
+
+	header := make([]byte, len(ipcMagic)+8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	payload := make([]byte, binary.LittleEndian.Uint32(header[len(ipcMagic):]))
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return err
+	}
+	var results []struct {
+		Success bool   `json:"success"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &results); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if !r.Success {
+			return fmt.Errorf("%s: %s", cmd, r.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,565 @@
+// Package config reads the driver's JSON config file and defines the
+// profiles that shape how touch input feels.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"touchpad/device"
+)
+
+// The defaults of the profile settings.
+const (
+	MoveSensitivity  = 0.6
+	NaturalScrolling = true
+
+	MinMovePressure = 2
+
+	TapTimeout       = 200 * time.Millisecond
+	TapMovePercent   = 1.0
+	PressThreshold   = 140
+	ReleaseThreshold = 80
+
+	// With right_click_corner, a click or tap right of RightClickZoneLeft
+	// and below BottomZoneTop, as fractions of the pad's width and height,
+	// clicks right.
+	RightClickZoneLeft = 0.75
+	BottomZoneTop      = 0.75
+)
+
+// VirtualDeviceName is what the virtual devices are called unless
+// configured otherwise.
+const VirtualDeviceName = "Goodix-Driver"
+
+const DefaultPath = "/etc/touchpad2mouse/config.json"
+
+// AndroidConfigPath is the config file on Android, whose userspace has no
+// /etc. /data/adb is where root add-ons keep their files.
+const AndroidConfigPath = "/data/adb/touchpad2mouse/config.json"
+
+// Path returns the config file to use: a user service prefers
+// $XDG_CONFIG_HOME/touchpad2mouse/config.json when it exists.
+func Path() string {
+	if device.OnAndroid() {
+		return AndroidConfigPath
+	}
+	if os.Getuid() == 0 {
+		return DefaultPath
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return DefaultPath
+	}
+	path := filepath.Join(dir, "touchpad2mouse", "config.json")
+	if _, err := os.Stat(path); err != nil {
+		return DefaultPath
+	}
+	return path
+}
+
+// Config holds the settings read from the JSON config file. Any field left out
+// of the file keeps its value from Default.
+type Config struct {
+	// Profile is embedded so its keys sit at the top level of the file.
+	Profile
+
+	// KDEDefaults seeds the profile from Plasma's touchpad settings in the
+	// session user's kcminputrc. Values in this file still win.
+	KDEDefaults bool `json:"kde_defaults"`
+
+	// RunAsUser and RunAsGroup name the account the event loop runs as once
+	// the devices are open. An empty RunAsUser keeps the starting identity.
+	RunAsUser  string `json:"run_as_user"`
+	RunAsGroup string `json:"run_as_group"`
+	// ExtraGroups are the supplementary groups kept after dropping root.
+	// "input" is what reopening the touchpad and /dev/uinput requires.
+	ExtraGroups []string `json:"extra_groups"`
+	// CommandUser is the account hot zone and gesture commands run as when
+	// the driver starts as root. Empty means the user of the session active
+	// on the seat; commands only run as root when this names root.
+	CommandUser string `json:"command_user"`
+
+	// Sandbox applies a seccomp filter and a Landlock ruleset once setup is
+	// done, limiting the long-running loop to what it needs.
+	Sandbox bool `json:"sandbox"`
+
+	// Accessibility switches the profile defaults to the simplified ones of
+	// accessibleProfile, for users with tremor or limited dexterity. Values
+	// in this file still win.
+	Accessibility bool `json:"accessibility"`
+
+	// SessionUser restricts injection to times when this user's logind
+	// session is the active one on the seat. A user service defaults to its
+	// own user; a system service with no value follows any session.
+	SessionUser string `json:"session_user"`
+	// SessionReleaseGrab also releases the touchpad grab while paused, so
+	// the other session gets the pad through libinput.
+	SessionReleaseGrab bool `json:"session_release_grab"`
+
+	// ControlGroup names a group whose members may change the driver
+	// through the control socket; anyone else but root and the driver's
+	// own user may only read its state.
+	ControlGroup string `json:"control_group"`
+
+	// HTTPListen enables the localhost HTTP control API on this address,
+	// e.g. "127.0.0.1:7733". Only loopback addresses are accepted.
+	HTTPListen string `json:"http_listen"`
+
+	// GestureBackend selects how gestures are carried out: "keys" (default)
+	// sends key chords through the virtual device; "hyprland" and "sway"
+	// send commands over the compositor's IPC socket; "auto" uses whichever
+	// of those is running. Gestures the compositor can't take fall back to
+	// key chords.
+	GestureBackend string `json:"gesture_backend"`
+	// CompositorCommands overrides the IPC command per gesture name, e.g.
+	// {"swipe-up": "workspace e+1"}. An empty command means "use keys".
+	CompositorCommands map[string]string `json:"compositor_commands"`
+	// GestureActions binds gestures to actions that take precedence over
+	// both, e.g. {"swipe-right": "exec:playerctl next"}, which runs the
+	// command with sh -c.
+	GestureActions map[string]string `json:"gesture_actions"`
+
+	// Notifications posts desktop notifications when the touchpad is
+	// enabled or disabled, changes profile, or is lost and reconnected.
+	// Needs a session bus, so in practice a --user install.
+	Notifications bool `json:"notifications"`
+
+	// BatterySaver is "auto" (default: on while running on battery, as
+	// UPower reports), "on" or "off". Battery-saver mode sends pointer
+	// motion at a lower rate and lets timers coalesce.
+	BatterySaver string `json:"battery_saver"`
+
+	// MaxButtonHoldMs is how long a virtual button or key may stay down
+	// with no finger on the touchpad before it is taken to be stuck and
+	// released. 0 turns the failsafe off.
+	MaxButtonHoldMs int `json:"max_button_hold_ms"`
+
+	// IdleSuspendMs parks the failsafe and watchdog timers once no seat has
+	// had input for this long, or sooner once logind considers every seat
+	// idle, so an untouched driver causes no wakeups. 0 keeps them running.
+	IdleSuspendMs int `json:"idle_suspend_ms"`
+
+	// ResumeBlankMs ignores touches that start within this long of resume
+	// or the lid opening, when the pad is usually being palmed while the
+	// laptop is picked up. 0 turns it off.
+	ResumeBlankMs int `json:"resume_blank_ms"`
+
+	// VirtualDevice sets how the virtual devices identify themselves.
+	VirtualDevice VirtualDeviceConfig `json:"virtual_device"`
+
+	// DrawingAbsolute makes the "drawing" profile map the pad onto the
+	// screen rather than move a pointer.
+	DrawingAbsolute bool `json:"drawing_absolute"`
+
+	// Profiles defines named profiles of the user's own, such as "precise"
+	// or "fast", each as the settings it changes from the top-level ones.
+	// They are switched to like the built-in profiles.
+	Profiles map[string]json.RawMessage `json:"profiles"`
+
+	// ProfileCycle lists the profiles ProfileCycleGesture steps through,
+	// e.g. "five-finger-tap" or "swipe-up"; "" turns cycling off.
+	ProfileCycle        []string `json:"profile_cycle"`
+	ProfileCycleGesture string   `json:"profile_cycle_gesture"`
+
+	// Seats lists one entry per logind seat to drive. Each gets its own
+	// touchpad, virtual device and event loop. Empty means seat0 only.
+	Seats []SeatConfig `json:"seats"`
+}
+
+// SeatConfig describes one touchpad's translation pipeline. A seat may have
+// several, e.g. the internal pad and an external one; by default they share
+// the seat's virtual devices.
+type SeatConfig struct {
+	Seat string `json:"seat"`
+	// Name identifies the touchpad in control commands, metrics and
+	// notifications. It defaults to the seat, so it only needs setting for
+	// a second touchpad on one.
+	Name string `json:"name"`
+	// Device is the name keyword of this seat's touchpad. Left empty, the
+	// touchpad is found by its capabilities.
+	Device string `json:"device"`
+	// Pointer names the set of virtual devices this touchpad drives.
+	// Touchpads of a seat with the same pointer move the same cursor; a
+	// different name gets devices of its own, e.g. a second cursor for a
+	// wall display. It defaults to the seat.
+	Pointer string `json:"pointer"`
+	// Profile overrides settings of the top-level profile for this
+	// touchpad, e.g. {"sensitivity": 0.3} for a larger one.
+	Profile json.RawMessage `json:"profile"`
+	// SessionUser overrides the top-level session_user for this seat.
+	SessionUser string `json:"session_user"`
+}
+
+// VirtualDeviceConfig is the identity of the virtual devices, for libinput
+// quirks, xinput settings and remapper rules to match on. The keyboard gets
+// the same ids, with " Keyboard" appended to its name.
+type VirtualDeviceConfig struct {
+	Name    string   `json:"name"`
+	Bustype deviceID `json:"bustype"`
+	Vendor  deviceID `json:"vendor"`
+	Product deviceID `json:"product"`
+	Version deviceID `json:"version"`
+	// Properties are the pointer's input properties by name, e.g.
+	// "pointer" or "pointing_stick".
+	Properties []string `json:"properties"`
+}
+
+// deviceID is a 16-bit id that may be written as a number or, as ids
+// usually are, as a "0x" hex string.
+type deviceID uint16
+
+func (d *deviceID) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) != nil {
+		s = string(data)
+	}
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid device id %s", data)
+	}
+	*d = deviceID(n)
+	return nil
+}
+
+// inputProps maps property names to INPUT_PROP_* values.
+var inputProps = map[string]int{
+	"pointer":        device.INPUT_PROP_POINTER,
+	"direct":         device.INPUT_PROP_DIRECT,
+	"buttonpad":      0x02,
+	"semi_mt":        0x03,
+	"topbuttonpad":   0x04,
+	"pointing_stick": 0x05,
+	"accelerometer":  0x06,
+}
+
+// Props returns the configured input properties as INPUT_PROP_* values.
+func (c VirtualDeviceConfig) Props() ([]int, error) {
+	props := make([]int, 0, len(c.Properties))
+	for _, name := range c.Properties {
+		prop, ok := inputProps[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown input property %q", name)
+		}
+		props = append(props, prop)
+	}
+	return props, nil
+}
+
+// SeatList returns the configured seats with defaults filled in.
+func (c Config) SeatList() []SeatConfig {
+	if len(c.Seats) == 0 {
+		return []SeatConfig{{Seat: device.DefaultSeat, Name: device.DefaultSeat, Pointer: device.DefaultSeat}}
+	}
+	seats := make([]SeatConfig, len(c.Seats))
+	for i, sc := range c.Seats {
+		if sc.Seat == "" {
+			sc.Seat = device.DefaultSeat
+		}
+		if sc.Name == "" {
+			sc.Name = sc.Seat
+		}
+		if sc.Pointer == "" {
+			sc.Pointer = sc.Seat
+		}
+		seats[i] = sc
+	}
+	return seats
+}
+
+// Profile holds the settings that shape how touch input feels.
+type Profile struct {
+	MoveSensitivity  float64 `json:"sensitivity"`
+	NaturalScrolling bool    `json:"natural_scrolling"`
+	TapToClick       bool    `json:"tap_to_click"`
+	// Output is "relative" for a mouse-like pointer, "absolute" to map
+	// the pad onto the screen like a tablet, or "touchscreen" to pass the
+	// contacts on as a touchscreen.
+	Output string `json:"output"`
+
+	// DwellClickMs clicks DwellButton once the pointer has rested this
+	// long after moving; 0 turns dwell clicking off. DwellWarningMs before
+	// the click, a "dwell-warning" event goes out for OSDs.
+	DwellClickMs   int    `json:"dwell_click_ms"`
+	DwellButton    string `json:"dwell_button"`
+	DwellWarningMs int    `json:"dwell_warning_ms"`
+
+	// StickyDrag makes a one-finger tap press the left button and the next
+	// one release it, so drags need no sustained contact.
+	StickyDrag bool `json:"sticky_drag"`
+	// TapDrag holds a one-finger tap's left button for TapDragMs in case
+	// the finger comes down again, and drags while it then stays down.
+	// With TapDragLock the drag outlasts the lift until the next tap.
+	TapDrag     bool `json:"tap_drag"`
+	TapDragMs   int  `json:"tap_drag_ms"`
+	TapDragLock bool `json:"tap_drag_lock"`
+	// ThreeFingerDrag names the button three fingers moving together hold
+	// down, "left" or "middle" say, or "" for none. It stays held for
+	// ThreeFingerDragMs after they lift, for putting them down again.
+	ThreeFingerDrag   string `json:"three_finger_drag"`
+	ThreeFingerDragMs int    `json:"three_finger_drag_ms"`
+
+	// DisableWhileTyping ignores touches that land within TypingTimeoutMs
+	// of a key being typed, and holds the pointer still meanwhile, so a
+	// palm brushing the pad while typing does nothing.
+	DisableWhileTyping bool `json:"disable_while_typing"`
+	TypingTimeoutMs    int  `json:"typing_timeout_ms"`
+
+	// ScrollModeGesture names a swipe that toggles one-finger scrolling
+	// instead of doing what it normally would.
+	ScrollModeGesture string `json:"scroll_mode_gesture"`
+
+	// A touch shorter than TapTimeoutMs that moves less than
+	// TapMovePercent of the pad's width is a tap. TapMoveLimit, in
+	// touchpad units, takes precedence when set.
+	TapTimeoutMs   int     `json:"tap_timeout_ms"`
+	TapMovePercent float64 `json:"tap_move_percent"`
+	TapMoveLimit   float64 `json:"tap_move_limit"`
+	// Gestures enables three-finger swipes.
+	Gestures bool `json:"gestures"`
+	// FeedbackBeep sounds the PC speaker on taps, dwell clicks and
+	// gestures.
+	FeedbackBeep bool `json:"feedback_beep"`
+
+	// PalmRejection ignores touches that land hard at the top of the pad.
+	PalmRejection bool `json:"palm_rejection"`
+	// Accel is the pointer acceleration profile: "adaptive" slows slow
+	// movements and speeds up fast ones, "flat" keeps motion
+	// proportional, and "custom" follows AccelCurve, a list of [speed,
+	// factor] points with the speed in touchpad units per millisecond.
+	Accel      string       `json:"accel"`
+	AccelCurve [][2]float64 `json:"accel_curve"`
+	// Smoothing filters pointer motion against jitter: "one-euro"
+	// (default) or "off". The 1€ filter's SmoothingMinCutoff (Hz) is how
+	// much a finger held still is smoothed, lower meaning more, and
+	// SmoothingBeta how quickly that eases off as it speeds up.
+	Smoothing          string  `json:"smoothing"`
+	SmoothingMinCutoff float64 `json:"smoothing_min_cutoff"`
+	SmoothingBeta      float64 `json:"smoothing_beta"`
+	// LowLatency sends every frame's motion straight away, even in
+	// battery-saver mode, and skips per-frame bookkeeping such as the
+	// heatmap.
+	LowLatency bool `json:"low_latency"`
+
+	// Pressing harder than PressPressure clicks; the button is let go once
+	// the pressure drops below ReleasePressure.
+	PressPressure   int32 `json:"press_pressure"`
+	ReleasePressure int32 `json:"release_pressure"`
+	// A contact moves the pointer only while pressing at least
+	// MinMovePressure.
+	MinMovePressure int32 `json:"min_move_pressure"`
+	// Pressing harder than ForcePressPressure while clicking is a deep
+	// press, which does ForceAction: a button ("right", "middle") held
+	// until the pressure drops below ForceReleasePressure, or a key chord
+	// ("leftmeta+d"). 0 turns deep presses off.
+	ForcePressPressure   int32  `json:"force_press_pressure"`
+	ForceReleasePressure int32  `json:"force_release_pressure"`
+	ForceAction          string `json:"force_action"`
+	// AdaptivePressure learns the three thresholds above from the
+	// pressures of taps and clicks, keeping PressPressure between
+	// PressPressureMin and PressPressureMax; the configured values are
+	// where it starts.
+	AdaptivePressure bool  `json:"adaptive_pressure"`
+	PressPressureMin int32 `json:"press_pressure_min"`
+	PressPressureMax int32 `json:"press_pressure_max"`
+
+	// Keypad turns the pad into a grid of keys instead of a pointer.
+	// KeypadLayout names the keys row by row, top row first; holding a
+	// key for KeypadRepeatMs repeats it, and 0 turns repeating off.
+	Keypad         bool       `json:"keypad"`
+	KeypadLayout   [][]string `json:"keypad_layout"`
+	KeypadRepeatMs int        `json:"keypad_repeat_ms"`
+
+	// BrightnessStrip is the height, as a fraction of the pad, of a strip
+	// along the top edge where a one-finger horizontal drag changes the
+	// screen brightness instead of moving the pointer; 0 turns it off. A
+	// drag across the whole width sends BrightnessSteps key presses.
+	BrightnessStrip float64 `json:"brightness_strip"`
+	BrightnessSteps int     `json:"brightness_steps"`
+
+	// Trackball keeps the pointer moving after a flick, like a spun
+	// trackball, until it slows to a stop or a finger rests on the pad.
+	// TrackballFriction is how quickly it slows: the speed drops by a
+	// factor of e every 1/TrackballFriction seconds.
+	Trackball         bool    `json:"trackball"`
+	TrackballFriction float64 `json:"trackball_friction"`
+
+	// KineticScroll keeps a two-finger scroll going after a quick swipe
+	// lifts off, slowing down with KineticScrollFriction like the
+	// trackball, until it stops or a finger touches the pad.
+	KineticScroll         bool    `json:"kinetic_scroll"`
+	KineticScrollFriction float64 `json:"kinetic_scroll_friction"`
+
+	// HotZones are soft buttons: tapping or pressing inside one does what
+	// it says instead of clicking.
+	HotZones []HotZone `json:"hot_zones"`
+	// RightClickCorner makes the bottom right corner a zone that clicks
+	// right, after any of HotZones.
+	RightClickCorner bool `json:"right_click_corner"`
+
+	// RotateWithScreen turns motion to match the screen orientation, for
+	// the built-in pad of a convertible. The orientation comes from
+	// iio-sensor-proxy, or from the "orientation" control command.
+	RotateWithScreen bool `json:"rotate_with_screen"`
+
+	// BackForward sends the back and forward mouse buttons on a quick
+	// horizontal "two-finger-flick", or a one-finger "edge-swipe" in from
+	// the side of the pad; "" turns it off.
+	BackForward string `json:"back_forward"`
+}
+
+func (p Profile) TapTimeout() time.Duration {
+	return time.Duration(p.TapTimeoutMs) * time.Millisecond
+}
+
+// TapMoveDistance is how far, in units of the touchpad info describes, a
+// touch may move and still be a tap.
+func (p Profile) TapMoveDistance(info device.Info) float64 {
+	if p.TapMoveLimit > 0 {
+		return p.TapMoveLimit
+	}
+	return p.TapMovePercent / 100 * info.Width()
+}
+
+func (p Profile) TypingTimeout() time.Duration {
+	return time.Duration(p.TypingTimeoutMs) * time.Millisecond
+}
+
+// accessibleProfile makes p forgiving: taps may be slow and shaky, swipes
+// can't be triggered by accident, and actions are confirmed by a beep.
+func accessibleProfile(p *Profile) {
+	p.TapTimeoutMs = 3 * int(TapTimeout/time.Millisecond)
+	p.TapMovePercent = 3 * TapMovePercent
+	p.Gestures = false
+	p.FeedbackBeep = true
+}
+
+// Output modes of a profile.
+const (
+	OutputRelative    = "relative"
+	OutputAbsolute    = "absolute"
+	OutputTouchscreen = "touchscreen"
+)
+
+// DefaultProfile returns the profile settings a config file starts from.
+func DefaultProfile() Profile {
+	return Profile{
+		MoveSensitivity:   MoveSensitivity,
+		NaturalScrolling:  NaturalScrolling,
+		TapToClick:        true,
+		RightClickCorner:  true,
+		Output:            OutputRelative,
+		DwellButton:       "left",
+		TapTimeoutMs:      int(TapTimeout / time.Millisecond),
+		TapMovePercent:    TapMovePercent,
+		Gestures:          true,
+		PalmRejection:     true,
+		Accel:             AccelAdaptive,
+		PressPressure:     PressThreshold,
+		ReleasePressure:   ReleaseThreshold,
+		MinMovePressure:   MinMovePressure,
+		ForceAction:       "right",
+		PressPressureMin:  PressThreshold * 2 / 3,
+		PressPressureMax:  PressThreshold * 3 / 2,
+		KeypadLayout:      numpadLayout(),
+		BrightnessSteps:   20,
+		TrackballFriction: 2.5,
+
+		// A scroll coasts to a stop sooner than the pointer.
+		KineticScrollFriction: 3,
+
+		TapDragMs:         300,
+		ThreeFingerDragMs: 500,
+
+		Smoothing:          SmoothingOneEuro,
+		SmoothingMinCutoff: 4,
+		SmoothingBeta:      0.01,
+
+		DisableWhileTyping: true,
+		TypingTimeoutMs:    300,
+	}
+}
+
+// Default returns the config used for anything the file leaves out.
+func Default() Config {
+	cfg := Config{
+		Profile:      DefaultProfile(),
+		KDEDefaults:  true,
+		RunAsUser:    "nobody",
+		ExtraGroups:  []string{"input"},
+		Sandbox:      true,
+		BatterySaver: "auto",
+
+		MaxButtonHoldMs: 10000,
+		IdleSuspendMs:   30000,
+		ResumeBlankMs:   500,
+
+		ProfileCycle:        []string{DefaultProfileName, "drawing", "gaming"},
+		ProfileCycleGesture: "five-finger-tap",
+		VirtualDevice: VirtualDeviceConfig{
+			Name:       VirtualDeviceName,
+			Bustype:    0x03,
+			Vendor:     0x1234,
+			Product:    0x5678,
+			Version:    1,
+			Properties: []string{"pointer"},
+		},
+	}
+	if device.OnAndroid() {
+		// Without cgo there is no user database to look names up in, and
+		// the input group is Android's own.
+		cfg.RunAsUser, cfg.ExtraGroups = "", nil
+	}
+	return cfg
+}
+
+// Load reads path on top of the defaults. A missing file is not an error;
+// the defaults are returned as-is.
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		data, err = []byte("{}"), nil
+	}
+	if err != nil {
+		return Default(), err
+	}
+
+	// The file says whose KDE settings to read and whether to start from
+	// the accessible profile, so it is parsed once to find out and then
+	// again on top of the adjusted defaults.
+	cfg := Default()
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if !cfg.KDEDefaults && !cfg.Accessibility {
+		return cfg, nil
+	}
+	seeded := Default()
+	if cfg.KDEDefaults && applyKDESettings(&seeded.Profile, cfg.SessionOwner(os.Getuid()), cfg.SeatList()[0].Device) {
+		slog.Info("using KDE touchpad settings as defaults")
+	}
+	if cfg.Accessibility {
+		accessibleProfile(&seeded.Profile)
+	}
+	json.Unmarshal(data, &seeded)
+	return seeded, nil
+}
+
+// SessionOwner returns the user whose session the driver serves: the
+// configured session_user, or the invoking user when not running as root.
+// An empty result means any session on the seat is acceptable.
+func (c Config) SessionOwner(uid int) string {
+	if c.SessionUser != "" || uid == 0 {
+		return c.SessionUser
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
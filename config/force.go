@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ForceAction is what a deep press does: hold a mouse button for as long as
+// it lasts, or send a key chord once.
+type ForceAction struct {
+	Button uint16
+	Chord  []uint16
+}
+
+// ParseForceAction reads a button name ("right", "middle", "left") or a key
+// chord of key names joined by "+", such as "leftmeta+d".
+func ParseForceAction(s string) (ForceAction, error) {
+	if code, ok := ButtonCode(s); ok {
+		return ForceAction{Button: code}, nil
+	}
+	var a ForceAction
+	for _, name := range strings.Split(s, "+") {
+		code, ok := KeyNames[name]
+		if !ok {
+			return ForceAction{}, fmt.Errorf("unknown force_action %q: no key %q", s, name)
+		}
+		a.Chord = append(a.Chord, code)
+	}
+	return a, nil
+}
+
+// ForceReleaseLevel is where a deep press ends: ForceReleasePressure, or
+// if unset, 85% of ForcePressPressure, so the press can't flicker on and off
+// around a single level.
+func (p Profile) ForceReleaseLevel() int32 {
+	if p.ForceReleasePressure > 0 {
+		return p.ForceReleasePressure
+	}
+	return p.ForcePressPressure * 85 / 100
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"touchpad/device"
+)
+
+// ExecActionPrefix starts an action that runs a shell command.
+const ExecActionPrefix = "exec:"
+
+// HotZone is a rectangle on the pad with its own actions: Tap for a tap
+// inside it and Press for clicking the pad down inside it. Its bounds are
+// fractions of the pad's width and height, from the top left.
+type HotZone struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"w"`
+	Height float64 `json:"h"`
+	// Fingers restricts the zone to touches with that many fingers; 0
+	// takes any.
+	Fingers int `json:"fingers"`
+	// Tap and Press are each a button ("right", "middle"...), a key chord
+	// such as "leftctrl+c", or "exec:" and a shell command. A tap clicks a
+	// button only with tap_to_click on, and unless Fingers is set, only a
+	// one-finger tap; two and three fingers click as anywhere else.
+	Tap   string `json:"tap"`
+	Press string `json:"press"`
+	// Command is short for a Tap of "exec:" and the command.
+	Command string `json:"command"`
+}
+
+// RightClickCorner is the bottom right corner that clicks right, by tap or
+// by press, with right_click_corner on.
+var RightClickCorner = HotZone{
+	X: RightClickZoneLeft, Y: BottomZoneTop, Width: 1 - RightClickZoneLeft, Height: 1 - BottomZoneTop,
+	Tap: "right", Press: "right",
+}
+
+// Zones returns p's hot zones, then the right-click corner if it is on, so
+// a zone of its own over the corner comes first.
+func (p Profile) Zones() []HotZone {
+	if !p.RightClickCorner {
+		return p.HotZones
+	}
+	return append(p.HotZones[:len(p.HotZones):len(p.HotZones)], RightClickCorner)
+}
+
+// ZoneAction is what a zone does: a button clicked or held, a key chord
+// sent, or a command run.
+type ZoneAction struct {
+	ForceAction
+	Command string
+}
+
+// ParseZoneAction reads an action of a zone: "exec:" and a command, or what
+// ParseForceAction takes.
+func ParseZoneAction(s string) (ZoneAction, error) {
+	if command, ok := strings.CutPrefix(s, ExecActionPrefix); ok {
+		if strings.TrimSpace(command) == "" {
+			return ZoneAction{}, fmt.Errorf("empty command")
+		}
+		return ZoneAction{Command: command}, nil
+	}
+	a, err := ParseForceAction(s)
+	return ZoneAction{ForceAction: a}, err
+}
+
+// TapAction returns the zone's tap action as written, "" if it has none.
+func (z HotZone) TapAction() string {
+	if z.Tap == "" && z.Command != "" {
+		return ExecActionPrefix + z.Command
+	}
+	return z.Tap
+}
+
+func (z HotZone) validate() error {
+	if z.TapAction() == "" && z.Press == "" {
+		return fmt.Errorf("hot zone without a tap or press action")
+	}
+	name := z.TapAction()
+	if name == "" {
+		name = z.Press
+	}
+	if z.Width <= 0 || z.Height <= 0 || z.X < 0 || z.Y < 0 || z.X+z.Width > 1 || z.Y+z.Height > 1 {
+		return fmt.Errorf("hot zone for %q is not within the pad (0 to 1)", name)
+	}
+	for _, action := range []string{z.TapAction(), z.Press} {
+		if action == "" {
+			continue
+		}
+		if _, err := ParseZoneAction(action); err != nil {
+			return fmt.Errorf("hot zone for %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Describe says what the zone does, for inspect.
+func (z HotZone) Describe() string {
+	var parts []string
+	if tap := z.TapAction(); tap != "" {
+		parts = append(parts, "tap "+tap)
+	}
+	if z.Press != "" {
+		parts = append(parts, "press "+z.Press)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Contains reports whether a touch with fingers at x, y is in the zone.
+func (z HotZone) Contains(x, y int32, fingers int, info device.Info) bool {
+	if z.Fingers != 0 && z.Fingers != fingers {
+		return false
+	}
+	return x >= info.PadX(z.X) && x <= info.PadX(z.X+z.Width) &&
+		y >= info.PadY(z.Y) && y <= info.PadY(z.Y+z.Height)
+}
+
+// HasZoneCommand reports whether any zone of profiles runs a command.
+func HasZoneCommand(profiles []NamedProfile) bool {
+	for _, p := range profiles {
+		for _, z := range p.HotZones {
+			for _, action := range []string{z.TapAction(), z.Press} {
+				if strings.HasPrefix(action, ExecActionPrefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// applyKDESettings seeds p from the touchpad section of owner's kcminputrc,
+// where Plasma's System Settings stores libinput options per device:
+//
+//	[Libinput][1267][12608][GXTP7863:00 27C6:01E0 Touchpad]
+//	NaturalScroll=true
+//	PointerAcceleration=0.2
+//	TapToClick=true
+//
+// It reports whether a matching section was found.
+func applyKDESettings(p *Profile, owner, keyword string) bool {
+	if owner == "" {
+		return false
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return false
+	}
+	f, err := os.Open(filepath.Join(u.HomeDir, ".config", "kcminputrc"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	// Prefer the section for our touchpad; settle for any touchpad.
+	var best, fallback map[string]string
+	var section map[string]string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			section = nil
+			lower := strings.ToLower(line)
+			if !strings.HasPrefix(lower, "[libinput]") || !strings.Contains(lower, "touchpad") {
+				continue
+			}
+			section = make(map[string]string)
+			if strings.Contains(lower, strings.ToLower(keyword)) && best == nil {
+				best = section
+			} else if fallback == nil {
+				fallback = section
+			}
+			continue
+		}
+		if key, val, ok := strings.Cut(line, "="); ok && section != nil {
+			section[key] = val
+		}
+	}
+	if best == nil {
+		best = fallback
+	}
+	if best == nil {
+		return false
+	}
+
+	if v, err := strconv.ParseBool(best["NaturalScroll"]); err == nil {
+		p.NaturalScrolling = v
+	}
+	if v, err := strconv.ParseBool(best["TapToClick"]); err == nil {
+		p.TapToClick = v
+	}
+	// libinput speed runs from -1 to 1 around a neutral 0; map it onto a
+	// factor of 0.5x to 2x of our own default sensitivity.
+	if v, err := strconv.ParseFloat(best["PointerAcceleration"], 64); err == nil {
+		p.MoveSensitivity = MoveSensitivity * math.Pow(2, math.Max(-1, math.Min(1, v)))
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"touchpad/device"
+)
+
+// KeyNames maps the key names keypad layouts and actions may use to key
+// codes. The virtual keyboard declares all of them.
+var KeyNames = map[string]uint16{
+	"kp0": 82, "kp1": 79, "kp2": 80, "kp3": 81, "kp4": 75,
+	"kp5": 76, "kp6": 77, "kp7": 71, "kp8": 72, "kp9": 73,
+	"kpdot": 83, "kpcomma": 121, "kpenter": 96, "kpplus": 78, "kpminus": 74,
+	"kpasterisk": 55, "kpslash": 98, "kpequal": 117, "numlock": 69,
+	"0": 11, "1": 2, "2": 3, "3": 4, "4": 5, "5": 6, "6": 7, "7": 8, "8": 9, "9": 10,
+	"minus": 12, "equal": 13, "dot": 52, "comma": 51,
+	"esc": 1, "backspace": 14, "tab": device.KEY_TAB, "enter": 28, "space": 57,
+	"left": device.KEY_LEFT, "right": device.KEY_RIGHT, "up": 103, "down": 108,
+	"mute": 113, "volumedown": 114, "volumeup": 115,
+	"brightnessdown": 224, "brightnessup": 225,
+	"leftctrl": device.KEY_LEFTCTRL, "leftshift": device.KEY_LEFTSHIFT, "leftalt": device.KEY_LEFTALT, "leftmeta": device.KEY_LEFTMETA,
+	"q": 16, "w": 17, "e": 18, "r": 19, "t": 20, "y": 21, "u": 22, "i": 23, "o": 24, "p": 25,
+	"a": 30, "s": 31, "d": device.KEY_D, "f": 33, "g": 34, "h": 35, "j": 36, "k": 37, "l": 38,
+	"z": 44, "x": 45, "c": 46, "v": 47, "b": 48, "n": 49, "m": 50,
+}
+
+// NamedKeys returns every code in KeyNames.
+func NamedKeys() []int {
+	var keys []int
+	for _, code := range slices.Sorted(maps.Values(KeyNames)) {
+		keys = append(keys, int(code))
+	}
+	return keys
+}
+
+// numpadLayout returns the default keypad: a numeric keypad, rows top to
+// bottom.
+func numpadLayout() [][]string {
+	return [][]string{
+		{"kp7", "kp8", "kp9", "kpslash"},
+		{"kp4", "kp5", "kp6", "kpasterisk"},
+		{"kp1", "kp2", "kp3", "kpminus"},
+		{"kp0", "kpdot", "kpenter", "kpplus"},
+	}
+}
+
+// ParseKeypadLayout turns a layout's key names into codes. An empty name
+// leaves that cell without a key.
+func ParseKeypadLayout(layout [][]string) ([][]uint16, error) {
+	if len(layout) == 0 {
+		return nil, fmt.Errorf("empty keypad_layout")
+	}
+	grid := make([][]uint16, len(layout))
+	for r, row := range layout {
+		if len(row) == 0 {
+			return nil, fmt.Errorf("keypad_layout row %d is empty", r+1)
+		}
+		grid[r] = make([]uint16, len(row))
+		for c, name := range row {
+			if name == "" {
+				continue
+			}
+			code, ok := KeyNames[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown key %q in keypad_layout", name)
+			}
+			grid[r][c] = code
+		}
+	}
+	return grid, nil
+}
+
+// ButtonName is the name actions and events use for a mouse button, "" for
+// any other code.
+func ButtonName(code uint16) string {
+	switch code {
+	case device.BTN_LEFT:
+		return "left"
+	case device.BTN_RIGHT:
+		return "right"
+	case device.BTN_MIDDLE:
+		return "middle"
+	case device.BTN_SIDE:
+		return "back"
+	case device.BTN_EXTRA:
+		return "forward"
+	}
+	return ""
+}
+
+// ButtonCode is the inverse of ButtonName.
+func ButtonCode(name string) (uint16, bool) {
+	for _, code := range []uint16{device.BTN_LEFT, device.BTN_RIGHT, device.BTN_MIDDLE, device.BTN_SIDE, device.BTN_EXTRA} {
+		if ButtonName(code) == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,79 @@
+package config
+
+import "fmt"
+
+// Acceleration profiles, for accel.
+const (
+	// AccelDefault is what configurations from before the profiles had;
+	// it is taken as adaptive.
+	AccelDefault  = "default"
+	AccelFlat     = "flat"
+	AccelAdaptive = "adaptive"
+	AccelCustom   = "custom"
+)
+
+// validAccel checks p's acceleration profile and, for a custom one, its
+// curve.
+func validAccel(p Profile) error {
+	switch p.Accel {
+	case AccelDefault, AccelFlat, AccelAdaptive:
+		return nil
+	case AccelCustom:
+	default:
+		return fmt.Errorf("unknown accel %q", p.Accel)
+	}
+	if len(p.AccelCurve) < 2 {
+		return fmt.Errorf("accel_curve needs at least two points")
+	}
+	for i, pt := range p.AccelCurve {
+		if pt[0] < 0 || pt[1] < 0 {
+			return fmt.Errorf("accel_curve: point %d is negative", i)
+		}
+		if i > 0 && pt[0] <= p.AccelCurve[i-1][0] {
+			return fmt.Errorf("accel_curve: speeds must increase, not %g after %g", pt[0], p.AccelCurve[i-1][0])
+		}
+	}
+	return nil
+}
+
+// AccelName is the acceleration profile p uses, as the status shows it.
+func AccelName(p Profile) string {
+	if p.Accel == AccelDefault {
+		return AccelAdaptive
+	}
+	return p.Accel
+}
+
+// Smoothing filters, for smoothing.
+const (
+	SmoothingOff     = "off"
+	SmoothingOneEuro = "one-euro"
+)
+
+func validSmoothing(p Profile) error {
+	switch p.Smoothing {
+	case SmoothingOff:
+		return nil
+	case SmoothingOneEuro:
+		if p.SmoothingMinCutoff <= 0 || p.SmoothingBeta < 0 {
+			return fmt.Errorf("smoothing_min_cutoff must be positive and smoothing_beta not negative")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown smoothing %q", p.Smoothing)
+}
+
+// Ways of sending the back and forward buttons, for back_forward.
+const (
+	BackForwardOff       = ""
+	BackForwardFlick     = "two-finger-flick"
+	BackForwardEdgeSwipe = "edge-swipe"
+)
+
+func validBackForward(mode string) error {
+	switch mode {
+	case BackForwardOff, BackForwardFlick, BackForwardEdgeSwipe:
+		return nil
+	}
+	return fmt.Errorf("unknown back_forward %q", mode)
+}
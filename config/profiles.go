@@ -0,0 +1,229 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// DefaultProfileName is what the profile from the top level of the config
+// is called.
+const DefaultProfileName = "default"
+
+// NamedProfile is a profile the driver can switch to at runtime.
+type NamedProfile struct {
+	Name string
+	Profile
+}
+
+// builtinProfiles returns the profiles every seat offers: the configured one,
+// and the special-purpose ones derived from it.
+func builtinProfiles(cfg Config) []NamedProfile {
+	base := cfg.Profile
+	return []NamedProfile{
+		{DefaultProfileName, base},
+		{"gaming", gamingProfile(base)},
+		{"drawing", drawingProfile(base, cfg.DrawingAbsolute)},
+		{"keypad", keypadProfile(base)},
+		{"trackball", trackballProfile(base)},
+	}
+}
+
+// configProfiles returns every profile cfg offers: the built-in ones, then
+// those of "profiles" in name order, each the top-level profile with its own
+// settings applied.
+func configProfiles(cfg Config) ([]NamedProfile, error) {
+	profiles := builtinProfiles(cfg)
+	for _, name := range slices.Sorted(maps.Keys(cfg.Profiles)) {
+		if slices.ContainsFunc(profiles, func(p NamedProfile) bool { return p.Name == name }) {
+			return nil, fmt.Errorf("profiles: %q is a built-in profile", name)
+		}
+		p, err := overrideProfile(cfg, cfg.Profiles[name])
+		if err != nil {
+			return nil, fmt.Errorf("profiles: %s: %w", name, err)
+		}
+		profiles = append(profiles, NamedProfile{name, p})
+	}
+	return profiles, nil
+}
+
+// SeatProfiles returns the profiles for the touchpad of sc: those of the
+// config, based on the top-level profile with sc's overrides applied.
+func SeatProfiles(cfg Config, sc SeatConfig) ([]NamedProfile, error) {
+	if len(sc.Profile) == 0 {
+		return configProfiles(cfg)
+	}
+	base, err := overrideProfile(cfg, sc.Profile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: profile: %w", sc.Name, err)
+	}
+	cfg.Profile = base
+	return configProfiles(cfg)
+}
+
+// overrideProfile returns cfg's top-level profile with the settings in data
+// applied over it.
+func overrideProfile(cfg Config, data json.RawMessage) (Profile, error) {
+	// Decoding into a slice reuses its array, which cfg still refers to.
+	p := cfg.Profile
+	p.KeypadLayout, p.HotZones, p.AccelCurve = nil, nil, nil
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, err
+	}
+	if p.KeypadLayout == nil {
+		p.KeypadLayout = cfg.KeypadLayout
+	}
+	if p.HotZones == nil {
+		p.HotZones = cfg.HotZones
+	}
+	if p.AccelCurve == nil {
+		p.AccelCurve = cfg.AccelCurve
+	}
+	return p, nil
+}
+
+// cycleGestures are the gestures profile_cycle_gesture may name.
+var cycleGestures = []string{"four-finger-tap", "five-finger-tap", "swipe-left", "swipe-right", "swipe-up", "swipe-down",
+	"four-finger-swipe-left", "four-finger-swipe-right", "four-finger-swipe-up", "four-finger-swipe-down"}
+
+// TapGesture names a tap with that many fingers, for taps that are
+// gestures rather than clicks.
+func TapGesture(fingers int) string {
+	switch fingers {
+	case 4:
+		return "four-finger-tap"
+	case 5:
+		return "five-finger-tap"
+	}
+	return ""
+}
+
+// CheckProfileCycle makes sure cfg's profiles are sound, and that every
+// profile in its cycle exists and its gesture is one there is.
+func CheckProfileCycle(cfg Config) error {
+	profiles, err := configProfiles(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.ProfileCycleGesture == "" {
+		return nil
+	}
+	if !slices.Contains(cycleGestures, cfg.ProfileCycleGesture) {
+		return fmt.Errorf("unknown profile_cycle_gesture %q", cfg.ProfileCycleGesture)
+	}
+	for _, name := range cfg.ProfileCycle {
+		if !slices.ContainsFunc(profiles, func(p NamedProfile) bool { return p.Name == name }) {
+			return fmt.Errorf("profile_cycle: unknown profile %q", name)
+		}
+	}
+	return nil
+}
+
+// gamingProfile strips base down to plain, predictable pointer motion with
+// as little work per frame as possible: no taps, gestures or palm rejection,
+// flat acceleration and no battery-saver decimation.
+func gamingProfile(base Profile) Profile {
+	p := base
+	p.TapToClick = false
+	p.Gestures = false
+	p.PalmRejection = false
+	p.Accel = AccelFlat
+	p.LowLatency = true
+	// Games steer with the keys and aim with the pad at the same time.
+	p.DisableWhileTyping = false
+	p.DwellClickMs = 0
+	p.StickyDrag = false
+	p.ScrollModeGesture = ""
+	p.HotZones = nil
+	return p
+}
+
+// drawingProfile is for annotations and signatures: slow, unaccelerated
+// motion, and a light press that starts a stroke and ends it as soon as the
+// finger eases off. With absolute, the pad maps onto the screen instead.
+func drawingProfile(base Profile, absolute bool) Profile {
+	p := base
+	p.MoveSensitivity = base.MoveSensitivity / 3
+	p.Accel = AccelFlat
+	p.TapToClick = false
+	p.Gestures = false
+	p.DwellClickMs = 0
+	p.StickyDrag = false
+	p.ScrollModeGesture = ""
+	p.HotZones = nil
+	p.PressPressure = base.PressPressure / 2
+	p.ReleasePressure = base.PressPressure * 2 / 5
+	p.AdaptivePressure = false
+	if absolute {
+		p.Output = OutputAbsolute
+	}
+	return p
+}
+
+// keypadProfile makes the pad a grid of keys, a numeric keypad unless
+// keypad_layout says otherwise. Taps press keys, so they don't click, and
+// nothing else competes for the touch.
+func keypadProfile(base Profile) Profile {
+	p := base
+	p.Keypad = true
+	p.TapToClick = false
+	p.Gestures = false
+	p.DwellClickMs = 0
+	p.StickyDrag = false
+	p.ScrollModeGesture = ""
+	p.HotZones = nil
+	return p
+}
+
+// trackballProfile gives the pointer inertia, for crossing large
+// multi-monitor desktops with a flick.
+func trackballProfile(base Profile) Profile {
+	p := base
+	p.Trackball = true
+	return p
+}
+
+// CheckProfiles validates profiles and returns the outputs they use.
+func CheckProfiles(profiles []NamedProfile) (map[string]bool, error) {
+	outputs := make(map[string]bool)
+	for _, p := range profiles {
+		if _, ok := ButtonCode(p.DwellButton); !ok && p.DwellClickMs > 0 {
+			return nil, fmt.Errorf("profile %s: unknown dwell_button %q", p.Name, p.DwellButton)
+		}
+		if _, ok := ButtonCode(p.ThreeFingerDrag); !ok && p.ThreeFingerDrag != "" {
+			return nil, fmt.Errorf("profile %s: unknown three_finger_drag button %q", p.Name, p.ThreeFingerDrag)
+		}
+		if p.ForcePressPressure > 0 {
+			if _, err := ParseForceAction(p.ForceAction); err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+		}
+		if err := validAccel(p.Profile); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+		if err := validSmoothing(p.Profile); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+		if err := validBackForward(p.BackForward); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+		}
+		for _, z := range p.HotZones {
+			if err := z.validate(); err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+		}
+		if p.Keypad {
+			if _, err := ParseKeypadLayout(p.KeypadLayout); err != nil {
+				return nil, fmt.Errorf("profile %s: %w", p.Name, err)
+			}
+		}
+		switch p.Output {
+		case OutputRelative, OutputAbsolute, OutputTouchscreen:
+			outputs[p.Output] = true
+		default:
+			return nil, fmt.Errorf("profile %s: unknown output %q", p.Name, p.Output)
+		}
+	}
+	return outputs, nil
+}
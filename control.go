@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"touchpad/config"
+	"touchpad/device"
+	"touchpad/engine"
+	"touchpad/uinput"
+)
+
+const ControlSocketPath = "/run/touchpad2mouse.sock"
+
+// controlSocketPath is ControlSocketPath for the system service and the same
+// name in $XDG_RUNTIME_DIR for a user service; Android has a path of its own.
+func controlSocketPath() string {
+	if device.OnAndroid() {
+		return AndroidControlSocketPath
+	}
+	if os.Getuid() == 0 {
+		return ControlSocketPath
+	}
+	return filepath.Join(runtimeDir(), filepath.Base(ControlSocketPath))
+}
+
+// Control protocol: newline-delimited JSON. Each request is an object with a
+// "cmd" field and an optional "seat" (default: the first configured seat);
+// each reply is a controlResponse. The "follow" command keeps the connection
+// open and streams one reply per status change; "events" likewise streams the
+// processed-event feed as one StreamEvent per line.
+type controlRequest struct {
+	Cmd  string `json:"cmd"`
+	Seat string `json:"seat,omitempty"`
+	// Name is the argument of commands that take one, such as the profile
+	// for "profile" or the setting for "set".
+	Name string `json:"name,omitempty"`
+	// Value is the new value, as in the config file, for "set".
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type controlResponse struct {
+	OK     bool    `json:"ok"`
+	Error  string  `json:"error,omitempty"`
+	Status *Status `json:"status,omitempty"`
+	// Latency answers the "latency" command.
+	Latency *uinput.LatencySummary `json:"latency,omitempty"`
+	// Device answers the "device" command.
+	Device *device.Info `json:"device,omitempty"`
+	// Stats answers the "stats" command.
+	Stats *SessionStats `json:"stats,omitempty"`
+	// Heatmap answers the "heatmap" command.
+	Heatmap *Heatmap `json:"heatmap,omitempty"`
+	// Pressure answers the "pressure" command.
+	Pressure *engine.PressureThresholds `json:"pressure,omitempty"`
+	// Settings answers the "settings" command with the current profile.
+	Settings *config.Profile `json:"settings,omitempty"`
+}
+
+// publicCommands are the control commands anyone who can reach the socket
+// may use: the status, once or as it changes, which is what status bars
+// show. The rest change the driver, or report on the touch itself, such as
+// the contacts of the event feed, which could give away a PIN typed in
+// keypad mode or a signature.
+var publicCommands = []string{"status", "follow"}
+
+// controlAccess says who may change the driver, and see more than its
+// status, through the socket: root, the user the driver was started as, and
+// members of its control group.
+type controlAccess struct {
+	owner int
+	// gid is the control group's, or -1 without one.
+	gid int
+}
+
+func newControlAccess(group string) controlAccess {
+	a := controlAccess{owner: os.Getuid(), gid: -1}
+	if group == "" {
+		return a
+	}
+	g, err := user.LookupGroup(group)
+	if err == nil {
+		a.gid, err = strconv.Atoi(g.Gid)
+	}
+	if err != nil {
+		slog.Warn("control group unavailable; only root and the driver's user may change it", "group", group, "err", err)
+		a.gid = -1
+	}
+	return a
+}
+
+// mayChange reports whether the process at the other end of conn may use
+// commands that change the driver, going by its credentials as of connecting.
+func (a controlAccess) mayChange(conn net.Conn) bool {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return false
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var cred *unix.Ucred
+	var groups []uint32
+	raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+		if err == nil && a.gid >= 0 {
+			groups = peerGroups(int(fd))
+		}
+	})
+	if err != nil {
+		return false
+	}
+	return a.allows(cred.Uid, cred.Gid, groups)
+}
+
+// allows reports whether a process running as uid, with primary group gid
+// and the supplementary groups given, may change the driver.
+func (a controlAccess) allows(uid, gid uint32, groups []uint32) bool {
+	if uid == 0 || int(uid) == a.owner {
+		return true
+	}
+	return a.gid >= 0 && (int(gid) == a.gid || slices.Contains(groups, uint32(a.gid)))
+}
+
+// mayChangeAs is mayChange for a caller known only by its uid, such as one
+// on D-Bus; its groups are those the user database gives it.
+func (a controlAccess) mayChangeAs(uid uint32) bool {
+	if uid == 0 || int(uid) == a.owner {
+		return true
+	}
+	if a.gid < 0 {
+		return false
+	}
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return false
+	}
+	gid := strconv.Itoa(a.gid)
+	if u.Gid == gid {
+		return true
+	}
+	ids, _ := u.GroupIds()
+	return slices.Contains(ids, gid)
+}
+
+// peerGroups returns the supplementary groups of the process at the other
+// end of the socket fd (SO_PEERGROUPS), or nil on kernels before 4.13.
+func peerGroups(fd int) []uint32 {
+	groups := make([]uint32, 32)
+	for {
+		size := uint32(len(groups) * 4)
+		_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.SOL_SOCKET, unix.SO_PEERGROUPS,
+			uintptr(unsafe.Pointer(&groups[0])), uintptr(unsafe.Pointer(&size)), 0)
+		switch errno {
+		case 0:
+			return groups[:size/4]
+		case unix.ERANGE:
+			groups = make([]uint32, size/4)
+		default:
+			return nil
+		}
+	}
+}
+
+// serveControl listens on the socket at path; access says who may change the
+// driver through it.
+func serveControl(path string, seats []*seatInstance, access controlAccess) (net.Listener, error) {
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", path, err)
+	}
+	// Status bars run as the desktop user, not as root. What they may do
+	// is checked per connection.
+	if err := os.Chmod(path, 0666); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("chmod %s: %w", path, err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleControlConn(conn, seats, access.mayChange(conn))
+		}
+	}()
+	return l, nil
+}
+
+// handleControlConn serves one client; unless mayChange, it is limited to
+// publicCommands.
+func handleControlConn(conn net.Conn, seats []*seatInstance, mayChange bool) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+
+	for {
+		var req controlRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if !mayChange && !slices.Contains(publicCommands, req.Cmd) {
+			enc.Encode(controlResponse{Error: fmt.Sprintf("%q: permission denied", req.Cmd)})
+			continue
+		}
+
+		if req.Cmd == "follow" || req.Cmd == "events" {
+			inst := findSeat(seats, req.Seat)
+			if inst == nil {
+				enc.Encode(controlResponse{Error: fmt.Sprintf("unknown seat %q", req.Seat)})
+				continue
+			}
+			if req.Cmd == "follow" {
+				followStatus(conn, enc, inst.status)
+			} else {
+				followEvents(conn, enc, inst.events)
+			}
+			return
+		}
+		enc.Encode(execControl(seats, req))
+	}
+}
+
+// execControl runs one request-reply command. It is shared by every control
+// transport; streaming ("follow") is handled by each transport itself.
+func execControl(seats []*seatInstance, req controlRequest) controlResponse {
+	inst := findSeat(seats, req.Seat)
+	if inst == nil {
+		return controlResponse{Error: fmt.Sprintf("unknown seat %q", req.Seat)}
+	}
+	st := inst.status
+
+	switch req.Cmd {
+	case "status":
+	case "latency":
+		l := inst.pointer().Latency()
+		return controlResponse{OK: true, Latency: &l}
+	case "heatmap":
+		m := inst.heatmap.Snapshot()
+		return controlResponse{OK: true, Heatmap: &m}
+	case "pressure":
+		p := inst.engine.Pressure.Snapshot(inst.profile.Load())
+		return controlResponse{OK: true, Pressure: &p}
+	case "stats":
+		stats := inst.stats()
+		return controlResponse{OK: true, Stats: &stats}
+	case "device":
+		info, err := device.ReadInfo(inst.pad.Device())
+		if err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+		return controlResponse{OK: true, Device: &info}
+	case "battery-saver-auto", "battery-saver-on", "battery-saver-off":
+		inst.saver.SetMode(strings.TrimPrefix(req.Cmd, "battery-saver-"))
+	case "profile", "profile-toggle":
+		name := req.Name
+		if req.Cmd == "profile-toggle" && st.Get().Profile == name {
+			name = config.DefaultProfileName
+		}
+		if err := inst.SetProfile(name); err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+	case "reload":
+		// The same as SIGHUP, which tells systemd about it too; the
+		// outcome is logged.
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+	case "settings":
+		p := *inst.profile.Load()
+		return controlResponse{OK: true, Settings: &p}
+	case "set", "toggle-setting":
+		var err error
+		if req.Cmd == "set" {
+			err = inst.set(req.Name, req.Value)
+		} else {
+			err = inst.toggleSetting(req.Name)
+		}
+		if err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+	case "log-level":
+		// For the whole driver, not just the seat.
+		if err := setLogLevel(req.Name); err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+	case "orientation":
+		if err := inst.orientation.Set(req.Name); err != nil {
+			return controlResponse{Error: err.Error()}
+		}
+	case "scroll-mode-on":
+		inst.engine.SetOneFingerScroll(true)
+	case "scroll-mode-off":
+		inst.engine.SetOneFingerScroll(false)
+	case "scroll-mode-toggle":
+		inst.engine.SetOneFingerScroll(!inst.engine.OneFingerScroll.Load())
+	case "enable", "disable", "toggle":
+		st.update(func(s *Status) {
+			switch req.Cmd {
+			case "enable":
+				s.Enabled = true
+			case "disable":
+				s.Enabled = false
+			default:
+				s.Enabled = !s.Enabled
+			}
+		})
+	default:
+		return controlResponse{Error: fmt.Sprintf("unknown command %q", req.Cmd)}
+	}
+	s := st.Get()
+	s.Pressure = inst.engine.TouchPressure.Load()
+	return controlResponse{OK: true, Status: &s}
+}
+
+// hangup returns a channel closed once the client closes its end.
+func hangup(conn net.Conn) <-chan struct{} {
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	return gone
+}
+
+// followStatus streams status changes until the client hangs up.
+func followStatus(conn net.Conn, enc *json.Encoder, st *statusTracker) {
+	ch, stop := st.Subscribe()
+	defer stop()
+
+	gone := hangup(conn)
+	for {
+		select {
+		case s := <-ch:
+			if err := enc.Encode(controlResponse{OK: true, Status: &s}); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// followEvents streams the processed-event feed until the client hangs up.
+func followEvents(conn net.Conn, enc *json.Encoder, hub *eventHub) {
+	ch, stop := hub.Subscribe()
+	defer stop()
+
+	gone := hangup(conn)
+	for {
+		select {
+		case ev := <-ch:
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+func findSeat(seats []*seatInstance, name string) *seatInstance {
+	if name == "" {
+		return seats[0]
+	}
+	for _, inst := range seats {
+		if inst.cfg.Name == name {
+			return inst
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+)
+
+const (
+	// CrashReportDir holds the report of the last crash for the system
+	// service; a user service uses $XDG_STATE_HOME/touchpad2mouse.
+	CrashReportDir = "/var/lib/touchpad2mouse"
+
+	// RecentEvents is how many raw touchpad events a crash report shows.
+	RecentEvents = 256
+)
+
+func crashReportPath() string {
+	if os.Getuid() == 0 {
+		return filepath.Join(CrashReportDir, "crash.txt")
+	}
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "touchpad2mouse", "crash.txt")
+}
+
+// crashReporter writes crash reports to a file opened at startup, before
+// privileges are dropped and the sandbox stops new files from being created.
+// Each report replaces the previous one.
+type crashReporter struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+}
+
+func openCrashReporter() (*crashReporter, error) {
+	path := crashReportPath()
+	if path == "" {
+		return nil, errors.New("no place for crash reports")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	// Not truncated here, so the last report survives the restart it
+	// caused.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &crashReporter{f: f, path: path}, nil
+}
+
+// Write replaces the stored report. A nil reporter only logs.
+func (c *crashReporter) Write(report string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.f.Truncate(0); err != nil {
+		slog.Error("cannot write crash report", "path", c.path, "err", err)
+		return
+	}
+	if _, err := c.f.WriteAt([]byte(report), 0); err != nil {
+		slog.Error("cannot write crash report", "path", c.path, "err", err)
+		return
+	}
+	c.f.Sync()
+	slog.Error("crash report written", "path", c.path)
+}
+
+// eventRing keeps the last RecentEvents raw events read from the touchpad.
+// Only the processing loop touches it.
+type eventRing struct {
+	buf  [RecentEvents]evdev.InputEvent
+	next int
+	full bool
+}
+
+func (r *eventRing) Add(ev evdev.InputEvent) {
+	r.buf[r.next] = ev
+	r.next++
+	if r.next == len(r.buf) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Events returns the stored events, oldest first.
+func (r *eventRing) Events() []evdev.InputEvent {
+	if !r.full {
+		return append([]evdev.InputEvent(nil), r.buf[:r.next]...)
+	}
+	return append(append([]evdev.InputEvent(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// crashReport describes a panic in seat's loop: what panicked and where,
+// the state machine at the time, and the events that led up to it.
+func crashReport(seat, device string, value any, stack []byte, state string, recent []evdev.InputEvent) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "time: %s\n", time.Now().Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "seat: %s\ndevice: %s\n", seat, device)
+	fmt.Fprintf(&b, "panic: %v\n\n%s\n", value, stack)
+	fmt.Fprintf(&b, "state:\n%s\n", state)
+	fmt.Fprintf(&b, "last %d events:\n", len(recent))
+	for _, ev := range recent {
+		fmt.Fprintf(&b, "%d.%06d %s %s %d\n", ev.Time.Sec, ev.Time.Usec,
+			evdev.EV[int(ev.Type)], codeName(ev.Type, ev.Code), ev.Value)
+	}
+	return b.String()
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// CtlName is the name the driver answers to as the companion CLI. The
+// install command links it to the driver's executable.
+const CtlName = "touchpadctl"
+
+const ctlUsage = `usage: touchpadctl [-socket path] [-seat name] command [args]
+
+commands:
+  status                  print the driver's status
+  settings                print the current profile's settings
+  set <setting> <value>   change a setting, e.g. set sensitivity 0.8
+  toggle <setting>        flip a true/false setting, e.g. toggle tap_to_click
+  gestures on|off         turn swipe gestures on or off
+  profile <name>          switch profile
+  enable | disable        start or stop translating the touchpad
+  log-level <level>       log at debug, info, warn or error from now on
+  reload                  reload the config file`
+
+// runCtl implements touchpadctl, which sends the control socket's commands
+// from a shell. Settings changed with it last until the driver restarts or
+// reloads its config.
+func runCtl(args []string) error {
+	fs := flag.NewFlagSet(CtlName, flag.ExitOnError)
+	socket := fs.String("socket", "", "control socket path (default: the user service's, else the system one)")
+	seat := fs.String("seat", "", "seat to control (default: the first configured)")
+	fs.Usage = func() { fmt.Fprintln(fs.Output(), ctlUsage) }
+	fs.Parse(args)
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	req := controlRequest{Cmd: args[0], Seat: *seat}
+	switch {
+	case args[0] == "status" && len(args) == 1, args[0] == "settings" && len(args) == 1,
+		args[0] == "enable" && len(args) == 1, args[0] == "disable" && len(args) == 1,
+		args[0] == "reload" && len(args) == 1:
+	case args[0] == "profile" && len(args) == 2, args[0] == "log-level" && len(args) == 2:
+		req.Name = args[1]
+	case args[0] == "toggle" && len(args) == 2:
+		req.Cmd, req.Name = "toggle-setting", args[1]
+	case args[0] == "set" && len(args) == 3:
+		req.Name, req.Value = args[1], ctlValue(args[2])
+	case args[0] == "gestures" && len(args) == 2 && (args[1] == "on" || args[1] == "off"):
+		req.Cmd, req.Name, req.Value = "set", "gestures", json.RawMessage(fmt.Sprint(args[1] == "on"))
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := dialControl(*socket)
+	if err != nil {
+		return fmt.Errorf("connect to driver: %w", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+
+	var out any
+	switch args[0] {
+	case "status":
+		out = resp.Status
+	case "settings":
+		out = resp.Settings
+	default:
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// ctlValue takes a value given on the command line as JSON if it is, and as
+// a string otherwise, so that both "set sensitivity 0.8" and "set accel
+// flat" work.
+func ctlValue(arg string) json.RawMessage {
+	if json.Valid([]byte(arg)) {
+		return json.RawMessage(arg)
+	}
+	s, _ := json.Marshal(arg)
+	return s
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+const (
+	DBusName      = "org.touchpad2mouse.Driver"
+	DBusPath      = dbus.ObjectPath("/org/touchpad2mouse/Driver")
+	DBusInterface = "org.touchpad2mouse.Driver"
+)
+
+const dbusIntrospectXML = `<node>
+	<interface name="` + DBusInterface + `">
+		<method name="Status">
+			<arg name="status" type="s" direction="out"/>
+		</method>
+		<method name="SetProfile">
+			<arg name="profile" type="s" direction="in"/>
+		</method>
+		<method name="Enable"/>
+		<method name="Disable"/>
+		<signal name="GestureDetected">
+			<arg name="gesture" type="s"/>
+		</signal>
+		<signal name="ProfileChanged">
+			<arg name="profile" type="s"/>
+		</signal>
+		<signal name="EnabledChanged">
+			<arg name="enabled" type="b"/>
+		</signal>
+		<signal name="DeviceLost"/>
+		<signal name="DeviceReconnected"/>
+	</interface>` + introspect.IntrospectDeclarationString + `</node>`
+
+// dbusService publishes driver events as signals so shell extensions can show
+// on-screen feedback, and takes the main control commands as methods. The
+// system service uses the system bus; a user service uses the session bus,
+// where it can also be started by bus activation.
+type dbusService struct {
+	conn   *dbus.Conn
+	events chan seatEvent
+	// access says who may call the methods that change the driver, as for
+	// the control socket.
+	access controlAccess
+}
+
+type seatEvent struct {
+	path dbus.ObjectPath
+	Event
+}
+
+// seatObjectPath is DBusPath for the first seat and DBusPath/<seat> for the
+// others, so single-seat listeners need not care about seats at all.
+func seatObjectPath(i int, seat string) dbus.ObjectPath {
+	if i == 0 {
+		return DBusPath
+	}
+	// Object path elements only allow [A-Za-z0-9_].
+	return DBusPath + dbus.ObjectPath("/"+strings.Map(func(r rune) rune {
+		if r < 0x80 && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '_'
+	}, seat))
+}
+
+func startDBus(seats []*seatInstance, access controlAccess) (*dbusService, error) {
+	connect, which := dbus.ConnectSystemBus, "system"
+	if os.Getuid() != 0 {
+		connect, which = dbus.ConnectSessionBus, "session"
+	}
+	conn, err := connect()
+	if err != nil {
+		return nil, fmt.Errorf("connect %s bus: %w", which, err)
+	}
+
+	// Owning the well-known name on the system bus needs a policy file;
+	// signals are still delivered from the unique name without it.
+	reply, err := conn.RequestName(DBusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		slog.Warn("cannot own bus name", "name", DBusName, "err", err)
+	} else if reply != dbus.RequestNameReplyPrimaryOwner {
+		slog.Warn("bus name already owned", "name", DBusName)
+	}
+
+	d := &dbusService{conn: conn, events: make(chan seatEvent, 32), access: access}
+	for i, inst := range seats {
+		path := seatObjectPath(i, inst.cfg.Name)
+		conn.Export(introspect.Introspectable(dbusIntrospectXML), path, "org.freedesktop.DBus.Introspectable")
+		conn.Export(dbusObject{d, inst}, path, DBusInterface)
+		inst.status.OnEvent(func(ev Event) { d.push(seatEvent{path, ev}) })
+	}
+	go d.run()
+	return d, nil
+}
+
+// push hands the event to the emitter goroutine without ever blocking the
+// event loop; if the bus is stuck, events are dropped.
+func (d *dbusService) push(ev seatEvent) {
+	select {
+	case d.events <- ev:
+	default:
+	}
+}
+
+func (d *dbusService) run() {
+	for ev := range d.events {
+		var err error
+		switch ev.Kind {
+		case EventGesture:
+			err = d.conn.Emit(ev.path, DBusInterface+".GestureDetected", ev.Name)
+		case EventProfile:
+			err = d.conn.Emit(ev.path, DBusInterface+".ProfileChanged", ev.Name)
+		case EventEnabled:
+			err = d.conn.Emit(ev.path, DBusInterface+".EnabledChanged", ev.Enabled)
+		case EventDevice:
+			if ev.Connected {
+				err = d.conn.Emit(ev.path, DBusInterface+".DeviceReconnected")
+			} else {
+				err = d.conn.Emit(ev.path, DBusInterface+".DeviceLost")
+			}
+		}
+		if err != nil {
+			slog.Warn("D-Bus emit failed", "err", err)
+		}
+	}
+}
+
+// dbusObject implements the methods of DBusInterface for one seat.
+type dbusObject struct {
+	d    *dbusService
+	inst *seatInstance
+}
+
+// Status returns the seat's status as the same JSON the control socket uses.
+func (o dbusObject) Status() (string, *dbus.Error) {
+	b, _ := json.Marshal(o.inst.status.Get())
+	return string(b), nil
+}
+
+// SetProfile switches the seat to profile.
+func (o dbusObject) SetProfile(sender dbus.Sender, profile string) *dbus.Error {
+	return o.exec(sender, controlRequest{Cmd: "profile", Name: profile})
+}
+
+// Enable starts translating the seat's touchpad again.
+func (o dbusObject) Enable(sender dbus.Sender) *dbus.Error {
+	return o.exec(sender, controlRequest{Cmd: "enable"})
+}
+
+// Disable stops translating the seat's touchpad.
+func (o dbusObject) Disable(sender dbus.Sender) *dbus.Error {
+	return o.exec(sender, controlRequest{Cmd: "disable"})
+}
+
+// exec runs req as the control socket would for the seat, if sender may
+// change the driver.
+func (o dbusObject) exec(sender dbus.Sender, req controlRequest) *dbus.Error {
+	var uid uint32
+	err := o.d.conn.BusObject().Call("org.freedesktop.DBus.GetConnectionUnixUser", 0, string(sender)).Store(&uid)
+	if err != nil {
+		return dbus.MakeFailedError(fmt.Errorf("identify caller: %w", err))
+	}
+	if !o.d.access.mayChangeAs(uid) {
+		return dbus.NewError("org.freedesktop.DBus.Error.AccessDenied", []any{fmt.Sprintf("%q: permission denied", req.Cmd)})
+	}
+	if resp := execControl([]*seatInstance{o.inst}, req); !resp.OK {
+		return dbus.MakeFailedError(errors.New(resp.Error))
+	}
+	return nil
+}
+
+func (d *dbusService) Close() {
+	d.conn.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+
+	"touchpad/device"
+)
+
+// Formats of --debug-format.
+const (
+	DebugFormatDefault  = "default"
+	DebugFormatLibinput = "libinput"
+	DebugFormatSummary  = "summary"
+)
+
+// eventDump prints raw touchpad events, the events synthesized from them and
+// the state machine's state at each frame, laid out like libinput
+// debug-events: source, event name, time since start, details. In the
+// libinput format it prints what libinput itself would report for the
+// virtual devices instead (see libinput.go), and in the summary format a
+// description in words (see summary.go).
+type eventDump struct {
+	mu       sync.Mutex
+	w        io.Writer
+	start    time.Time
+	libinput bool
+	summary  bool
+	// swipes tracks the swipe in progress on each touchpad, in the
+	// libinput format, and touches the touch, in the summary format.
+	swipes  map[string]*swipeState
+	touches map[string]*touchSummary
+}
+
+func newEventDump(w io.Writer, format string) (*eventDump, error) {
+	switch format {
+	case DebugFormatDefault, DebugFormatLibinput, DebugFormatSummary:
+	default:
+		return nil, fmt.Errorf("unknown debug format %q", format)
+	}
+	return &eventDump{
+		w:        w,
+		start:    time.Now(),
+		libinput: format == DebugFormatLibinput,
+		summary:  format == DebugFormatSummary,
+		swipes:   make(map[string]*swipeState),
+		touches:  make(map[string]*touchSummary),
+	}, nil
+}
+
+func (d *eventDump) line(source, name string, at time.Time, detail string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fmt.Fprintf(d.w, "%-10s %-22s %+9.3fs\t%s\n", source, name, at.Sub(d.start).Seconds(), detail)
+}
+
+// codeAliases names the codes the evdev package knows by more than one name,
+// between which it picks at random on each start, so that output and
+// recordings read the same every time.
+var codeAliases = map[[2]uint16]string{
+	{device.EV_KEY, evdev.KEY_MUTE}:            "KEY_MUTE",
+	{device.EV_KEY, evdev.KEY_HANGEUL}:         "KEY_HANGEUL",
+	{device.EV_KEY, evdev.KEY_COFFEE}:          "KEY_COFFEE",
+	{device.EV_KEY, evdev.KEY_ROTATE_DISPLAY}:  "KEY_ROTATE_DISPLAY",
+	{device.EV_KEY, evdev.KEY_BRIGHTNESS_AUTO}: "KEY_BRIGHTNESS_AUTO",
+	{device.EV_KEY, evdev.KEY_WWAN}:            "KEY_WWAN",
+	{device.EV_KEY, evdev.KEY_DISPLAYTOGGLE}:   "KEY_DISPLAYTOGGLE",
+	{device.EV_KEY, evdev.KEY_DATA}:            "KEY_DATA",
+	{device.EV_KEY, evdev.BTN_0}:               "BTN_0",
+	{device.EV_KEY, evdev.BTN_LEFT}:            "BTN_LEFT",
+	{device.EV_KEY, evdev.BTN_TRIGGER}:         "BTN_TRIGGER",
+	{device.EV_KEY, evdev.BTN_SOUTH}:           "BTN_SOUTH",
+	{device.EV_KEY, evdev.BTN_EAST}:            "BTN_EAST",
+	{device.EV_KEY, evdev.BTN_NORTH}:           "BTN_NORTH",
+	{device.EV_KEY, evdev.BTN_WEST}:            "BTN_WEST",
+	{device.EV_KEY, evdev.BTN_TOOL_PEN}:        "BTN_TOOL_PEN",
+	{device.EV_KEY, evdev.BTN_GEAR_DOWN}:       "BTN_GEAR_DOWN",
+	{device.EV_KEY, evdev.BTN_TRIGGER_HAPPY1}:  "BTN_TRIGGER_HAPPY1",
+	{evdev.EV_SW, evdev.SW_RFKILL_ALL}:         "SW_RFKILL_ALL",
+	{evdev.EV_SW, evdev.SW_PEN_INSERTED}:       "SW_PEN_INSERTED",
+	// Newer than the evdev package's tables.
+	{device.EV_REL, device.REL_WHEEL_HI_RES}:  "REL_WHEEL_HI_RES",
+	{device.EV_REL, device.REL_HWHEEL_HI_RES}: "REL_HWHEEL_HI_RES",
+}
+
+func codeName(typ, code uint16) string {
+	if name, ok := codeAliases[[2]uint16{typ, code}]; ok {
+		return name
+	}
+	if name, ok := evdev.ByEventType[int(typ)][int(code)]; ok {
+		return name
+	}
+	if name, ok := evdev.BTN[int(code)]; ok && typ == device.EV_KEY {
+		return name
+	}
+	return fmt.Sprintf("%s_0x%03x", evdev.EV[int(typ)], code)
+}
+
+// Raw prints one event read from the touchpad at path.
+func (d *eventDump) Raw(path string, ev evdev.InputEvent) {
+	if d.summary {
+		d.summaryRaw(path, ev)
+		return
+	}
+	if ev.Type == evdev.EV_SYN || d.libinput {
+		return
+	}
+	d.line(filepath.Base(path), codeName(ev.Type, ev.Code), device.Time(ev.Time), fmt.Sprint(ev.Value))
+}
+
+// Frame prints a frame boundary with the state the state machine is in.
+func (d *eventDump) Frame(path string, at time.Time, fingers int, mode string, clicked bool, slots *device.Slots) {
+	if d.libinput {
+		d.swipe(path, at, fingers, mode, slots)
+		return
+	}
+	if d.summary {
+		d.summaryFrame(path, at, fingers, mode, slots)
+		return
+	}
+	d.line(filepath.Base(path), "SYN_REPORT", at,
+		fmt.Sprintf("--- fingers=%d mode=%s clicked=%v", fingers, mode, clicked))
+}
+
+// virtual returns a hook printing the events written to the named device,
+// which the touchpad at path drives.
+func (d *eventDump) virtual(dev, path string) func(typ, code uint16, value int32) {
+	if d.libinput {
+		return d.libinputDevice(dev, path)
+	}
+	if d.summary {
+		return d.summaryDevice(dev, path)
+	}
+	return func(typ, code uint16, value int32) {
+		if typ == device.EV_SYN {
+			return
+		}
+		d.line("virtual", codeName(typ, code), time.Now(), fmt.Sprintf("%d (%s)", value, dev))
+	}
+}
@@ -0,0 +1,14 @@
+package device
+
+import (
+	"os"
+	"sync"
+)
+
+// OnAndroid reports whether the driver runs on Android rather than a regular
+// Linux userspace: no udev, systemd or user database, though the kernel's
+// uinput and evdev are the same.
+var OnAndroid = sync.OnceValue(func() bool {
+	_, err := os.Stat("/system/build.prop")
+	return err == nil
+})
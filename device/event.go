@@ -0,0 +1,128 @@
+package device
+
+import (
+	"encoding/binary"
+	"syscall"
+	"time"
+	"unsafe"
+
+	evdev "github.com/gvalkov/golang-evdev"
+)
+
+// The event types and codes the driver sends, as in linux/input-event-codes.h.
+const (
+	EV_SYN = 0x00
+	EV_KEY = 0x01
+	EV_REL = 0x02
+	EV_ABS = 0x03
+
+	SYN_REPORT = 0x00
+
+	REL_X      = 0x00
+	REL_Y      = 0x01
+	REL_HWHEEL = 0x06
+	REL_WHEEL  = 0x08
+
+	REL_WHEEL_HI_RES  = 0x0b
+	REL_HWHEEL_HI_RES = 0x0c
+
+	ABS_X              = 0x00
+	ABS_Y              = 0x01
+	ABS_MT_SLOT        = 0x2f
+	ABS_MT_POSITION_X  = 0x35
+	ABS_MT_POSITION_Y  = 0x36
+	ABS_MT_TRACKING_ID = 0x39
+
+	BTN_LEFT   = 0x110
+	BTN_RIGHT  = 0x111
+	BTN_MIDDLE = 0x112
+	BTN_SIDE   = 0x113
+	BTN_EXTRA  = 0x114
+	BTN_TOUCH  = 0x14a
+
+	KEY_LEFTMETA  = 125
+	KEY_LEFTALT   = 56
+	KEY_LEFTSHIFT = 42
+	KEY_TAB       = 15
+	KEY_D         = 32
+
+	KEY_LEFTCTRL = 29
+	KEY_LEFT     = 105
+	KEY_RIGHT    = 106
+
+	KEY_MAX = 0x2ff
+
+	INPUT_PROP_POINTER = 0x00
+	INPUT_PROP_DIRECT  = 0x01
+)
+
+// inputEvent mirrors struct input_event: a timeval, whose fields are as wide
+// as a long, then type, code and value. Its size and layout differ between
+// 32- and 64-bit architectures, so events are framed by the sizes and
+// offsets below, taken from it.
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// EventSize is the size of an input_event, as read from an event device and
+// written to uinput.
+const EventSize = int(unsafe.Sizeof(inputEvent{}))
+
+const (
+	inputEventType  = int(unsafe.Offsetof(inputEvent{}.Type))
+	inputEventCode  = int(unsafe.Offsetof(inputEvent{}.Code))
+	inputEventValue = int(unsafe.Offsetof(inputEvent{}.Value))
+	// timevalUsec is the offset of the microseconds in the timeval, which
+	// is also the size of its fields.
+	timevalUsec = int(unsafe.Offsetof(syscall.Timeval{}.Usec))
+)
+
+// PutEvent writes an event to b, whose time is left zero.
+func PutEvent(b []byte, typ, code uint16, value int32) {
+	binary.NativeEndian.PutUint16(b[inputEventType:], typ)
+	binary.NativeEndian.PutUint16(b[inputEventCode:], code)
+	binary.NativeEndian.PutUint32(b[inputEventValue:], uint32(value))
+}
+
+// ParseEvent reads the event at the start of b.
+func ParseEvent(b []byte) evdev.InputEvent {
+	sec, usec := nativeLong(b), nativeLong(b[timevalUsec:])
+	return evdev.InputEvent{
+		Time:  syscall.NsecToTimeval(sec*1e9 + usec*1e3),
+		Type:  binary.NativeEndian.Uint16(b[inputEventType:]),
+		Code:  binary.NativeEndian.Uint16(b[inputEventCode:]),
+		Value: int32(binary.NativeEndian.Uint32(b[inputEventValue:])),
+	}
+}
+
+// nativeLong reads a field of a timeval from b.
+func nativeLong(b []byte) int64 {
+	if timevalUsec == 8 {
+		return int64(binary.NativeEndian.Uint64(b))
+	}
+	// 32-bit kernels keep the seconds unsigned, past 2038.
+	return int64(binary.NativeEndian.Uint32(b))
+}
+
+// Time converts an evdev timestamp, which is CLOCK_REALTIME.
+func Time(tv syscall.Timeval) time.Time {
+	return time.Unix(tv.Unix())
+}
+
+// MaxSlots is how many multitouch slots are tracked; events for higher
+// slots are ignored.
+const MaxSlots = 10
+
+// Slot is one contact. Active is set from its first event until its tracking
+// id is released.
+type Slot struct {
+	X, Y, P int32
+	Active  bool
+}
+
+// Slots holds every slot by number. It is a plain array so that copying
+// the previous frame's state costs no allocation.
+type Slots [MaxSlots]Slot
@@ -0,0 +1,106 @@
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+
+	evdev "github.com/gvalkov/golang-evdev"
+)
+
+// DefaultSeat is the seat devices belong to unless udev assigns another.
+const DefaultSeat = "seat0"
+
+// Find returns the event node of the touchpad on seat: the first device
+// whose name contains keyword, preferring one whose name also contains
+// mustContain, or with no keyword, the first that looks like a touchpad.
+func Find(keyword, mustContain, seat string) (string, error) {
+	devices, _ := evdev.ListInputDevices()
+	defer func() {
+		for _, dev := range devices {
+			dev.File.Close()
+		}
+	}()
+	if keyword == "" {
+		return detectTouchpad(devices, seat)
+	}
+	var fallback string
+	for _, dev := range devices {
+		if Seat(dev.Fn) != seat {
+			continue
+		}
+		nameLower := strings.ToLower(dev.Name)
+		if strings.Contains(nameLower, strings.ToLower(keyword)) {
+			if strings.Contains(nameLower, strings.ToLower(mustContain)) {
+				return dev.Fn, nil
+			}
+			if fallback == "" {
+				fallback = dev.Fn
+			}
+		}
+	}
+	if fallback != "" {
+		return fallback, nil
+	}
+	return "", fmt.Errorf("device with keyword '%s' not found on %s", keyword, seat)
+}
+
+// detectTouchpad picks a touchpad on seat by what the devices advertise:
+// multitouch positions and a finger count, as touchpads report and touch
+// screens mostly don't. One the kernel marks as a pointer is preferred, and
+// one marked direct, a touchscreen or the driver's own virtual touchscreen,
+// is never taken.
+func detectTouchpad(devices []*evdev.InputDevice, seat string) (string, error) {
+	var fallback string
+	for _, dev := range devices {
+		if Seat(dev.Fn) != seat {
+			continue
+		}
+		caps := dev.CapabilitiesFlat
+		if !slices.Contains(caps[evdev.EV_ABS], evdev.ABS_MT_POSITION_X) || !slices.Contains(caps[evdev.EV_KEY], evdev.BTN_TOOL_FINGER) {
+			continue
+		}
+		props, err := Props(dev.File)
+		if err != nil || props&(1<<INPUT_PROP_DIRECT) != 0 {
+			continue
+		}
+		if props&(1<<INPUT_PROP_POINTER) != 0 {
+			return dev.Fn, nil
+		}
+		if fallback == "" {
+			fallback = dev.Fn
+		}
+	}
+	if fallback != "" {
+		return fallback, nil
+	}
+	return "", fmt.Errorf("no touchpad found on %s", seat)
+}
+
+// Seat returns the ID_SEAT udev assigned to the device node at path,
+// defaulting to seat0 like logind does.
+func Seat(path string) string {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return DefaultSeat
+	}
+	major := (st.Rdev >> 8) & 0xfff
+	minor := (st.Rdev & 0xff) | ((st.Rdev >> 12) & 0xfff00)
+
+	f, err := os.Open(fmt.Sprintf("/run/udev/data/c%d:%d", major, minor))
+	if err != nil {
+		return DefaultSeat
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if seat, ok := strings.CutPrefix(sc.Text(), "E:ID_SEAT="); ok && seat != "" {
+			return seat
+		}
+	}
+	return DefaultSeat
+}
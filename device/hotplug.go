@@ -0,0 +1,50 @@
+package device
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// InputDir is where the kernel's input device nodes appear.
+const InputDir = "/dev/input"
+
+// Watcher reports changes in InputDir through inotify, so a touchpad
+// that went away can be waited for rather than polled.
+type Watcher struct {
+	fd int
+}
+
+// NewWatcher starts watching InputDir.
+func NewWatcher() (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// udev fixes up a new node's owner and mode after creating it, which
+	// is when it can be opened.
+	if _, err := unix.InotifyAddWatch(fd, InputDir, unix.IN_CREATE|unix.IN_ATTRIB); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &Watcher{fd: fd}, nil
+}
+
+// Wait returns once something changed in InputDir, or after timeout.
+func (w *Watcher) Wait(timeout time.Duration) {
+	fds := []unix.PollFd{{Fd: int32(w.fd), Events: unix.POLLIN}}
+	if n, err := unix.Poll(fds, int(timeout/time.Millisecond)); err != nil || n == 0 {
+		return
+	}
+	// Which node it was doesn't matter; the caller looks again.
+	var buf [4096]byte
+	for {
+		if _, err := unix.Read(w.fd, buf[:]); err != nil {
+			return
+		}
+	}
+}
+
+func (w *Watcher) Close() {
+	unix.Close(w.fd)
+}
@@ -0,0 +1,413 @@
+// Package device finds, grabs and reads touchpads through evdev.
+package device
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	evdev "github.com/gvalkov/golang-evdev"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	EVIOCGRAB = 0x40044590
+
+	// Read-direction ioctls whose size is part of the request number.
+	eviocgkey     = 0x18
+	eviocgmtslots = 0x0a
+	eviocgabs     = 0x40
+	eviocgprop    = 0x09
+)
+
+func ioctl(fd uintptr, request uintptr, val uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, val)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// eviocRead builds the _IOC(_IOC_READ, 'E', nr, size) request number.
+func eviocRead(nr, size uintptr) uintptr {
+	return 2<<30 | size<<16 | 'E'<<8 | nr
+}
+
+// ReadBatch is the most events a Source returns from one read.
+const ReadBatch = 64
+
+// Touchpad is the grabbed source device. The event loop reads from it while
+// other goroutines grab, release or close it, and after resume it is reopened
+// in place, so access goes through its methods.
+type Touchpad struct {
+	mu  sync.Mutex
+	dev *evdev.InputDevice
+	// grabbed is the wanted grab state; it survives Close so Open can
+	// restore it on the new fd.
+	grabbed bool
+	// OnClose wakes the event loop, as epoll says nothing about a closed fd.
+	OnClose func()
+}
+
+// openTouchpad opens path with a non-blocking fd, which the event loop polls
+// and drains with readEvents. evdev's own Grab and Release switch the fd back
+// to blocking mode, hence setGrab.
+func openTouchpad(path string) (*evdev.InputDevice, error) {
+	dev, err := evdev.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	dev.File.Close()
+	if err != nil {
+		return nil, err
+	}
+	dev.File = f
+	return dev, nil
+}
+
+func setGrab(f *os.File, on bool) error {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var val uintptr
+	if on {
+		val = 1
+	}
+	var ioErr error
+	if err := raw.Control(func(fd uintptr) { ioErr = ioctl(fd, EVIOCGRAB, val) }); err != nil {
+		return err
+	}
+	return ioErr
+}
+
+// Props returns the INPUT_PROP_* bits the device f advertises.
+func Props(f *os.File) (uint32, error) {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var props [4]byte
+	var ioErr error
+	if err := raw.Control(func(fd uintptr) {
+		ioErr = ioctl(fd, eviocRead(eviocgprop, unsafe.Sizeof(props)), uintptr(unsafe.Pointer(&props)))
+	}); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(props[:]), ioErr
+}
+
+// Open replaces the current device (if any) with path, restoring the grab.
+func (t *Touchpad) Open(path string) error {
+	dev, err := openTouchpad(path)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dev != nil {
+		t.dev.File.Close()
+	}
+	t.dev = dev
+	if t.grabbed {
+		return setGrab(dev.File, true)
+	}
+	return nil
+}
+
+func (t *Touchpad) Device() *evdev.InputDevice {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dev
+}
+
+func (t *Touchpad) Grab() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.grabbed = true
+	return setGrab(t.dev.File, true)
+}
+
+func (t *Touchpad) Release() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.grabbed = false
+	return setGrab(t.dev.File, false)
+}
+
+// Close closes the fd, which drops the grab, and wakes the event loop so its
+// next read returns os.ErrClosed. The wanted grab state is kept for the next
+// Open.
+func (t *Touchpad) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dev.File.Close()
+	if t.OnClose != nil {
+		t.OnClose()
+	}
+}
+
+// CapabilitySummary lists dev's event types with how many codes each has,
+// e.g. "EV_ABS:11 EV_KEY:7", for logging.
+func CapabilitySummary(dev *evdev.InputDevice) string {
+	var parts []string
+	for t, codes := range dev.Capabilities {
+		parts = append(parts, fmt.Sprintf("%s:%d", t.Name, len(codes)))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// deviceFd returns dev's fd for polling, or os.ErrClosed once it is closed.
+func deviceFd(dev *evdev.InputDevice) (int, error) {
+	raw, err := dev.File.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	if err := raw.Control(func(f uintptr) { fd = int(f) }); err != nil {
+		return -1, os.ErrClosed
+	}
+	return fd, nil
+}
+
+// eventReader reads batches of events from a touchpad. The byte buffer, the
+// decoded batch and the read callback are reused, so reading allocates
+// nothing.
+type eventReader struct {
+	raw    syscall.RawConn
+	buf    []byte
+	events []evdev.InputEvent
+	n      int
+	err    error
+	read   func(fd uintptr) bool
+}
+
+func newEventReader(dev *evdev.InputDevice) (*eventReader, error) {
+	raw, err := dev.File.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	r := &eventReader{
+		raw:    raw,
+		buf:    make([]byte, ReadBatch*EventSize),
+		events: make([]evdev.InputEvent, ReadBatch),
+	}
+	// Returning true keeps the runtime from parking on EAGAIN; the event
+	// loop does the waiting.
+	r.read = func(fd uintptr) bool {
+		r.n, r.err = unix.Read(int(fd), r.buf)
+		return true
+	}
+	return r, nil
+}
+
+// Read returns the events pending on the device without blocking. An empty
+// batch means there was nothing to read. The batch is only valid until the
+// next call.
+func (r *eventReader) Read() ([]evdev.InputEvent, error) {
+	if err := r.raw.Read(r.read); err != nil {
+		return nil, os.ErrClosed
+	}
+	if r.err == unix.EAGAIN {
+		return nil, nil
+	}
+	if r.err != nil {
+		return nil, os.NewSyscallError("read", r.err)
+	}
+
+	count := r.n / EventSize
+	for i := range count {
+		r.events[i] = ParseEvent(r.buf[i*EventSize:])
+	}
+	return r.events[:count], nil
+}
+
+// Source is an open touchpad as the engine reads it.
+type Source struct {
+	dev    *evdev.InputDevice
+	reader *eventReader
+}
+
+func NewSource(dev *evdev.InputDevice) (*Source, error) {
+	r, err := newEventReader(dev)
+	if err != nil {
+		return nil, err
+	}
+	return &Source{dev: dev, reader: r}, nil
+}
+
+func (t *Source) Path() string                      { return t.dev.Fn }
+func (t *Source) Fd() (int, error)                  { return deviceFd(t.dev) }
+func (t *Source) Read() ([]evdev.InputEvent, error) { return t.reader.Read() }
+func (t *Source) Info() (Info, error)               { return ReadInfo(t.dev) }
+func (t *Source) Capabilities() map[int][]int       { return t.dev.CapabilitiesFlat }
+func (t *Source) TouchState() (TouchState, error)   { return queryTouchState(t.dev) }
+
+// AbsInfo mirrors struct input_absinfo.
+type AbsInfo struct {
+	Value, Minimum, Maximum, Fuzz, Flat, Resolution int32
+}
+
+func queryAbs(fd uintptr, code int) (AbsInfo, error) {
+	var abs AbsInfo
+	err := ioctl(fd, eviocRead(eviocgabs+uintptr(code), unsafe.Sizeof(abs)), uintptr(unsafe.Pointer(&abs)))
+	return abs, err
+}
+
+// Info describes a touchpad: its node, name, IDs, axis ranges and
+// resolution in units per millimetre (0 if the kernel doesn't know it).
+type Info struct {
+	Path        string `json:"path,omitempty"`
+	Name        string `json:"name"`
+	Vendor      uint16 `json:"vendor"`
+	Product     uint16 `json:"product"`
+	MinX        int32  `json:"min_x,omitempty"`
+	MinY        int32  `json:"min_y,omitempty"`
+	MaxX        int32  `json:"max_x"`
+	MaxY        int32  `json:"max_y"`
+	MaxPressure int32  `json:"max_pressure"`
+	ResX        int32  `json:"res_x"`
+	ResY        int32  `json:"res_y"`
+}
+
+// The surface assumed for a touchpad whose axis ranges are unknown, about
+// that of the GXTP panel the driver was first written for.
+const (
+	DefaultPadWidth  = 4000
+	DefaultPadHeight = 2400
+)
+
+// PadX returns the x coordinate a fraction f of the way across the pad from
+// the left, and PadY the y coordinate a fraction f of the way down.
+func (d Info) PadX(f float64) int32 {
+	if d.MaxX <= d.MinX {
+		return int32(f * DefaultPadWidth)
+	}
+	return d.MinX + int32(f*float64(d.MaxX-d.MinX))
+}
+
+func (d Info) PadY(f float64) int32 {
+	if d.MaxY <= d.MinY {
+		return int32(f * DefaultPadHeight)
+	}
+	return d.MinY + int32(f*float64(d.MaxY-d.MinY))
+}
+
+// Width is the pad's width in touchpad units.
+func (d Info) Width() float64 {
+	return float64(d.PadX(1) - d.PadX(0))
+}
+
+// ReadInfo asks the kernel about the touchpad dev.
+func ReadInfo(dev *evdev.InputDevice) (Info, error) {
+	info := Info{Path: dev.Fn, Name: dev.Name, Vendor: dev.Vendor, Product: dev.Product}
+	raw, err := dev.File.SyscallConn()
+	if err != nil {
+		return info, err
+	}
+	var ioErr error
+	err = raw.Control(func(fd uintptr) {
+		// Pressure has no resolution to speak of.
+		var noRes int32
+		var noMin int32
+		for code, dst := range map[int][3]*int32{
+			evdev.ABS_MT_POSITION_X: {&info.MinX, &info.MaxX, &info.ResX},
+			evdev.ABS_MT_POSITION_Y: {&info.MinY, &info.MaxY, &info.ResY},
+			evdev.ABS_MT_PRESSURE:   {&noMin, &info.MaxPressure, &noRes},
+		} {
+			var abs AbsInfo
+			if abs, ioErr = queryAbs(fd, code); ioErr != nil {
+				return
+			}
+			*dst[0], *dst[1], *dst[2] = abs.Minimum, abs.Maximum, abs.Resolution
+		}
+	})
+	if err != nil {
+		return info, os.ErrClosed
+	}
+	return info, ioErr
+}
+
+// TouchState is the device's current multitouch and button state, as queried
+// after the kernel dropped events.
+type TouchState struct {
+	Slots      Slots
+	ActiveSlot int
+	Fingers    int
+	Touching   bool
+}
+
+// queryTouchState reads the whole touch state back from the kernel, which is
+// how a client recovers from SYN_DROPPED.
+func queryTouchState(dev *evdev.InputDevice) (TouchState, error) {
+	var st TouchState
+	raw, err := dev.File.SyscallConn()
+	if err != nil {
+		return st, err
+	}
+	var ioErr error
+	err = raw.Control(func(fd uintptr) {
+		// struct input_mt_request_layout: the code, then one value per slot.
+		var req [1 + MaxSlots]int32
+		for _, code := range []uint16{evdev.ABS_MT_TRACKING_ID, evdev.ABS_MT_POSITION_X, evdev.ABS_MT_POSITION_Y, evdev.ABS_MT_PRESSURE} {
+			req[0] = int32(code)
+			if ioErr = ioctl(fd, eviocRead(eviocgmtslots, unsafe.Sizeof(req)), uintptr(unsafe.Pointer(&req))); ioErr != nil {
+				return
+			}
+			for i := range st.Slots {
+				v, slot := req[1+i], &st.Slots[i]
+				switch code {
+				case evdev.ABS_MT_TRACKING_ID:
+					slot.Active = v != -1
+				case evdev.ABS_MT_POSITION_X:
+					slot.X = v
+				case evdev.ABS_MT_POSITION_Y:
+					slot.Y = v
+				case evdev.ABS_MT_PRESSURE:
+					slot.P = v
+				}
+			}
+		}
+		for i := range st.Slots {
+			if !st.Slots[i].Active {
+				st.Slots[i] = Slot{}
+			}
+		}
+
+		var abs AbsInfo
+		if abs, ioErr = queryAbs(fd, evdev.ABS_MT_SLOT); ioErr != nil {
+			return
+		}
+		st.ActiveSlot = int(abs.Value)
+
+		var keys [(KEY_MAX + 7) / 8]byte
+		if ioErr = ioctl(fd, eviocRead(eviocgkey, unsafe.Sizeof(keys)), uintptr(unsafe.Pointer(&keys))); ioErr != nil {
+			return
+		}
+		down := func(code int) bool { return keys[code/8]&(1<<(code%8)) != 0 }
+		st.Touching = down(evdev.BTN_TOUCH)
+		switch {
+		case down(evdev.BTN_TOOL_QUINTTAP):
+			st.Fingers = 5
+		case down(evdev.BTN_TOOL_QUADTAP):
+			st.Fingers = 4
+		case down(evdev.BTN_TOOL_TRIPLETAP):
+			st.Fingers = 3
+		case down(evdev.BTN_TOOL_DOUBLETAP):
+			st.Fingers = 2
+		case down(evdev.BTN_TOOL_FINGER):
+			st.Fingers = 1
+		}
+	})
+	if err != nil {
+		return st, os.ErrClosed
+	}
+	return st, ioErr
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"touchpad/uinput"
+)
+
+// hintedError carries remediation steps for an error the user can fix
+// themselves. main prints the hint after the error.
+type hintedError struct {
+	err  error
+	hint string
+}
+
+func (e *hintedError) Error() string { return e.err.Error() }
+func (e *hintedError) Unwrap() error { return e.err }
+
+// withHint attaches hint to err, unless there is none.
+func withHint(err error, hint string) error {
+	if hint == "" {
+		return err
+	}
+	return &hintedError{err, hint}
+}
+
+// errorHint returns the hint attached anywhere in err's chain.
+func errorHint(err error) string {
+	var h *hintedError
+	if errors.As(err, &h) {
+		return h.hint
+	}
+	return ""
+}
+
+// openHint explains why opening the device node at path failed with err and
+// what to do about it.
+func openHint(path string, err error) string {
+	switch {
+	case errors.Is(err, syscall.ENOENT), errors.Is(err, syscall.ENODEV):
+		if !slices.Contains(uinput.Paths, path) {
+			return ""
+		}
+		if _, err := os.Stat("/sys/module/uinput"); err != nil {
+			return "The uinput kernel module is not loaded. Load it with\n" +
+				"    sudo modprobe uinput\n" +
+				"and have it loaded at boot with\n" +
+				"    echo uinput | sudo tee /etc/modules-load.d/uinput.conf"
+		}
+		return "uinput is loaded but /dev/uinput is missing; check that udev is running."
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return accessHint(path)
+	case errors.Is(err, syscall.EBUSY):
+		return busyHint(path)
+	}
+	return ""
+}
+
+// accessHint explains a permission error on path, which usually comes down to
+// not being in the group that owns it.
+func accessHint(path string) string {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return ""
+	}
+	const install = "`sudo touchpad-driver install` adds udev rules that give the input group\n" +
+		"access to the devices, and the logged-in user the touchpad; /dev/uinput\n" +
+		"stays with the input group, which --user installs need to be in."
+
+	group := strconv.Itoa(int(st.Gid))
+	if g, err := user.LookupGroupId(group); err == nil {
+		group = g.Name
+	}
+	groups, _ := os.Getgroups()
+	if int(st.Gid) != os.Getegid() && !slices.Contains(groups, int(st.Gid)) {
+		who := "$USER"
+		if u, err := user.Current(); err == nil {
+			who = u.Username
+		}
+		if int(st.Gid) == 0 {
+			return fmt.Sprintf("%s is only accessible to root. %s", path, install)
+		}
+		return fmt.Sprintf("%s belongs to the %q group, which this process is not in. Add the user with\n"+
+			"    sudo usermod -aG %s %s\n"+
+			"and log in again, or run the driver as a service.\n%s", path, group, group, who, install)
+	}
+	return fmt.Sprintf("%s belongs to the %q group but its mode %o does not let the group in.\n%s",
+		path, group, st.Mode&0o777, install)
+}
+
+// busyHint names the processes that have path open, one of which holds the
+// grab.
+func busyHint(path string) string {
+	holders := deviceHolders(path)
+	if len(holders) == 0 {
+		return fmt.Sprintf("Another program has grabbed %s, e.g. another touchpad-driver or `evtest --grab`.", path)
+	}
+	return fmt.Sprintf("Another program has grabbed %s. It is open in: %s.\n"+
+		"Stop the other driver (or `systemctl stop touchpad-driver`) and try again.",
+		path, strings.Join(holders, ", "))
+}
+
+// deviceHolders lists, as "pid (comm)", other processes with path open.
+// Only processes this one may inspect are found.
+func deviceHolders(path string) []string {
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	self := strconv.Itoa(os.Getpid())
+	var holders []string
+	seen := make(map[string]bool)
+	for _, fd := range fds {
+		pid := strings.Split(fd, "/")[2]
+		if pid == self || seen[pid] {
+			continue
+		}
+		if target, err := os.Readlink(fd); err != nil || target != path {
+			continue
+		}
+		seen[pid] = true
+		comm, _ := os.ReadFile("/proc/" + pid + "/comm")
+		holders = append(holders, fmt.Sprintf("%s (%s)", pid, strings.TrimSpace(string(comm))))
+	}
+	return holders
+}
+
+// inputAccessHint explains an empty device list caused by being unable to
+// read any input device, rather than by the touchpad being absent.
+func inputAccessHint() string {
+	nodes, _ := filepath.Glob("/dev/input/event*")
+	for _, node := range nodes {
+		if unix.Access(node, unix.R_OK) == nil {
+			return ""
+		}
+	}
+	if len(nodes) == 0 {
+		return ""
+	}
+	return "None of the input devices can be read. " + accessHint(nodes[0])
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DormantWatchdog is the watchdog timeout asked of systemd while dormant, so
+// that keepalives need not wake the process either.
+const DormantWatchdog = 24 * time.Hour
+
+// dormancy parks the periodic work (the button failsafe and the watchdog
+// keepalives) once no seat has seen input for a while and nothing is held
+// down. The event loops then sit in epoll_wait with nothing else waking the
+// process, and the next touch brings everything back at once.
+type dormancy struct {
+	after   time.Duration
+	dormant atomic.Bool
+	mu      sync.Mutex
+	// wake is closed when dormancy ends.
+	wake chan struct{}
+}
+
+func newDormancy(after time.Duration) *dormancy {
+	d := &dormancy{after: after, wake: make(chan struct{})}
+	close(d.wake)
+	return d
+}
+
+// Dormant reports whether periodic work should park. A nil dormancy never
+// is.
+func (d *dormancy) Dormant() bool {
+	return d != nil && d.dormant.Load()
+}
+
+// Wait returns a channel that is closed once dormancy ends.
+func (d *dormancy) Wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.wake
+}
+
+// Wake ends dormancy. The event loops call it for every batch, so the
+// common case is a single atomic load.
+func (d *dormancy) Wake() {
+	if d == nil || !d.dormant.Load() {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dormant.Swap(false) {
+		close(d.wake)
+		slog.Debug("input arrived, resuming periodic work")
+	}
+}
+
+func (d *dormancy) enter() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dormant.Load() {
+		return
+	}
+	d.wake = make(chan struct{})
+	d.dormant.Store(true)
+	slog.Debug("no input for a while, parking periodic work", "after", d.after)
+}
+
+// run puts the process to sleep whenever seats have all been untouched for
+// d.after with nothing held down. While input keeps coming it wakes up about
+// once per period.
+func (d *dormancy) run(seats []*seatInstance) {
+	wait := d.after
+	for {
+		time.Sleep(wait)
+		// Until the most recently touched seat has been left alone for
+		// long enough.
+		wait = 0
+		for _, inst := range seats {
+			wait = max(wait, d.after-inst.idle.SinceTouch())
+		}
+		if wait > 0 {
+			continue
+		}
+		if holding(seats) {
+			wait = d.after
+			continue
+		}
+		d.enter()
+		<-d.Wait()
+		wait = d.after
+	}
+}
+
+// Idle parks the periodic work straight away, without waiting for d.after,
+// once logind considers every seat idle and nothing is held down. A nil
+// dormancy does nothing.
+func (d *dormancy) Idle(seats []*seatInstance) {
+	if d == nil || holding(seats) {
+		return
+	}
+	for _, inst := range seats {
+		if !inst.idle.Idle() {
+			return
+		}
+	}
+	d.enter()
+}
+
+// holding reports whether any seat holds a key or button down, which the
+// failsafe has to keep an eye on.
+func holding(seats []*seatInstance) bool {
+	for _, inst := range seats {
+		for _, v := range inst.outputs() {
+			if v.Holding() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"time"
+
+	"touchpad/config"
+)
+
+// AccelGap is the longest pause between two frames of motion that still
+// counts as the same movement for working out its speed.
+const AccelGap = 50 * time.Millisecond
+
+// AccelFrame is the frame interval assumed for the first frame of a movement.
+const AccelFrame = 8 * time.Millisecond
+
+// adaptiveCurve is the adaptive profile, libinput-like: slow movements are
+// slowed further for precision, moderate ones kept as they are, and fast
+// ones sped up the faster they go, up to a limit. Speeds are in touchpad
+// units per millisecond.
+var adaptiveCurve = [][2]float64{{0, 0.6}, {0.25, 1}, {1, 1}, {3, 1.8}}
+
+// pointerAccel works out the acceleration factor for each frame of pointer
+// motion from the speed of the finger, following a curve of (speed,
+// factor) points joined by straight lines and level beyond the ends. All
+// its methods run on the event loop.
+type pointerAccel struct {
+	curve [][2]float64
+	// speed is the finger's recent speed, smoothed over frames; last is
+	// when the previous frame was.
+	speed float64
+	last  time.Time
+}
+
+// newPointerAccel returns nil for flat acceleration.
+func newPointerAccel(p config.Profile) *pointerAccel {
+	switch p.Accel {
+	case config.AccelFlat:
+		return nil
+	case config.AccelCustom:
+		return &pointerAccel{curve: p.AccelCurve}
+	}
+	return &pointerAccel{curve: adaptiveCurve}
+}
+
+// Factor returns the factor for a frame at t that moved dist touchpad
+// units. A nil pointerAccel is flat.
+func (a *pointerAccel) Factor(dist float64, t time.Time) float64 {
+	if a == nil {
+		return 1
+	}
+	dt := t.Sub(a.last)
+	a.last = t
+	if dt <= 0 || dt > AccelGap {
+		// A new movement; its speed so far is only this frame's.
+		a.speed = dist / AccelFrame.Seconds() / 1000
+	} else {
+		a.speed = (a.speed + dist/dt.Seconds()/1000) / 2
+	}
+	return a.at(a.speed)
+}
+
+// at interpolates the curve at speed v.
+func (a *pointerAccel) at(v float64) float64 {
+	c := a.curve
+	if v <= c[0][0] {
+		return c[0][1]
+	}
+	for i := 1; i < len(c); i++ {
+		if v <= c[i][0] {
+			lo, hi := c[i-1], c[i]
+			return lo[1] + (hi[1]-lo[1])*(v-lo[0])/(hi[0]-lo[0])
+		}
+	}
+	return c[len(c)-1][1]
+}
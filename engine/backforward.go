@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"math"
+	"time"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+const (
+	// NavSwipeTime is the longest a flick or edge swipe may take.
+	NavSwipeTime = 300 * time.Millisecond
+	// NavSwipeDistance is how far, as a fraction of the pad's width, it
+	// must travel sideways.
+	NavSwipeDistance = 0.2
+	// NavEdgeWidth is how close to the side, as a fraction of the pad's
+	// width, an edge swipe must start.
+	NavEdgeWidth = 0.05
+)
+
+// navSwipe turns quick horizontal swipes into the back and forward mouse
+// buttons, which browsers and file managers take as navigation whatever the
+// desktop's key bindings. Moving right goes back and moving left forward, as
+// if pushing the page aside. All its methods run on the event loop.
+type navSwipe struct {
+	mode  string
+	width float64
+	// start and startX are when and where the current touch landed; dx and
+	// dy are how far it has moved since.
+	start  time.Time
+	startX int32
+	dx, dy float64
+}
+
+// newNavSwipe returns nil unless p sends back and forward.
+func newNavSwipe(p config.Profile, info device.Info) *navSwipe {
+	if p.BackForward == config.BackForwardOff || info.MaxX <= 0 {
+		return nil
+	}
+	return &navSwipe{mode: p.BackForward, width: float64(info.MaxX)}
+}
+
+// Start begins following a touch that landed at s at time t.
+func (n *navSwipe) Start(s device.Slot, t time.Time) {
+	n.start, n.startX, n.dx, n.dy = t, s.X, 0, 0
+}
+
+// Move adds motion of the touch.
+func (n *navSwipe) Move(dx, dy float64) {
+	n.dx += dx
+	n.dy += dy
+}
+
+// Holding reports whether horizontal scrolling is held back at t, since the
+// two fingers may yet turn out to be a flick.
+func (n *navSwipe) Holding(t time.Time) bool {
+	return n.mode == config.BackForwardFlick && t.Sub(n.start) < NavSwipeTime
+}
+
+// End returns the button a touch of fingers that lifted at t sends, or 0.
+func (n *navSwipe) End(fingers int, t time.Time) uint16 {
+	if t.Sub(n.start) > NavSwipeTime || math.Abs(n.dx) < NavSwipeDistance*n.width || math.Abs(n.dx) < 2*math.Abs(n.dy) {
+		return 0
+	}
+	switch n.mode {
+	case config.BackForwardFlick:
+		if fingers != 2 {
+			return 0
+		}
+	case config.BackForwardEdgeSwipe:
+		// Only a swipe in from the edge counts.
+		edge := int32(NavEdgeWidth * n.width)
+		if fingers != 1 || n.dx > 0 && n.startX > edge || n.dx < 0 && n.startX < int32(n.width)-edge {
+			return 0
+		}
+	}
+	if n.dx > 0 {
+		return device.BTN_SIDE
+	}
+	return device.BTN_EXTRA
+}
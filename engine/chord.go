@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"time"
+
+	"touchpad/device"
+)
+
+// GestureChords are the key chords sent through the virtual keyboard for each
+// gesture. Keys are pressed in order and released in reverse.
+var GestureChords = map[string][]uint16{
+	"swipe-right": {device.KEY_LEFTALT, device.KEY_LEFTSHIFT, device.KEY_TAB},
+	"swipe-left":  {device.KEY_LEFTALT, device.KEY_TAB},
+	"swipe-up":    {device.KEY_LEFTMETA},
+	"swipe-down":  {device.KEY_LEFTMETA, device.KEY_D},
+
+	// Four-finger swipes move between workspaces, open the overview and
+	// show the desktop.
+	"four-finger-swipe-left":  {device.KEY_LEFTCTRL, device.KEY_LEFTMETA, device.KEY_RIGHT},
+	"four-finger-swipe-right": {device.KEY_LEFTCTRL, device.KEY_LEFTMETA, device.KEY_LEFT},
+	"four-finger-swipe-up":    {device.KEY_LEFTMETA},
+	"four-finger-swipe-down":  {device.KEY_LEFTMETA, device.KEY_D},
+}
+
+// ChordHold is how long a gesture's chord stays pressed.
+const ChordHold = 50 * time.Millisecond
+
+// pressChord presses keys now and releases them from loop after ChordHold.
+func pressChord(loop *Loop, vkbd EventSink, keys []uint16) {
+	if len(keys) == 0 {
+		return
+	}
+	for _, k := range keys {
+		vkbd.WriteEvent(device.EV_KEY, k, 1)
+	}
+	vkbd.Syn()
+	vkbd.Flush()
+	loop.After(ChordHold, func() {
+		for i := len(keys) - 1; i >= 0; i-- {
+			vkbd.WriteEvent(device.EV_KEY, keys[i], 0)
+		}
+		vkbd.Syn()
+		vkbd.Flush()
+	})
+}
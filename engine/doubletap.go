@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DoubleTapTime is how soon after a tap the next one must come to make
+	// a double click.
+	DoubleTapTime = 400 * time.Millisecond
+	// DoubleTapDistance is how far apart the two taps may land, in tap
+	// movement limits.
+	DoubleTapDistance = 3
+	// DoubleClickGap is how long the button stays up between the clicks of
+	// a double click.
+	DoubleClickGap = 30 * time.Millisecond
+)
+
+// tapClicker sends the left clicks of one-finger taps. Two taps in quick
+// succession at about the same place make a double click whose first click
+// is over, and the button up for DoubleClickGap, before the second begins,
+// however the taps' events were batched or the release timer delayed. All
+// its methods run on the event loop.
+type tapClicker struct {
+	loop           *Loop
+	press, release func()
+
+	// held is set while a click's button is down; gen numbers the clicks,
+	// so a scheduled release can tell whether its click is still the one
+	// held.
+	held       bool
+	gen        int
+	releasedAt time.Time
+	// last, lastX and lastY are when and where the tap that may start a
+	// double click was.
+	last         time.Time
+	lastX, lastY int32
+}
+
+func newTapClicker(loop *Loop, press, release func()) *tapClicker {
+	return &tapClicker{loop: loop, press: press, release: release}
+}
+
+// Tap clicks for a tap at x, y at time t and reports whether that made the
+// second click of a double click; limit is the tap movement limit. hold, if
+// not nil, takes the button over once pressed, instead of it being released
+// after TapHold.
+func (c *tapClicker) Tap(x, y int32, t time.Time, limit float64, hold func()) bool {
+	double := !c.last.IsZero() && t.Sub(c.last) < DoubleTapTime &&
+		math.Hypot(float64(x-c.lastX), float64(y-c.lastY)) < DoubleTapDistance*limit
+	if double {
+		// A third tap starts the next pair.
+		c.last = time.Time{}
+	} else {
+		c.last, c.lastX, c.lastY = t, x, y
+	}
+
+	var wait time.Duration
+	if c.held {
+		c.Release()
+		wait = DoubleClickGap
+	} else if double {
+		wait = DoubleClickGap - time.Since(c.releasedAt)
+	}
+	click := func() {
+		c.press()
+		c.held = true
+		c.gen++
+		if hold != nil {
+			hold()
+			return
+		}
+		gen := c.gen
+		c.loop.After(TapHold, func() {
+			if c.gen == gen {
+				c.Release()
+			}
+		})
+	}
+	if wait > 0 {
+		c.loop.After(wait, click)
+	} else {
+		click()
+	}
+	return double
+}
+
+// Release lets go of the button, if a click holds it.
+func (c *tapClicker) Release() {
+	if c.held {
+		c.held = false
+		c.releasedAt = time.Now()
+		c.release()
+	}
+}
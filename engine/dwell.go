@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"time"
+
+	"touchpad/config"
+)
+
+// dwellClicker clicks once the pointer has rested for the dwell time after
+// moving, optionally announcing the click shortly before so an OSD can show
+// it coming. It keeps at most one check scheduled on the loop, and all its
+// methods run on the event loop.
+type dwellClicker struct {
+	loop        *Loop
+	dwell, warn time.Duration
+	onWarn      func()
+	onClick     func()
+
+	lastMove time.Time
+	// armed is set while a check is scheduled; warned and done record how
+	// far the current rest has got.
+	armed, warned, done bool
+}
+
+// newDwellClicker returns nil when dwell clicking is off.
+func newDwellClicker(loop *Loop, p config.Profile, onWarn, onClick func()) *dwellClicker {
+	if p.DwellClickMs <= 0 {
+		return nil
+	}
+	return &dwellClicker{
+		loop:    loop,
+		dwell:   time.Duration(p.DwellClickMs) * time.Millisecond,
+		warn:    min(time.Duration(p.DwellWarningMs)*time.Millisecond, time.Duration(p.DwellClickMs)*time.Millisecond),
+		onWarn:  onWarn,
+		onClick: onClick,
+		done:    true,
+	}
+}
+
+// Moved starts a new rest.
+func (d *dwellClicker) Moved() {
+	d.lastMove = time.Now()
+	d.warned, d.done = false, false
+	d.arm()
+}
+
+// Cancel skips the click for the current rest, e.g. because the user
+// clicked themselves.
+func (d *dwellClicker) Cancel() {
+	d.done = true
+}
+
+func (d *dwellClicker) arm() {
+	if d.armed || d.done {
+		return
+	}
+	d.armed = true
+	d.loop.After(d.wait(), d.check)
+}
+
+// wait returns the time until the next step: the warning, then the click.
+func (d *dwellClicker) wait() time.Duration {
+	at := d.lastMove.Add(d.dwell)
+	if d.warn > 0 && !d.warned {
+		at = at.Add(-d.warn)
+	}
+	return time.Until(at)
+}
+
+func (d *dwellClicker) check() {
+	d.armed = false
+	switch {
+	case d.done:
+	case d.wait() > 0:
+		// Moved again since this was scheduled.
+		d.arm()
+	case d.warn > 0 && !d.warned:
+		d.warned = true
+		d.onWarn()
+		d.arm()
+	default:
+		d.done = true
+		d.onClick()
+	}
+}
@@ -0,0 +1,197 @@
+// Package engine is the gesture and pointer state machine: it turns a
+// touchpad's events into those of the virtual devices. It reads from an
+// EventSource and writes to EventSinks, so it runs the same on a grabbed
+// touchpad, a recording being played back or a test's fake devices.
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+// EventSource is where the state machine's input comes from: a grabbed
+// touchpad, or anything else that produces the same events, such as a
+// recording being played back.
+type EventSource interface {
+	// Path names the source in event dumps and crash reports.
+	Path() string
+	// Fd is what the event loop waits on for input, and Read returns the
+	// events pending without blocking, none if there are none yet.
+	Fd() (int, error)
+	Read() ([]evdev.InputEvent, error)
+	// Info describes the device, and Capabilities lists the codes it
+	// reports by event type.
+	Info() (device.Info, error)
+	Capabilities() map[int][]int
+	// TouchState returns the contacts as they are now, to carry on from
+	// after the kernel dropped events.
+	TouchState() (device.TouchState, error)
+}
+
+// EventSink is a virtual device the state machine writes to. Events are
+// queued until Flush sends them.
+type EventSink interface {
+	WriteEvent(typ, code uint16, value int32)
+	// Syn ends a report.
+	Syn()
+	Flush()
+	// Stamp notes the kernel time of the input behind the events queued
+	// next, for measuring latency.
+	Stamp(t time.Time)
+	// ReleaseAll lifts every button and key still held, sending the
+	// releases straight away.
+	ReleaseAll()
+}
+
+// Outputs are the devices a touchpad drives. Absolute and Touchscreen are
+// nil unless a profile uses them.
+type Outputs struct {
+	Mouse, Keyboard       EventSink
+	Absolute, Touchscreen EventSink
+}
+
+// pointer returns the device that pointer input goes to under p.
+func (o Outputs) pointer(p *config.Profile) EventSink {
+	switch p.Output {
+	case config.OutputAbsolute:
+		return o.Absolute
+	case config.OutputTouchscreen:
+		return o.Touchscreen
+	}
+	return o.Mouse
+}
+
+// releaseAll lifts every button and key still held on the outputs.
+func (o Outputs) releaseAll() {
+	for _, v := range []EventSink{o.Mouse, o.Keyboard, o.Absolute, o.Touchscreen} {
+		if v != nil {
+			v.ReleaseAll()
+		}
+	}
+}
+
+// Status is told what the touch is doing, and says whether the touchpad is
+// enabled.
+type Status interface {
+	Active() bool
+	SetFingers(n int)
+	SetGesture(name string)
+	SetOneFingerScroll(on bool)
+}
+
+// Publisher takes the processed-event stream. Active reports whether anyone
+// is listening, so frames are only put together for them.
+type Publisher interface {
+	Active() bool
+	Publish(ev StreamEvent)
+}
+
+// Tracer sees the raw events and the frames made of them, for
+// --debug-events.
+type Tracer interface {
+	Raw(path string, ev evdev.InputEvent)
+	Frame(path string, at time.Time, fingers int, mode string, clicked bool, slots *device.Slots)
+}
+
+// Host is what the state machine asks of the driver around it. Its methods
+// are called on the event loop.
+type Host interface {
+	// Beep sounds the feedback beep, if it is on.
+	Beep()
+	// CycleProfile switches to the next profile of the cycle.
+	CycleProfile()
+	// RunCommand runs a hot zone's shell command.
+	RunCommand(command string)
+	// Dispatch carries out a gesture some other way than its key chord,
+	// reporting false if it didn't.
+	Dispatch(gesture string) bool
+	// Typing reports whether a key was typed less than window before t,
+	// Blanked whether t falls in the window after resume in which new
+	// touches are ignored, and Saving whether battery-saver mode is on.
+	Typing(t time.Time, window time.Duration) bool
+	Blanked(t time.Time) bool
+	Saving() bool
+	// Rotate turns motion to match the screen orientation.
+	Rotate(dx, dy float64) (float64, float64)
+	// Input sees each batch of events before it is processed, and Frame
+	// the contacts of each frame unless the profile is low latency.
+	Input(events []evdev.InputEvent)
+	Frame(slots *device.Slots)
+	// Crashed reports a panic of the state machine, with its value and
+	// stack and the touch state it was in.
+	Crashed(path string, value any, stack []byte, state string)
+}
+
+// Engine runs the state machine for one touchpad. Its fields are set up
+// before Run; the atomics may be used from any goroutine.
+type Engine struct {
+	// Seat names the touchpad in logs.
+	Seat string
+	Loop *Loop
+	// Profile returns the current profile; a new one is picked up between
+	// batches.
+	Profile func() *config.Profile
+	Outputs Outputs
+	Status  Status
+	Host    Host
+	// Events and Trace may be nil.
+	Events Publisher
+	Trace  Tracer
+	// CycleGesture is the gesture that cycles profiles, "" for none.
+	CycleGesture string
+
+	Metrics   Metrics
+	Pressure  PressureLearner
+	Heartbeat Heartbeat
+
+	// DragLocked is set while a sticky drag holds the left button.
+	DragLocked atomic.Bool
+	// OneFingerScroll is set while one finger scrolls rather than points.
+	OneFingerScroll atomic.Bool
+	// Locked is set while the seat's session is locked; gestures are not
+	// sent then, since the chords would go to the lock screen.
+	Locked atomic.Bool
+	// TouchPressure is the first contact's pressure as of the last frame.
+	TouchPressure atomic.Int32
+
+	// touch tracks what the touchscreen has been told.
+	touch touchEmitter
+}
+
+// SetOneFingerScroll switches one-finger scrolling on or off.
+func (e *Engine) SetOneFingerScroll(on bool) {
+	e.OneFingerScroll.Store(on)
+	e.Status.SetOneFingerScroll(on)
+}
+
+// Pointer returns the device that pointer input goes to under the current
+// profile.
+func (e *Engine) Pointer() EventSink {
+	return e.Outputs.pointer(e.Profile())
+}
+
+// ReleaseAll lifts every button and key still held on the outputs.
+func (e *Engine) ReleaseAll() {
+	e.Outputs.releaseAll()
+}
+
+func (e *Engine) publish(ev StreamEvent) {
+	if e.Events != nil {
+		e.Events.Publish(ev)
+	}
+}
+
+// runZoneAction carries out a zone's key chord or command; buttons are
+// left to the caller, which knows how the click goes.
+func (e *Engine) runZoneAction(a config.ZoneAction) {
+	if a.Command != "" {
+		e.Host.RunCommand(a.Command)
+		return
+	}
+	pressChord(e.Loop, e.Outputs.Keyboard, a.Chord)
+}
@@ -0,0 +1,505 @@
+package engine
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+	"golang.org/x/sys/unix"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+// fakeSource is an EventSource whose batches a test pushes. Each push makes
+// a pipe readable, so the loop wakes for it as it would for a touchpad.
+type fakeSource struct {
+	r, w    int
+	batches chan []evdev.InputEvent
+	// state is what TouchState reports after a SYN_DROPPED; without it the
+	// query fails.
+	state *device.TouchState
+}
+
+func newFakeSource(t *testing.T) *fakeSource {
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		unix.Close(p[0])
+		unix.Close(p[1])
+	})
+	return &fakeSource{r: p[0], w: p[1], batches: make(chan []evdev.InputEvent, 16)}
+}
+
+// push hands the state machine a batch of events, given as type, code and
+// value triples, stamped with the current time.
+func (s *fakeSource) push(events ...[3]int32) {
+	tv := syscall.NsecToTimeval(time.Now().UnixNano())
+	batch := make([]evdev.InputEvent, len(events))
+	for i, ev := range events {
+		batch[i] = evdev.InputEvent{Time: tv, Type: uint16(ev[0]), Code: uint16(ev[1]), Value: ev[2]}
+	}
+	s.batches <- batch
+	unix.Write(s.w, []byte{0})
+}
+
+// end has Read return io.EOF once the batches pushed so far are read.
+func (s *fakeSource) end() {
+	close(s.batches)
+	unix.Write(s.w, []byte{0})
+}
+
+func (s *fakeSource) Path() string     { return "fake" }
+func (s *fakeSource) Fd() (int, error) { return s.r, nil }
+
+func (s *fakeSource) Read() ([]evdev.InputEvent, error) {
+	var b [1]byte
+	if n, _ := unix.Read(s.r, b[:]); n == 0 {
+		return nil, nil
+	}
+	batch, ok := <-s.batches
+	if !ok {
+		return nil, io.EOF
+	}
+	return batch, nil
+}
+
+func (s *fakeSource) Info() (device.Info, error) {
+	return device.Info{Name: "fake", MaxX: 3500, MaxY: 2300, MaxPressure: 255, ResX: 31, ResY: 31}, nil
+}
+
+func (s *fakeSource) Capabilities() map[int][]int {
+	return map[int][]int{
+		evdev.EV_KEY: {evdev.BTN_LEFT, evdev.BTN_TOUCH, evdev.BTN_TOOL_FINGER, evdev.BTN_TOOL_DOUBLETAP},
+		evdev.EV_ABS: {evdev.ABS_X, evdev.ABS_Y, evdev.ABS_MT_SLOT, evdev.ABS_MT_POSITION_X, evdev.ABS_MT_POSITION_Y, evdev.ABS_MT_TRACKING_ID, evdev.ABS_MT_PRESSURE},
+	}
+}
+
+func (s *fakeSource) TouchState() (device.TouchState, error) {
+	if s.state == nil {
+		return device.TouchState{}, errNoTouchState
+	}
+	return *s.state, nil
+}
+
+var errNoTouchState = errors.New("no touch state")
+
+// fakeSink is an EventSink that keeps the key events it is sent and adds up
+// its relative motion.
+type fakeSink struct {
+	mu   sync.Mutex
+	keys [][2]int32
+	rel  map[uint16]int32
+}
+
+func (s *fakeSink) WriteEvent(typ, code uint16, value int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch typ {
+	case device.EV_KEY:
+		s.keys = append(s.keys, [2]int32{int32(code), value})
+	case device.EV_REL:
+		if s.rel == nil {
+			s.rel = make(map[uint16]int32)
+		}
+		s.rel[code] += value
+	}
+}
+
+func (s *fakeSink) Syn()              {}
+func (s *fakeSink) Flush()            {}
+func (s *fakeSink) Stamp(t time.Time) {}
+func (s *fakeSink) ReleaseAll()       {}
+
+func (s *fakeSink) Keys() [][2]int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.keys)
+}
+
+// Rel returns the sum of the relative motion sent on axis code.
+func (s *fakeSink) Rel(code uint16) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rel[code]
+}
+
+type fakeStatus struct{}
+
+func (fakeStatus) Active() bool               { return true }
+func (fakeStatus) SetFingers(n int)           {}
+func (fakeStatus) SetGesture(name string)     {}
+func (fakeStatus) SetOneFingerScroll(on bool) {}
+
+type fakeHost struct{}
+
+func (fakeHost) Beep()                                                      {}
+func (fakeHost) CycleProfile()                                              {}
+func (fakeHost) RunCommand(command string)                                  {}
+func (fakeHost) Dispatch(gesture string) bool                               { return false }
+func (fakeHost) Typing(t time.Time, window time.Duration) bool              { return false }
+func (fakeHost) Blanked(t time.Time) bool                                   { return false }
+func (fakeHost) Saving() bool                                               { return false }
+func (fakeHost) Rotate(dx, dy float64) (float64, float64)                   { return dx, dy }
+func (fakeHost) Input(events []evdev.InputEvent)                            {}
+func (fakeHost) Frame(slots *device.Slots)                                  {}
+func (fakeHost) Crashed(path string, value any, stack []byte, state string) {}
+
+// TestTap runs a one-finger tap through the state machine on fake devices
+// and checks that it clicks the left button of the mouse.
+func TestTap(t *testing.T) {
+	loop, err := NewLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	profile := config.DefaultProfile()
+	mouse, kbd := &fakeSink{}, &fakeSink{}
+	e := &Engine{
+		Seat:    "seat0",
+		Loop:    loop,
+		Profile: func() *config.Profile { return &profile },
+		Outputs: Outputs{Mouse: mouse, Keyboard: kbd},
+		Status:  fakeStatus{},
+		Host:    fakeHost{},
+	}
+	src := newFakeSource(t)
+	done := make(chan error, 1)
+	go func() { done <- e.Run(src) }()
+
+	src.push(
+		[3]int32{device.EV_ABS, device.ABS_MT_SLOT, 0},
+		[3]int32{device.EV_ABS, device.ABS_MT_TRACKING_ID, 1},
+		[3]int32{device.EV_ABS, device.ABS_MT_POSITION_X, 1700},
+		[3]int32{device.EV_ABS, device.ABS_MT_POSITION_Y, 1100},
+		[3]int32{device.EV_ABS, evdev.ABS_MT_PRESSURE, 60},
+		[3]int32{device.EV_KEY, device.BTN_TOUCH, 1},
+		[3]int32{device.EV_KEY, evdev.BTN_TOOL_FINGER, 1},
+		[3]int32{device.EV_SYN, device.SYN_REPORT, 0},
+	)
+	time.Sleep(10 * time.Millisecond)
+	src.push(
+		[3]int32{device.EV_ABS, device.ABS_MT_TRACKING_ID, -1},
+		[3]int32{device.EV_KEY, device.BTN_TOUCH, 0},
+		[3]int32{device.EV_KEY, evdev.BTN_TOOL_FINGER, 0},
+		[3]int32{device.EV_SYN, device.SYN_REPORT, 0},
+	)
+
+	// The button is let go of TapHold after the tap, from the loop.
+	want := [][2]int32{{device.BTN_LEFT, 1}, {device.BTN_LEFT, 0}}
+	deadline := time.Now().Add(time.Second)
+	for !slices.Equal(mouse.Keys(), want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	src.end()
+	if err := <-done; !errors.Is(err, io.EOF) {
+		t.Fatalf("Run returned %v, want io.EOF", err)
+	}
+	if got := mouse.Keys(); !slices.Equal(got, want) {
+		t.Errorf("mouse got keys %v, want %v", got, want)
+	}
+	if got := kbd.Keys(); len(got) != 0 {
+		t.Errorf("keyboard got keys %v, want none", got)
+	}
+	if got := e.Metrics.Taps.Load(); got != 1 {
+		t.Errorf("counted %d taps, want 1", got)
+	}
+}
+
+// fakePad turns the positions of the fingers on a fake touchpad into the
+// frames a multitouch touchpad sends for them.
+type fakePad struct {
+	src     *fakeSource
+	fingers int
+	nextID  int32
+}
+
+var toolKeys = [...]int32{evdev.BTN_TOOL_FINGER, evdev.BTN_TOOL_DOUBLETAP, evdev.BTN_TOOL_TRIPLETAP}
+
+// frame pushes a frame with a finger at each of pts, in slots from 0 up;
+// fingers no longer given lift, and a frame without any ends the touch.
+func (p *fakePad) frame(pts ...[2]int32) {
+	var events [][3]int32
+	for i, pt := range pts {
+		events = append(events, [3]int32{device.EV_ABS, device.ABS_MT_SLOT, int32(i)})
+		if i >= p.fingers {
+			p.nextID++
+			events = append(events, [3]int32{device.EV_ABS, device.ABS_MT_TRACKING_ID, p.nextID})
+		}
+		events = append(events,
+			[3]int32{device.EV_ABS, device.ABS_MT_POSITION_X, pt[0]},
+			[3]int32{device.EV_ABS, device.ABS_MT_POSITION_Y, pt[1]},
+			[3]int32{device.EV_ABS, evdev.ABS_MT_PRESSURE, 60},
+		)
+	}
+	for i := len(pts); i < p.fingers; i++ {
+		events = append(events,
+			[3]int32{device.EV_ABS, device.ABS_MT_SLOT, int32(i)},
+			[3]int32{device.EV_ABS, device.ABS_MT_TRACKING_ID, -1},
+		)
+	}
+	if p.fingers == 0 && len(pts) > 0 {
+		events = append(events, [3]int32{device.EV_KEY, device.BTN_TOUCH, 1})
+	} else if p.fingers > 0 && len(pts) == 0 {
+		events = append(events, [3]int32{device.EV_KEY, device.BTN_TOUCH, 0})
+	}
+	if p.fingers != len(pts) {
+		if p.fingers > 0 {
+			events = append(events, [3]int32{device.EV_KEY, toolKeys[p.fingers-1], 0})
+		}
+		if len(pts) > 0 {
+			events = append(events, [3]int32{device.EV_KEY, toolKeys[len(pts)-1], 1})
+		}
+	}
+	events = append(events, [3]int32{device.EV_SYN, device.SYN_REPORT, 0})
+	p.fingers = len(pts)
+	p.src.push(events...)
+}
+
+// slide moves the fingers at pts by dx, dy in steps frames, a few
+// milliseconds apart.
+func (p *fakePad) slide(steps int, dx, dy int32, pts ...[2]int32) {
+	for range steps {
+		for i := range pts {
+			pts[i][0] += dx
+			pts[i][1] += dy
+		}
+		time.Sleep(2 * time.Millisecond)
+		p.frame(pts...)
+	}
+}
+
+// tap puts fingers down at pts and lifts them again shortly after.
+func (p *fakePad) tap(pts ...[2]int32) {
+	p.frame(pts...)
+	time.Sleep(10 * time.Millisecond)
+	p.frame()
+}
+
+// waitKeys waits up to a second for sink to have sent want.
+func waitKeys(sink *fakeSink, want [][2]int32) {
+	deadline := time.Now().Add(time.Second)
+	for !slices.Equal(sink.Keys(), want) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestTouches runs touches through the state machine on fake devices and
+// checks the buttons and scrolling they come out as.
+func TestTouches(t *testing.T) {
+	one := [2]int32{1700, 1100}
+	two := [][2]int32{{1500, 1100}, {2000, 1100}}
+	three := [][2]int32{{1300, 1100}, {1700, 1100}, {2100, 1100}}
+
+	for _, tc := range []struct {
+		name    string
+		profile func(p *config.Profile)
+		touch   func(t *testing.T, pad *fakePad, e *Engine, mouse *fakeSink)
+		keys    [][2]int32
+		scrolls bool
+		taps    uint64
+	}{
+		{
+			name:  "two-finger tap",
+			touch: func(t *testing.T, pad *fakePad, e *Engine, mouse *fakeSink) { pad.tap(two...) },
+			keys:  [][2]int32{{device.BTN_RIGHT, 1}, {device.BTN_RIGHT, 0}},
+			taps:  1,
+		},
+		{
+			// Moving less than a tap may is no scroll yet.
+			name: "two-finger tap with a wobble",
+			touch: func(t *testing.T, pad *fakePad, e *Engine, mouse *fakeSink) {
+				pad.frame(two...)
+				pad.slide(2, 0, 5, slices.Clone(two)...)
+				pad.frame()
+			},
+			keys: [][2]int32{{device.BTN_RIGHT, 1}, {device.BTN_RIGHT, 0}},
+			taps: 1,
+		},
+		{
+			name: "two-finger scroll",
+			touch: func(t *testing.T, pad *fakePad, e *Engine, mouse *fakeSink) {
+				pad.frame(two...)
+				pad.slide(10, 0, 20, slices.Clone(two)...)
+				pad.frame()
+			},
+			scrolls: true,
+		},
+		{
+			// The fingers lift and come down again within the grace
+			// period, which carries the drag on; it ends once the
+			// period lapses.
+			name: "three-finger drag grace",
+			profile: func(p *config.Profile) {
+				p.ThreeFingerDrag = "left"
+				p.ThreeFingerDragMs = 150
+			},
+			touch: func(t *testing.T, pad *fakePad, e *Engine, mouse *fakeSink) {
+				pad.frame(three...)
+				pad.slide(5, 20, 0, slices.Clone(three)...)
+				pad.frame()
+				time.Sleep(20 * time.Millisecond)
+				pad.frame(three...)
+				pad.slide(5, 20, 0, slices.Clone(three)...)
+				pad.frame()
+				time.Sleep(20 * time.Millisecond)
+				if got, want := mouse.Keys(), [][2]int32{{device.BTN_LEFT, 1}}; !slices.Equal(got, want) {
+					t.Errorf("within the grace period mouse got keys %v, want %v", got, want)
+				}
+			},
+			keys: [][2]int32{{device.BTN_LEFT, 1}, {device.BTN_LEFT, 0}},
+		},
+		{
+			// A tap during the grace period ends the drag instead of
+			// clicking.
+			name: "three-finger drag cancel",
+			profile: func(p *config.Profile) {
+				p.ThreeFingerDrag = "left"
+				p.ThreeFingerDragMs = 500
+			},
+			touch: func(t *testing.T, pad *fakePad, e *Engine, mouse *fakeSink) {
+				pad.frame(three...)
+				pad.slide(5, 20, 0, slices.Clone(three)...)
+				pad.frame()
+				time.Sleep(20 * time.Millisecond)
+				pad.tap(one)
+			},
+			keys: [][2]int32{{device.BTN_LEFT, 1}, {device.BTN_LEFT, 0}},
+		},
+		{
+			// One tap presses the button and holds it, the next lets go.
+			name:    "sticky drag",
+			profile: func(p *config.Profile) { p.StickyDrag = true },
+			touch: func(t *testing.T, pad *fakePad, e *Engine, mouse *fakeSink) {
+				pad.tap(one)
+				time.Sleep(3 * TapHold)
+				if got, want := mouse.Keys(), [][2]int32{{device.BTN_LEFT, 1}}; !slices.Equal(got, want) {
+					t.Errorf("after the first tap mouse got keys %v, want %v", got, want)
+				}
+				if !e.DragLocked.Load() {
+					t.Error("the first tap didn't lock the drag")
+				}
+				pad.frame(one)
+				pad.slide(5, 20, 0, one)
+				pad.frame()
+				pad.tap(one)
+			},
+			keys: [][2]int32{{device.BTN_LEFT, 1}, {device.BTN_LEFT, 0}},
+			taps: 1,
+		},
+		{
+			// The touch state after a SYN_DROPPED moved the finger:
+			// carrying on from there neither jumps nor, as the touch
+			// lost its start, taps.
+			name: "resync after SYN_DROPPED",
+			touch: func(t *testing.T, pad *fakePad, e *Engine, mouse *fakeSink) {
+				pad.frame(one)
+				var st device.TouchState
+				st.Slots[0] = device.Slot{Active: true, X: one[0] + 200, Y: one[1], P: 60}
+				st.Fingers, st.Touching = 1, true
+				pad.src.state = &st
+				pad.src.push(
+					[3]int32{device.EV_SYN, evdev.SYN_DROPPED, 0},
+					[3]int32{device.EV_ABS, device.ABS_MT_POSITION_X, 100},
+					[3]int32{device.EV_SYN, device.SYN_REPORT, 0},
+				)
+				pad.frame([2]int32{one[0] + 200, one[1]})
+				pad.frame()
+				time.Sleep(3 * TapHold)
+				if got := mouse.Rel(device.REL_X); got != 0 {
+					t.Errorf("pointer moved %d across the resync, want 0", got)
+				}
+				if got := e.Metrics.Dropped.Load(); got != 1 {
+					t.Errorf("counted %d drops, want 1", got)
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			loop, err := NewLoop()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer loop.Close()
+			profile := config.DefaultProfile()
+			if tc.profile != nil {
+				tc.profile(&profile)
+			}
+			mouse, kbd := &fakeSink{}, &fakeSink{}
+			e := &Engine{
+				Seat:    "seat0",
+				Loop:    loop,
+				Profile: func() *config.Profile { return &profile },
+				Outputs: Outputs{Mouse: mouse, Keyboard: kbd},
+				Status:  fakeStatus{},
+				Host:    fakeHost{},
+			}
+			pad := &fakePad{src: newFakeSource(t)}
+			done := make(chan error, 1)
+			go func() { done <- e.Run(pad.src) }()
+
+			tc.touch(t, pad, e, mouse)
+			waitKeys(mouse, tc.keys)
+			// Anything that would follow the expected keys has time to.
+			time.Sleep(3 * TapHold)
+			pad.src.end()
+			if err := <-done; !errors.Is(err, io.EOF) {
+				t.Fatalf("Run returned %v, want io.EOF", err)
+			}
+			if got := mouse.Keys(); !slices.Equal(got, tc.keys) {
+				t.Errorf("mouse got keys %v, want %v", got, tc.keys)
+			}
+			if got := mouse.Rel(device.REL_WHEEL) != 0; got != tc.scrolls {
+				t.Errorf("scrolled: %v, want %v", got, tc.scrolls)
+			}
+			if got := kbd.Keys(); len(got) != 0 {
+				t.Errorf("keyboard got keys %v, want none", got)
+			}
+			if got := e.Metrics.Taps.Load(); got != tc.taps {
+				t.Errorf("counted %d taps, want %d", got, tc.taps)
+			}
+		})
+	}
+}
+
+// TestResyncFails checks that Run gives up, rather than carrying on from a
+// state it has lost, when the touch state can't be read after a SYN_DROPPED.
+func TestResyncFails(t *testing.T) {
+	loop, err := NewLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	profile := config.DefaultProfile()
+	e := &Engine{
+		Seat:    "seat0",
+		Loop:    loop,
+		Profile: func() *config.Profile { return &profile },
+		Outputs: Outputs{Mouse: &fakeSink{}, Keyboard: &fakeSink{}},
+		Status:  fakeStatus{},
+		Host:    fakeHost{},
+	}
+	src := newFakeSource(t)
+	done := make(chan error, 1)
+	go func() { done <- e.Run(src) }()
+
+	src.push(
+		[3]int32{device.EV_SYN, evdev.SYN_DROPPED, 0},
+		[3]int32{device.EV_SYN, device.SYN_REPORT, 0},
+	)
+	select {
+	case err := <-done:
+		if !errors.Is(err, errNoTouchState) {
+			t.Errorf("Run returned %v, want %v", err, errNoTouchState)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return")
+	}
+}
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"time"
+
+	"touchpad/config"
+)
+
+// fingerDragState is where a three-finger drag stands.
+type fingerDragState int
+
+const (
+	fingerDragIdle fingerDragState = iota
+	// fingerDragDragging holds the button while three fingers are down.
+	fingerDragDragging
+	// fingerDragLifted holds it for the grace period after they lifted.
+	fingerDragLifted
+)
+
+// fingerDragger turns three fingers moving together into a drag, as on a
+// Mac: the button goes down as they start moving, and after they lift it
+// stays down a moment longer, so they can be put down further back to carry
+// on. Any other touch in that moment ends the drag. All its methods run on
+// the event loop.
+type fingerDragger struct {
+	loop   *Loop
+	grace  time.Duration
+	button uint16
+	send   func(button uint16, value int32)
+
+	state fingerDragState
+	// gen counts lifts, so that a grace period that lapses can tell
+	// whether it is still the current one.
+	gen int
+}
+
+// newFingerDragger returns nil unless p drags with three fingers. send
+// pushes and lets go of the button.
+func newFingerDragger(loop *Loop, p config.Profile, send func(button uint16, value int32)) *fingerDragger {
+	button, ok := config.ButtonCode(p.ThreeFingerDrag)
+	if !ok {
+		return nil
+	}
+	return &fingerDragger{
+		loop:   loop,
+		grace:  time.Duration(p.ThreeFingerDragMs) * time.Millisecond,
+		button: button,
+		send:   send,
+	}
+}
+
+// Move notes three fingers moving, which starts or picks up the drag.
+func (d *fingerDragger) Move() {
+	switch d.state {
+	case fingerDragIdle:
+		d.send(d.button, 1)
+	case fingerDragLifted:
+		d.gen++
+	}
+	d.state = fingerDragDragging
+}
+
+// Dragging reports whether the fingers of a drag are down, when their
+// motion is the drag's and not for pointing or scrolling, down to the last
+// one to lift.
+func (d *fingerDragger) Dragging() bool {
+	return d.state == fingerDragDragging
+}
+
+// Up ends a touch and reports whether it was the drag's, in which case it
+// was no tap. A touch during the grace period that didn't pick the drag up
+// ends it, and doesn't click either.
+func (d *fingerDragger) Up() bool {
+	switch d.state {
+	case fingerDragDragging:
+		d.state = fingerDragLifted
+		d.gen++
+		gen := d.gen
+		d.loop.After(d.grace, func() {
+			if d.gen == gen && d.state == fingerDragLifted {
+				d.Cancel()
+			}
+		})
+		return true
+	case fingerDragLifted:
+		d.Cancel()
+		return true
+	}
+	return false
+}
+
+// Cancel lets go of the button, if held.
+func (d *fingerDragger) Cancel() {
+	if d.state != fingerDragIdle {
+		d.state = fingerDragIdle
+		d.send(d.button, 0)
+	}
+}
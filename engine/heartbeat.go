@@ -0,0 +1,22 @@
+package engine
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat records whether the event loop is waiting for input or busy
+// processing it. A loop blocked in read on an idle touchpad is healthy; one
+// that has been processing the same batch for a whole watchdog period is not.
+type Heartbeat struct {
+	busySince atomic.Int64
+}
+
+func (h *Heartbeat) Busy() { h.busySince.Store(time.Now().UnixNano()) }
+func (h *Heartbeat) Idle() { h.busySince.Store(0) }
+
+// Healthy reports whether the loop has been busy for less than limit.
+func (h *Heartbeat) Healthy(limit time.Duration) bool {
+	since := h.busySince.Load()
+	return since == 0 || time.Since(time.Unix(0, since)) < limit
+}
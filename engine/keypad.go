@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"time"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+// KeypadRepeatInterval is how often a held keypad key repeats.
+const KeypadRepeatInterval = 100 * time.Millisecond
+
+// keypad turns the touchpad into a grid of keys: a tap presses the key under
+// the finger and, with hold-to-repeat, keeping the finger on a key repeats it.
+// Sliding off a key cancels it. All its methods run on the event loop.
+type keypad struct {
+	loop       *Loop
+	kbd        EventSink
+	grid       [][]uint16
+	maxX, maxY int32
+	repeat     time.Duration
+
+	// key is the key under the current touch, 0 once it has slid off.
+	key uint16
+	// repeating is set once the key has started repeating; lifting the
+	// finger then sends nothing more.
+	repeating bool
+	// touch counts touches, so timers left from an earlier one do nothing.
+	touch int
+}
+
+// newKeypad returns nil unless p is a keypad profile. The layout has been
+// checked when the seat was opened.
+func newKeypad(loop *Loop, kbd EventSink, p config.Profile, info device.Info) *keypad {
+	if !p.Keypad {
+		return nil
+	}
+	grid, err := config.ParseKeypadLayout(p.KeypadLayout)
+	if err != nil {
+		return nil
+	}
+	return &keypad{
+		loop:   loop,
+		kbd:    kbd,
+		grid:   grid,
+		maxX:   max(info.MaxX, 1),
+		maxY:   max(info.MaxY, 1),
+		repeat: time.Duration(p.KeypadRepeatMs) * time.Millisecond,
+	}
+}
+
+func (k *keypad) keyAt(x, y int32) uint16 {
+	r := int(int64(min(max(y, 0), k.maxY-1)) * int64(len(k.grid)) / int64(k.maxY))
+	row := k.grid[r]
+	c := int(int64(min(max(x, 0), k.maxX-1)) * int64(len(row)) / int64(k.maxX))
+	return row[c]
+}
+
+// Down starts a touch at x, y.
+func (k *keypad) Down(x, y int32) {
+	k.touch++
+	k.key, k.repeating = k.keyAt(x, y), false
+	if k.key == 0 || k.repeat <= 0 {
+		return
+	}
+	touch := k.touch
+	k.loop.After(k.repeat, func() {
+		if k.touch == touch && k.key != 0 {
+			k.repeating = true
+			k.autorepeat(touch)
+		}
+	})
+}
+
+func (k *keypad) autorepeat(touch int) {
+	if k.touch != touch || k.key == 0 {
+		return
+	}
+	pressChord(k.loop, k.kbd, []uint16{k.key})
+	k.loop.After(KeypadRepeatInterval, func() { k.autorepeat(touch) })
+}
+
+// Moved follows the touch; leaving the key it started on cancels it.
+func (k *keypad) Moved(x, y int32) {
+	if k.key != 0 && k.keyAt(x, y) != k.key {
+		k.key = 0
+	}
+}
+
+// Up ends the touch. A tap presses the key it landed on.
+func (k *keypad) Up(tap bool) {
+	k.touch++
+	if tap && k.key != 0 && !k.repeating {
+		pressChord(k.loop, k.kbd, []uint16{k.key})
+	}
+	k.key = 0
+}
+
+// Cancel forgets the current touch without pressing anything.
+func (k *keypad) Cancel() {
+	k.touch++
+	k.key = 0
+}
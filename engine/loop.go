@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Loop is a seat's epoll set. The reader stage waits on it for the
+// touchpad; auxiliary fds (other devices) registered with Add have their
+// callbacks run on that goroutine, and deferred actions come due through its
+// timerfd.
+type Loop struct {
+	epfd int
+	// wake is an eventfd other goroutines poke to interrupt Wait, e.g.
+	// after closing the touchpad, which epoll does not report.
+	wake   int
+	source int
+	// timer is a timerfd armed for the earliest deferred action.
+	timer int
+
+	mu       sync.Mutex
+	handlers map[int]func()
+	deferred []deferredAction
+	// due passes actions whose time has come to the goroutine that owns the
+	// state they touch; firing is runDeferred's scratch space.
+	due    chan func()
+	firing []deferredAction
+	// slack, when set, rounds deferred actions up to a multiple of it.
+	slack time.Duration
+}
+
+// DueQueue bounds the due actions waiting for the state machine.
+const DueQueue = 16
+
+// deferredAction is work scheduled with After, such as releasing a button
+// that was pressed for a tap.
+type deferredAction struct {
+	at time.Time
+	fn func()
+}
+
+func NewLoop() (*Loop, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("epoll_create: %w", err)
+	}
+	wake, err := unix.Eventfd(0, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+	if err != nil {
+		unix.Close(epfd)
+		return nil, fmt.Errorf("eventfd: %w", err)
+	}
+	timer, err := unix.TimerfdCreate(unix.CLOCK_MONOTONIC, unix.TFD_CLOEXEC|unix.TFD_NONBLOCK)
+	if err != nil {
+		unix.Close(wake)
+		unix.Close(epfd)
+		return nil, fmt.Errorf("timerfd_create: %w", err)
+	}
+	l := &Loop{epfd: epfd, wake: wake, source: -1, timer: timer,
+		handlers: make(map[int]func()), due: make(chan func(), DueQueue)}
+	for _, fd := range []int{wake, timer} {
+		if err := l.ctl(unix.EPOLL_CTL_ADD, fd); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *Loop) ctl(op, fd int) error {
+	ev := unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}
+	if err := unix.EpollCtl(l.epfd, op, fd, &ev); err != nil {
+		return fmt.Errorf("epoll_ctl fd %d: %w", fd, err)
+	}
+	return nil
+}
+
+// SetSource makes fd the fd Wait returns for. A closed fd drops out of the
+// set by itself, and the new one may reuse its number, hence the MOD
+// fallback. Actions deferred for the previous source are dropped; whoever
+// closed it has already released what they would have.
+func (l *Loop) SetSource(fd int) error {
+	l.mu.Lock()
+	l.deferred = l.deferred[:0]
+	l.mu.Unlock()
+	for len(l.due) > 0 {
+		<-l.due
+	}
+
+	if err := unix.EpollCtl(l.epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{Events: unix.EPOLLIN, Fd: int32(fd)}); err == unix.EEXIST {
+		if err := l.ctl(unix.EPOLL_CTL_MOD, fd); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("epoll_ctl fd %d: %w", fd, err)
+	}
+	l.source = fd
+	return nil
+}
+
+// Add registers an auxiliary fd; fn runs on the loop whenever it is readable
+// and must drain it.
+func (l *Loop) Add(fd int, fn func()) error {
+	l.mu.Lock()
+	l.handlers[fd] = fn
+	l.mu.Unlock()
+	return l.ctl(unix.EPOLL_CTL_ADD, fd)
+}
+
+func (l *Loop) Remove(fd int) {
+	l.mu.Lock()
+	delete(l.handlers, fd)
+	l.mu.Unlock()
+	unix.EpollCtl(l.epfd, unix.EPOLL_CTL_DEL, fd, nil)
+}
+
+// After schedules fn once d has passed, so the state machine never has to
+// sleep to space out events. When the time comes, fn is handed over on Due.
+func (l *Loop) After(d time.Duration, fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	at := time.Now().Add(d)
+	if l.slack > 0 {
+		at = at.Add(l.slack - 1).Truncate(l.slack)
+	}
+	i, _ := slices.BinarySearchFunc(l.deferred, at, func(a deferredAction, t time.Time) int {
+		return a.at.Compare(t)
+	})
+	l.deferred = slices.Insert(l.deferred, i, deferredAction{at, fn})
+	if i == 0 {
+		l.arm()
+	}
+}
+
+// SetSlack lets deferred actions fire up to slack late, so that ones close
+// together share a wakeup. Zero restores exact timing.
+func (l *Loop) SetSlack(slack time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.slack = slack
+}
+
+// arm sets the timerfd for the earliest deferred action. l.mu must be held.
+func (l *Loop) arm() {
+	var spec unix.ItimerSpec
+	if len(l.deferred) > 0 {
+		// A zero value would disarm the timer instead of firing at once.
+		spec.Value = unix.NsecToTimespec(max(time.Until(l.deferred[0].at), 1).Nanoseconds())
+	}
+	unix.TimerfdSettime(l.timer, 0, &spec, nil)
+}
+
+// Due delivers actions scheduled with After once they are due.
+func (l *Loop) Due() <-chan func() {
+	return l.due
+}
+
+// runDeferred passes on every action that is due and rearms the timer.
+func (l *Loop) runDeferred() {
+	var buf [8]byte
+	unix.Read(l.timer, buf[:])
+
+	now := time.Now()
+	l.mu.Lock()
+	n := 0
+	for n < len(l.deferred) && !l.deferred[n].at.After(now) {
+		n++
+	}
+	l.firing = append(l.firing[:0], l.deferred[:n]...)
+	l.deferred = slices.Delete(l.deferred, 0, n)
+	l.arm()
+	l.mu.Unlock()
+
+	// Sent without the lock held, since the receiver may be calling After.
+	for _, a := range l.firing {
+		l.due <- a.fn
+	}
+}
+
+// Wake interrupts Wait from another goroutine.
+func (l *Loop) Wake() {
+	var one = [8]byte{1}
+	unix.Write(l.wake, one[:])
+}
+
+// Wait blocks until the source is readable or Wake was called, running
+// deferred actions and the handlers of any auxiliary fds that become ready
+// meanwhile.
+func (l *Loop) Wait() error {
+	var events [8]unix.EpollEvent
+	for {
+		n, err := unix.EpollWait(l.epfd, events[:], -1)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return os.NewSyscallError("epoll_wait", err)
+		}
+
+		ready := false
+		for _, ev := range events[:n] {
+			fd := int(ev.Fd)
+			switch fd {
+			case l.source:
+				ready = true
+			case l.wake:
+				var buf [8]byte
+				unix.Read(l.wake, buf[:])
+				ready = true
+			case l.timer:
+				l.runDeferred()
+			default:
+				l.mu.Lock()
+				fn := l.handlers[fd]
+				l.mu.Unlock()
+				if fn != nil {
+					fn()
+				}
+			}
+		}
+		if ready {
+			return nil
+		}
+	}
+}
+
+func (l *Loop) Close() {
+	unix.Close(l.timer)
+	unix.Close(l.wake)
+	unix.Close(l.epfd)
+}
@@ -0,0 +1,95 @@
+package engine
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"touchpad/device"
+)
+
+// LoopBuckets are the upper bounds of the batch processing time histogram.
+var LoopBuckets = []time.Duration{
+	50 * time.Microsecond, 100 * time.Microsecond, 250 * time.Microsecond,
+	500 * time.Microsecond, time.Millisecond, 2500 * time.Microsecond,
+	5 * time.Millisecond, 10 * time.Millisecond,
+}
+
+// Metrics counts what the state machine does. Counters are atomics so the
+// loop never waits on a scrape.
+type Metrics struct {
+	Events  atomic.Uint64
+	Frames  atomic.Uint64
+	Taps    atomic.Uint64
+	Palms   atomic.Uint64
+	Dropped atomic.Uint64
+	// Stuck counts buttons and keys the failsafe had to release.
+	Stuck atomic.Uint64
+
+	// Clicks counts button presses, taps included, from BTN_LEFT on.
+	Clicks      [5]atomic.Uint64
+	ScrollTicks atomic.Uint64
+	// distanceBits is the pointer distance in pixels as float64 bits.
+	// Only the event loop writes it.
+	distanceBits atomic.Uint64
+
+	mu       sync.Mutex
+	gestures map[string]uint64
+
+	// loopCounts[i] counts batches handled within LoopBuckets[i]; the
+	// last entry is the +Inf bucket.
+	loopCounts [9]atomic.Uint64
+	loopSumNs  atomic.Uint64
+}
+
+func (m *Metrics) Click(button uint16) {
+	if i := int(button) - device.BTN_LEFT; i >= 0 && i < len(m.Clicks) {
+		m.Clicks[i].Add(1)
+	}
+}
+
+func (m *Metrics) Moved(dx, dy int32) {
+	d := math.Float64frombits(m.distanceBits.Load()) + math.Hypot(float64(dx), float64(dy))
+	m.distanceBits.Store(math.Float64bits(d))
+}
+
+// Distance is how far the pointer has moved, in pixels.
+func (m *Metrics) Distance() float64 {
+	return math.Float64frombits(m.distanceBits.Load())
+}
+
+func (m *Metrics) Gesture(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gestures == nil {
+		m.gestures = make(map[string]uint64)
+	}
+	m.gestures[name]++
+}
+
+// Gestures returns how often each gesture was recognized.
+func (m *Metrics) Gestures() map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.gestures)
+}
+
+// ObserveLoop records how long handling one batch took.
+func (m *Metrics) ObserveLoop(d time.Duration) {
+	i, _ := slices.BinarySearch(LoopBuckets, d)
+	m.loopCounts[i].Add(1)
+	m.loopSumNs.Add(uint64(d.Nanoseconds()))
+}
+
+// LoopHistogram returns the batches counted in each of LoopBuckets and the
+// +Inf bucket, and the time they took altogether.
+func (m *Metrics) LoopHistogram() ([]uint64, time.Duration) {
+	counts := make([]uint64, len(m.loopCounts))
+	for i := range m.loopCounts {
+		counts[i] = m.loopCounts[i].Load()
+	}
+	return counts, time.Duration(m.loopSumNs.Load())
+}
@@ -0,0 +1,76 @@
+package engine
+
+import (
+	evdev "github.com/gvalkov/golang-evdev"
+
+	"touchpad/device"
+)
+
+// PipelineDepth is how many batches the reader may get ahead of the state
+// machine before it stops reading.
+const PipelineDepth = 8
+
+// inputBatch is what the reader stage hands to the state machine: events,
+// or the error that ended reading.
+type inputBatch struct {
+	events []evdev.InputEvent
+	err    error
+}
+
+// batchPool is the fixed set of buffers batches travel in. The state machine
+// returns each one after use, so the reader never allocates and blocks
+// instead once all of them are in flight.
+type batchPool chan []evdev.InputEvent
+
+func newBatchPool() batchPool {
+	p := make(batchPool, PipelineDepth)
+	for range PipelineDepth {
+		p <- make([]evdev.InputEvent, 0, device.ReadBatch)
+	}
+	return p
+}
+
+// readBatches is the reader stage: it waits on the loop, drains the touchpad
+// and sends what it read to out, until reading fails or the state machine
+// closes stop on its way out.
+func readBatches(loop *Loop, r EventSource, pool batchPool, out chan<- inputBatch, stop <-chan struct{}) {
+	for {
+		if err := loop.Wait(); err != nil {
+			sendBatch(out, inputBatch{err: err}, stop)
+			return
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		events, err := r.Read()
+		if err != nil {
+			sendBatch(out, inputBatch{err: err}, stop)
+			return
+		}
+		if len(events) == 0 {
+			continue
+		}
+		var buf []evdev.InputEvent
+		select {
+		case buf = <-pool:
+		case <-stop:
+			return
+		}
+		if !sendBatch(out, inputBatch{events: append(buf[:0], events...)}, stop) {
+			return
+		}
+	}
+}
+
+// sendBatch hands in to the state machine, or reports false if it has
+// stopped taking batches.
+func sendBatch(out chan<- inputBatch, in inputBatch, stop <-chan struct{}) bool {
+	select {
+	case out <- in:
+		return true
+	case <-stop:
+		return false
+	}
+}
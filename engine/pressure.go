@@ -0,0 +1,130 @@
+package engine
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+
+	"touchpad/config"
+)
+
+const (
+	// PressureSamples is how many recent touches of each kind the learner
+	// keeps.
+	PressureSamples = 200
+	// pressureMinSamples is how many of each it needs before adapting.
+	pressureMinSamples = 20
+	// pressureRate is how far each touch moves the thresholds towards
+	// what the samples suggest.
+	pressureRate = 0.05
+)
+
+// PressureThresholds are the thresholds in use, as reported by the
+// "pressure" command.
+type PressureThresholds struct {
+	Adaptive bool  `json:"adaptive"`
+	Press    int32 `json:"press"`
+	Release  int32 `json:"release"`
+	MinMove  int32 `json:"min_move"`
+	// Taps and Clicks are how many touches of each kind the learned values
+	// are based on.
+	Taps   int `json:"taps"`
+	Clicks int `json:"clicks"`
+}
+
+// pressureRing holds the peak pressures of recent touches of one kind.
+type pressureRing struct {
+	buf  [PressureSamples]int32
+	n    int
+	next int
+}
+
+func (r *pressureRing) Add(p int32) {
+	r.buf[r.next] = p
+	r.next = (r.next + 1) % len(r.buf)
+	r.n = min(r.n+1, len(r.buf))
+}
+
+// Percentile returns the q-th percentile, 0 <= q <= 1.
+func (r *pressureRing) Percentile(q float64) float64 {
+	s := slices.Clone(r.buf[:r.n])
+	slices.Sort(s)
+	return float64(s[int(q*float64(len(s)-1))])
+}
+
+// PressureLearner adapts the click and motion thresholds to the pressures
+// this user and firmware actually produce. The press threshold settles
+// halfway between a firm tap (90th percentile of tap peaks) and a light click
+// (10th percentile of click peaks), within the profile's bounds; release and
+// min-move follow in proportion. It moves slowly, so one odd touch changes
+// little.
+type PressureLearner struct {
+	mu           sync.Mutex
+	taps, clicks pressureRing
+	// press, release and minMove are the learned values, 0 until the
+	// first touch seeds them from the profile.
+	press, release, minMove float64
+}
+
+func (l *PressureLearner) seed(p *config.Profile) {
+	if l.press == 0 {
+		l.press, l.release, l.minMove = float64(p.PressPressure), float64(p.ReleasePressure), float64(p.MinMovePressure)
+	}
+}
+
+// Observe records the peak pressure of a touch that clicked, or of a short
+// one that didn't.
+func (l *PressureLearner) Observe(peak int32, click bool, p *config.Profile) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seed(p)
+	if click {
+		l.clicks.Add(peak)
+	} else {
+		l.taps.Add(peak)
+	}
+	if !p.AdaptivePressure || l.taps.n < pressureMinSamples || l.clicks.n < pressureMinSamples {
+		return
+	}
+	tap, clickLow := l.taps.Percentile(0.9), l.clicks.Percentile(0.1)
+	if clickLow <= tap {
+		// Taps and clicks overlap; there is no threshold to learn.
+		return
+	}
+	target := min(max((tap+clickLow)/2, float64(p.PressPressureMin)), float64(p.PressPressureMax))
+	before := int32(l.press)
+	l.press += pressureRate * (target - l.press)
+	l.release = l.press * float64(p.ReleasePressure) / float64(max(p.PressPressure, 1))
+	// A light touch brushes the pad with about a tenth of a tap's force.
+	minMove := min(max(l.taps.Percentile(0.1)/10, 1), LowPressureThreshold-1)
+	l.minMove += pressureRate * (minMove - l.minMove)
+	if int32(l.press) != before {
+		slog.Debug("pressure thresholds adapted", "press", int32(l.press), "release", int32(l.release), "min_move", int32(l.minMove))
+	}
+}
+
+// Thresholds returns the press, release and min-move pressures to use under
+// p: the learned ones if it adapts, else its own.
+func (l *PressureLearner) Thresholds(p *config.Profile) (press, release, minMove int32) {
+	if !p.AdaptivePressure {
+		return p.PressPressure, p.ReleasePressure, p.MinMovePressure
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seed(p)
+	return int32(l.press), int32(l.release), int32(l.minMove)
+}
+
+func (l *PressureLearner) Snapshot(p *config.Profile) PressureThresholds {
+	press, release, minMove := l.Thresholds(p)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return PressureThresholds{
+		Adaptive: p.AdaptivePressure,
+		Press:    press,
+		Release:  release,
+		MinMove:  minMove,
+		Taps:     l.taps.n,
+		Clicks:   l.clicks.n,
+	}
+}
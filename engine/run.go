@@ -0,0 +1,909 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+const (
+	ScrollDivider = 40.0
+
+	// PalmZoneTop is how far down the pad, as a fraction of its height,
+	// the palm zone along the top edge reaches.
+	PalmZoneTop           = 0.2
+	PalmPressureThreshold = 45
+
+	LowPressureThreshold = 15
+	SmallMoveCutoff      = 2.0
+
+	TapHold = 15 * time.Millisecond
+
+	GestureDistThreshold = 100.0
+	// Four fingers travel further before a swipe counts, since they move
+	// less precisely together.
+	FourFingerGestureDistThreshold = 150.0
+
+	// SaverMotionInterval is the shortest gap between pointer reports in
+	// battery-saver mode; motion in between is summed into the next one.
+	SaverMotionInterval = 16 * time.Millisecond
+)
+
+// ErrCrashed marks a run that panicked. The supervisor does not retry it:
+// the process exits and the service manager starts a fresh one.
+var ErrCrashed = errors.New("event loop crashed")
+
+// Run runs the gesture state machine over events from src, as read by a
+// separate reader stage, until a read fails. All touch state is local, so
+// each call starts from scratch. A panic ends it with ErrCrashed once held
+// buttons are released and the host has been told.
+func (e *Engine) Run(src EventSource) (err error) {
+	vmouse, status, hb := e.Pointer(), e.Status, &e.Heartbeat
+
+	var slots, prevSlots device.Slots
+	activeSlot := 0
+
+	var (
+		currentFingerCount       int
+		maxFingersDuringTouch    int
+		maxPressureDuringTouch   int32
+		touchStartTime           time.Time
+		touchStartX, touchStartY int32
+		isPhysicallyClicked      bool
+		activePhysicalButton     uint16
+		scrollAccX, scrollAccY   float64
+		// hiResX and hiResY are high-resolution wheel motion yet to report.
+		hiResX, hiResY           float64
+		isScrolling              bool
+		isPalmRejected           bool
+		gestureAccX, gestureAccY float64
+		gestureTriggered         bool
+		// heldMX and heldMY are motion battery-saver mode has yet to report;
+		// fracMX and fracMY what didn't make a whole unit of pointer motion.
+		heldMX, heldMY int32
+		fracMX, fracMY float64
+		lastMotionOut  time.Time
+		// syncing is set from SYN_DROPPED until the next SYN_REPORT; the
+		// events in between are incomplete and get discarded.
+		syncing bool
+		// sliding is set for a touch that started on the brightness strip.
+		sliding bool
+		// stoppedCoast is set for a touch that stopped a kinetic scroll,
+		// which is no tap.
+		stoppedCoast bool
+		// forced is set during a deep press, and forceButton is the button
+		// it holds, if any.
+		forced      bool
+		forceButton uint16
+		// lastMode and lastFingers are the touch state last logged.
+		lastMode    string
+		lastFingers int
+	)
+
+	// A bug in here must not leave buttons held down: lift them, keep a
+	// report for the bug and let the service manager start over.
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		e.ReleaseAll()
+		var b strings.Builder
+		fmt.Fprintf(&b, "fingers=%d max=%d pressure_max=%d active_slot=%d\n",
+			currentFingerCount, maxFingersDuringTouch, maxPressureDuringTouch, activeSlot)
+		fmt.Fprintf(&b, "touch_start=%s at=%d,%d clicked=%v button=%d\n",
+			touchStartTime.Format(time.RFC3339Nano), touchStartX, touchStartY, isPhysicallyClicked, activePhysicalButton)
+		fmt.Fprintf(&b, "scrolling=%v scroll_acc=%.1f,%.1f palm=%v gesture=%v gesture_acc=%.1f,%.1f syncing=%v\n",
+			isScrolling, scrollAccX, scrollAccY, isPalmRejected, gestureTriggered, gestureAccX, gestureAccY, syncing)
+		for id := range slots {
+			if slots[id].Active || prevSlots[id].Active {
+				fmt.Fprintf(&b, "slot %d: %+v prev %+v\n", id, slots[id], prevSlots[id])
+			}
+		}
+		e.Host.Crashed(src.Path(), r, debug.Stack(), b.String())
+		slog.Error("event loop panicked", "seat", e.Seat, "panic", r)
+		err = fmt.Errorf("%w: %v", ErrCrashed, r)
+	}()
+
+	fd, err := src.Fd()
+	if err != nil {
+		return err
+	}
+	if err := e.Loop.SetSource(fd); err != nil {
+		return err
+	}
+	info, _ := src.Info()
+	// Touchscreens, such as a phone's, don't report how many fingers are
+	// down, so they are counted from the slots instead.
+	countSlots := !slices.Contains(src.Capabilities()[evdev.EV_KEY], evdev.BTN_TOOL_FINGER)
+
+	// Whatever was held for a sticky drag was released when the last
+	// call ended.
+	e.DragLocked.Store(false)
+
+	// profile is the current profile as of the batch being processed.
+	profile := e.Profile()
+
+	newDwell := func(p *config.Profile) *dwellClicker {
+		button, _ := config.ButtonCode(p.DwellButton)
+		return newDwellClicker(e.Loop, *p, func() {
+			e.publish(StreamEvent{Type: "dwell-warning", Name: p.DwellButton})
+		}, func() {
+			if !status.Active() {
+				return
+			}
+			vmouse.WriteEvent(device.EV_KEY, button, 1)
+			vmouse.Syn()
+			vmouse.Flush()
+			e.Loop.After(TapHold, func() {
+				vmouse.WriteEvent(device.EV_KEY, button, 0)
+				vmouse.Syn()
+				vmouse.Flush()
+			})
+			e.Host.Beep()
+			e.Metrics.Click(button)
+			e.publish(StreamEvent{Type: "dwell", Name: p.DwellButton})
+		})
+	}
+	dwell := newDwell(profile)
+	keys := newKeypad(e.Loop, e.Outputs.Keyboard, *profile, info)
+	slider := newBrightnessSlider(e.Outputs.Keyboard, *profile, info)
+	spin := func(mx, my int32) {
+		if !status.Active() {
+			return
+		}
+		vmouse.WriteEvent(device.EV_REL, device.REL_X, mx)
+		vmouse.WriteEvent(device.EV_REL, device.REL_Y, my)
+		vmouse.Syn()
+		vmouse.Flush()
+		e.Metrics.Moved(mx, my)
+	}
+	ball := newTrackball(e.Loop, *profile, spin)
+	nav := newNavSwipe(*profile, info)
+	ptrAccel := newPointerAccel(*profile)
+	smooth := newMotionFilter(*profile)
+	taps := newTapClicker(e.Loop, func() {
+		vmouse.WriteEvent(device.EV_KEY, device.BTN_LEFT, 1)
+		vmouse.Syn()
+		vmouse.Flush()
+	}, func() {
+		vmouse.WriteEvent(device.EV_KEY, device.BTN_LEFT, 0)
+		vmouse.Syn()
+		vmouse.Flush()
+	})
+	tapDrag := newTapDragger(e.Loop, *profile, taps.Release)
+	sendButton := func(button uint16, value int32) {
+		vmouse.WriteEvent(device.EV_KEY, button, value)
+		vmouse.Syn()
+		vmouse.Flush()
+	}
+	fingerDrag := newFingerDragger(e.Loop, *profile, sendButton)
+	scroll := newScrollDecider(*profile, info)
+	// scrollBy turns scroll motion in touchpad units into wheel ticks, and
+	// in between into high-resolution wheel motion (1/120 of a tick) for
+	// smooth scrolling; holdX keeps the horizontal part back for now.
+	scrollBy := func(dx, dy float64, holdX bool) {
+		scrollAccY += dy
+		scrollAccX += dx
+		hiResY += dy * 120 / ScrollDivider
+		hiResX += dx * 120 / ScrollDivider
+		direction := 1
+		if !profile.NaturalScrolling {
+			direction = -1
+		}
+
+		if v := int32(hiResY); v != 0 {
+			vmouse.WriteEvent(device.EV_REL, device.REL_WHEEL_HI_RES, v*int32(direction))
+			hiResY -= float64(v)
+		}
+		if v := int32(hiResX); v != 0 && !holdX {
+			vmouse.WriteEvent(device.EV_REL, device.REL_HWHEEL_HI_RES, v*int32(-direction))
+			hiResX -= float64(v)
+		}
+
+		if math.Abs(scrollAccY) > ScrollDivider {
+			ticks := int(scrollAccY / ScrollDivider)
+			vmouse.WriteEvent(device.EV_REL, device.REL_WHEEL, int32(ticks*direction))
+			e.Metrics.ScrollTicks.Add(uint64(max(ticks, -ticks)))
+			scrollAccY -= float64(ticks) * ScrollDivider
+		}
+		if math.Abs(scrollAccX) > ScrollDivider && !holdX {
+			ticks := int(scrollAccX / ScrollDivider)
+			vmouse.WriteEvent(device.EV_REL, device.REL_HWHEEL, int32(ticks*-direction))
+			e.Metrics.ScrollTicks.Add(uint64(max(ticks, -ticks)))
+			scrollAccX -= float64(ticks) * ScrollDivider
+		}
+	}
+	coastScroll := func(dx, dy int32) {
+		if !status.Active() {
+			return
+		}
+		scrollBy(float64(dx), float64(dy), false)
+		vmouse.Syn()
+		vmouse.Flush()
+	}
+	coast := newKineticScroll(e.Loop, *profile, coastScroll)
+	// The pressure thresholds in use, which adapt over time when the
+	// profile asks for it.
+	var pressPressure, releasePressure, minMovePressure int32
+
+	// Reading runs in its own stage so that the kernel's buffer keeps being
+	// drained while this one works through a backlog.
+	pool := newBatchPool()
+	batches := make(chan inputBatch, PipelineDepth)
+	// The reader may be blocked sending or waiting on the loop when this
+	// returns; stop and the wake-up let it go.
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		e.Loop.Wake()
+	}()
+	go readBatches(e.Loop, src, pool, batches, stop)
+
+	for {
+		hb.Idle()
+		var in inputBatch
+		select {
+		case in = <-batches:
+		case fn := <-e.Loop.Due():
+			hb.Busy()
+			fn()
+			continue
+		}
+		if in.err != nil {
+			return in.err
+		}
+		hb.Busy()
+		batchStart := time.Now()
+		// Profiles are switched between batches.
+		if p := e.Profile(); p != profile {
+			profile = p
+			if dwell != nil {
+				dwell.Cancel()
+			}
+			dwell = newDwell(profile)
+			if keys != nil {
+				keys.Cancel()
+			}
+			keys = newKeypad(e.Loop, e.Outputs.Keyboard, *profile, info)
+			slider = newBrightnessSlider(e.Outputs.Keyboard, *profile, info)
+			sliding = false
+			if ball != nil {
+				ball.Stop()
+			}
+			ball = newTrackball(e.Loop, *profile, spin)
+			nav = newNavSwipe(*profile, info)
+			ptrAccel = newPointerAccel(*profile)
+			smooth = newMotionFilter(*profile)
+			if tapDrag != nil {
+				tapDrag.Cancel()
+			}
+			tapDrag = newTapDragger(e.Loop, *profile, taps.Release)
+			if fingerDrag != nil {
+				fingerDrag.Cancel()
+			}
+			fingerDrag = newFingerDragger(e.Loop, *profile, sendButton)
+			scroll = newScrollDecider(*profile, info)
+			if coast != nil {
+				coast.Stop()
+			}
+			coast = newKineticScroll(e.Loop, *profile, coastScroll)
+		}
+		pressPressure, releasePressure, minMovePressure = e.Pressure.Thresholds(profile)
+		if next := e.Outputs.pointer(profile); next != vmouse {
+			// Whatever the old output holds is let go of as the new one
+			// takes over.
+			if tapDrag != nil {
+				tapDrag.Cancel()
+			}
+			if fingerDrag != nil {
+				fingerDrag.Cancel()
+			}
+			taps.Release()
+			if vmouse == e.Outputs.Touchscreen {
+				e.touch.Frame(vmouse, &device.Slots{})
+			}
+			vmouse.ReleaseAll()
+			vmouse.Flush()
+			isPhysicallyClicked, activePhysicalButton = false, 0
+			forced, forceButton = false, 0
+			e.DragLocked.Store(false)
+			vmouse = next
+		}
+		e.Metrics.Events.Add(uint64(len(in.events)))
+		e.Host.Input(in.events)
+
+		for _, event := range in.events {
+			if e.Trace != nil {
+				e.Trace.Raw(src.Path(), event)
+			}
+			if event.Type == evdev.EV_SYN && event.Code == evdev.SYN_DROPPED {
+				syncing = true
+				e.Metrics.Dropped.Add(1)
+				continue
+			}
+			if syncing {
+				if event.Type != evdev.EV_SYN || event.Code != evdev.SYN_REPORT {
+					continue
+				}
+				syncing = false
+				st, err := src.TouchState()
+				if err != nil {
+					return err
+				}
+				// Whatever touch was going on lost its history; carry on
+				// from the current contacts without a tap, scroll or
+				// gesture in progress, and without a jump.
+				slots, prevSlots, activeSlot = st.Slots, st.Slots, st.ActiveSlot
+				currentFingerCount, maxFingersDuringTouch = st.Fingers, st.Fingers
+				touchStartTime = time.Time{}
+				isScrolling, gestureTriggered, isPalmRejected = false, false, false
+				gestureAccX, gestureAccY = 0, 0
+				scroll.Reset()
+				status.SetFingers(currentFingerCount)
+				continue
+			}
+
+			switch event.Type {
+			case evdev.EV_ABS:
+				if event.Code == evdev.ABS_MT_SLOT {
+					activeSlot = int(event.Value)
+				}
+				if activeSlot < 0 || activeSlot >= device.MaxSlots {
+					continue
+				}
+				slot := &slots[activeSlot]
+				slot.Active = true
+				switch event.Code {
+				case evdev.ABS_MT_POSITION_X:
+					slot.X = event.Value
+				case evdev.ABS_MT_POSITION_Y:
+					slot.Y = event.Value
+				case evdev.ABS_MT_PRESSURE:
+					slot.P = event.Value
+					if event.Value > maxPressureDuringTouch {
+						maxPressureDuringTouch = event.Value
+					}
+				case evdev.ABS_MT_TRACKING_ID:
+					if event.Value == -1 {
+						*slot = device.Slot{}
+					}
+				}
+
+			case evdev.EV_KEY:
+				switch event.Code {
+				case evdev.BTN_TOOL_FINGER:
+					if event.Value == 1 {
+						currentFingerCount = 1
+					} else {
+						currentFingerCount = 0
+					}
+				case evdev.BTN_TOOL_DOUBLETAP:
+					if event.Value == 1 {
+						currentFingerCount = 2
+					} else {
+						currentFingerCount = 0
+					}
+				case evdev.BTN_TOOL_TRIPLETAP:
+					if event.Value == 1 {
+						currentFingerCount = 3
+					} else {
+						currentFingerCount = 0
+					}
+				case evdev.BTN_TOOL_QUADTAP:
+					if event.Value == 1 {
+						currentFingerCount = 4
+					} else {
+						currentFingerCount = 0
+					}
+				case evdev.BTN_TOOL_QUINTTAP:
+					if event.Value == 1 {
+						currentFingerCount = 5
+					} else {
+						currentFingerCount = 0
+					}
+				}
+				status.SetFingers(currentFingerCount)
+				if currentFingerCount > maxFingersDuringTouch {
+					maxFingersDuringTouch = currentFingerCount
+				}
+
+				if event.Code == evdev.BTN_TOUCH {
+					now := time.Now()
+					if event.Value == 1 {
+						touchStartTime = now
+						maxFingersDuringTouch = currentFingerCount
+						maxPressureDuringTouch = 0
+						isScrolling = false
+						scroll.Reset()
+						gestureTriggered = false
+						gestureAccX, gestureAccY = 0, 0
+						heldMX, heldMY = 0, 0
+						fracMX, fracMY = 0, 0
+						if s := slots[0]; s.Active {
+							touchStartX, touchStartY = s.X, s.Y
+							isPalmRejected = profile.PalmRejection && s.Y < info.PadY(PalmZoneTop) && s.P > PalmPressureThreshold
+							if isPalmRejected {
+								e.Metrics.Palms.Add(1)
+							}
+						}
+						if e.Host.Blanked(now) {
+							// The laptop was just opened or woken, and is
+							// likely being held by the pad.
+							isPalmRejected = true
+						}
+						if profile.DisableWhileTyping && e.Host.Typing(now, profile.TypingTimeout()) {
+							// Most likely a palm brushing the pad.
+							isPalmRejected = true
+						}
+						prevSlots = device.Slots{}
+						if keys != nil && slots[0].Active && status.Active() && !isPalmRejected {
+							keys.Down(slots[0].X, slots[0].Y)
+						}
+						sliding = slider != nil && !isPalmRejected && slider.Starts(slots[0])
+						if ball != nil {
+							ball.Stop()
+						}
+						stoppedCoast = coast != nil && coast.Stop()
+						if smooth != nil {
+							smooth.Reset()
+						}
+						if nav != nil {
+							nav.Start(slots[0], now)
+						}
+						if tapDrag != nil && status.Active() && !isPalmRejected {
+							tapDrag.Down()
+						}
+					} else {
+						duration := now.Sub(touchStartTime)
+						if keys != nil {
+							keys.Up(status.Active() && !isPalmRejected && duration < profile.TapTimeout() && maxFingersDuringTouch == 1)
+						}
+						if ball != nil && maxFingersDuringTouch == 1 && !isScrolling && !sliding && !isPalmRejected {
+							ball.Release(device.Time(event.Time))
+						}
+						// Coasting costs a wakeup every tick, which battery
+						// saver mode does without.
+						if coast != nil && scroll.Scrolled() && maxFingersDuringTouch <= 2 && !isPalmRejected && !e.Host.Saving() {
+							coast.Release(device.Time(event.Time))
+						}
+						wasPhysicalClick := maxPressureDuringTouch > pressPressure
+						if maxFingersDuringTouch == 1 && !isPalmRejected && (wasPhysicalClick || duration < profile.TapTimeout()) {
+							e.Pressure.Observe(maxPressureDuringTouch, wasPhysicalClick, profile)
+						}
+
+						if nav != nil && status.Active() && !isPalmRejected && !gestureTriggered && !sliding {
+							if btn := nav.End(maxFingersDuringTouch, now); btn != 0 {
+								vmouse.WriteEvent(device.EV_KEY, btn, 1)
+								vmouse.Syn()
+								e.Loop.After(TapHold, func() {
+									vmouse.WriteEvent(device.EV_KEY, btn, 0)
+									vmouse.Syn()
+									vmouse.Flush()
+								})
+								// What the flick held back is not scrolled later.
+								scrollAccX, hiResX = 0, 0
+								e.Metrics.Click(btn)
+								status.SetGesture(config.ButtonName(btn))
+								e.Host.Beep()
+							}
+						}
+
+						// The profile gesture works whatever the profile,
+						// so a profile without taps can be left again.
+						cycled := e.CycleGesture != "" && e.CycleGesture == config.TapGesture(maxFingersDuringTouch) &&
+							status.Active() && duration < profile.TapTimeout() && !wasPhysicalClick && !gestureTriggered
+						if cycled {
+							e.Host.CycleProfile()
+							e.Host.Beep()
+						}
+
+						lastX, lastY := touchStartX, touchStartY
+						if ps := prevSlots[0]; ps.Active {
+							lastX, lastY = ps.X, ps.Y
+						}
+						dist := math.Sqrt(math.Pow(float64(lastX-touchStartX), 2) + math.Pow(float64(lastY-touchStartY), 2))
+
+						dragged := false
+						if tapDrag != nil {
+							dragged = tapDrag.Up(maxFingersDuringTouch, duration < profile.TapTimeout() && dist < profile.TapMoveDistance(info))
+						}
+						if fingerDrag != nil && fingerDrag.Up() {
+							dragged = true
+						}
+
+						zones := profile.Zones()
+						if !cycled && !dragged && maxFingersDuringTouch <= 3 && status.Active() && (profile.TapToClick || len(zones) > 0) && profile.Output != config.OutputTouchscreen && !isPalmRejected && duration < profile.TapTimeout() && !wasPhysicalClick &&
+							!scroll.Scrolled() && !stoppedCoast && !gestureTriggered {
+
+							zone := tapZoneAt(zones, lastX, lastY, maxFingersDuringTouch, info)
+							var action config.ZoneAction
+							if zone != nil {
+								action, _ = config.ParseZoneAction(zone.TapAction())
+							}
+							if zone != nil && action.Button == 0 && dist < profile.TapMoveDistance(info) {
+								slog.Debug("hot zone tapped", "seat", e.Seat, "action", zone.TapAction())
+								e.runZoneAction(action)
+								e.Host.Beep()
+								e.publish(StreamEvent{Type: "hot-zone", Fingers: maxFingersDuringTouch, Name: zone.TapAction()})
+							} else if dist < profile.TapMoveDistance(info) && profile.TapToClick {
+								clickBtn := uint16(device.BTN_LEFT)
+								if zone != nil && (zone.Fingers != 0 || maxFingersDuringTouch == 1) {
+									clickBtn = action.Button
+								} else if maxFingersDuringTouch == 2 {
+									clickBtn = device.BTN_RIGHT
+								} else if maxFingersDuringTouch == 3 {
+									clickBtn = device.BTN_MIDDLE
+								}
+								if dwell != nil {
+									dwell.Cancel()
+								}
+								if clickBtn == device.BTN_LEFT && profile.StickyDrag {
+									// A tap presses the button and the next
+									// one lets go, so dragging needs no
+									// sustained contact.
+									if e.DragLocked.Swap(false) {
+										vmouse.WriteEvent(device.EV_KEY, device.BTN_LEFT, 0)
+										vmouse.Syn()
+										e.Host.Beep()
+										e.publish(StreamEvent{Type: "release", Name: "left"})
+										continue
+									}
+									e.DragLocked.Store(true)
+									vmouse.WriteEvent(device.EV_KEY, device.BTN_LEFT, 1)
+									vmouse.Syn()
+									e.Host.Beep()
+									e.Metrics.Taps.Add(1)
+									e.Metrics.Click(device.BTN_LEFT)
+									e.publish(StreamEvent{Type: "press", Name: "left"})
+									continue
+								}
+								tapType := "tap"
+								if clickBtn == device.BTN_LEFT {
+									var hold func()
+									if tapDrag != nil {
+										// Held on in case a touch
+										// follows to drag.
+										hold = tapDrag.Tapped
+									}
+									if taps.Tap(lastX, lastY, now, profile.TapMoveDistance(info), hold) {
+										tapType = "double-tap"
+									}
+								} else {
+									vmouse.WriteEvent(device.EV_KEY, clickBtn, 1)
+									vmouse.Syn()
+									e.Loop.After(TapHold, func() {
+										vmouse.WriteEvent(device.EV_KEY, clickBtn, 0)
+										vmouse.Syn()
+										vmouse.Flush()
+									})
+								}
+								e.Host.Beep()
+								e.Metrics.Taps.Add(1)
+								e.Metrics.Click(clickBtn)
+								e.publish(StreamEvent{Type: tapType, Fingers: maxFingersDuringTouch, Name: config.ButtonName(clickBtn)})
+							}
+						}
+					}
+				}
+
+			case evdev.EV_SYN:
+				if event.Code == evdev.SYN_REPORT {
+					e.Metrics.Frames.Add(1)
+					if countSlots {
+						n := 0
+						for _, s := range slots {
+							if s.Active {
+								n++
+							}
+						}
+						if n != currentFingerCount {
+							currentFingerCount = n
+							maxFingersDuringTouch = max(maxFingersDuringTouch, n)
+							status.SetFingers(n)
+						}
+					}
+					if currentFingerCount > 0 && e.Host.Blanked(time.Now()) {
+						// A touch that was already down at resume
+						// is ignored until it lifts.
+						isPalmRejected = true
+					}
+					if !profile.LowLatency {
+						e.Host.Frame(&slots)
+					}
+					e.TouchPressure.Store(slots[0].P)
+					mode := touchMode(status.Active(), isPalmRejected, isScrolling, gestureTriggered, currentFingerCount)
+					e.publishFrame(&slots, currentFingerCount, mode)
+					if mode != lastMode || currentFingerCount != lastFingers {
+						slog.Debug("touch state", "seat", e.Seat, "fingers", currentFingerCount, "mode", mode)
+						lastMode, lastFingers = mode, currentFingerCount
+					}
+					if e.Trace != nil {
+						e.Trace.Frame(src.Path(), device.Time(event.Time), currentFingerCount, mode, isPhysicallyClicked, &slots)
+					}
+					vmouse.Stamp(device.Time(event.Time))
+
+					if !status.Active() && isPhysicallyClicked {
+						isPhysicallyClicked = false
+						if activePhysicalButton != 0 {
+							vmouse.WriteEvent(device.EV_KEY, activePhysicalButton, 0)
+							vmouse.Syn()
+						}
+						activePhysicalButton = 0
+					}
+					if !status.Active() && tapDrag != nil {
+						tapDrag.Cancel()
+					}
+					if !status.Active() && fingerDrag != nil {
+						fingerDrag.Cancel()
+					}
+					if !status.Active() && forceButton != 0 {
+						vmouse.WriteEvent(device.EV_KEY, forceButton, 0)
+						vmouse.Syn()
+						forced, forceButton = false, 0
+					}
+					if profile.Output == config.OutputTouchscreen {
+						// Touchscreen clients recognize taps and gestures
+						// themselves; they get the contacts and nothing else.
+						if isPalmRejected || !status.Active() {
+							e.touch.Frame(vmouse, &device.Slots{})
+						} else {
+							e.touch.Frame(vmouse, &slots)
+						}
+						prevSlots = slots
+						continue
+					}
+					if isPalmRejected || !status.Active() {
+						prevSlots = slots
+						continue
+					}
+					if keys != nil {
+						// The pad is all keys; nothing moves the pointer.
+						if s0 := slots[0]; s0.Active {
+							keys.Moved(s0.X, s0.Y)
+						}
+						vmouse.Syn()
+						prevSlots = slots
+						continue
+					}
+					if sliding {
+						// A drag along the strip is for the slider alone.
+						if s0, p0 := slots[0], prevSlots[0]; currentFingerCount == 1 && s0.Active && p0.Active {
+							slider.Slide(s0.X - p0.X)
+						}
+						prevSlots = slots
+						continue
+					}
+
+					// An inactive slot is all zeroes, so this is 0 without a contact.
+					pressure := slots[0].P
+
+					if !isPhysicallyClicked && pressure > pressPressure {
+						isPhysicallyClicked = true
+						activePhysicalButton = device.BTN_LEFT
+						var zone *config.HotZone
+						if s := slots[0]; s.Active {
+							zone = pressZoneAt(profile.Zones(), s.X, s.Y, currentFingerCount, info)
+						}
+						if zone != nil {
+							action, _ := config.ParseZoneAction(zone.Press)
+							// A chord or command goes off once, and the
+							// click holds no button down.
+							activePhysicalButton = action.Button
+							if action.Button == 0 {
+								slog.Debug("hot zone pressed", "seat", e.Seat, "action", zone.Press)
+								e.runZoneAction(action)
+								e.publish(StreamEvent{Type: "hot-zone", Fingers: currentFingerCount, Name: zone.Press})
+							}
+						}
+						if dwell != nil {
+							dwell.Cancel()
+						}
+						if activePhysicalButton != 0 {
+							// A real click takes over a sticky drag; its
+							// release ends it.
+							e.DragLocked.Store(false)
+							vmouse.WriteEvent(device.EV_KEY, activePhysicalButton, 1)
+							vmouse.Syn()
+							e.Metrics.Click(activePhysicalButton)
+							e.publish(StreamEvent{Type: "press", Name: config.ButtonName(activePhysicalButton)})
+						}
+					} else if isPhysicallyClicked && pressure < releasePressure {
+						isPhysicallyClicked = false
+						if activePhysicalButton != 0 {
+							vmouse.WriteEvent(device.EV_KEY, activePhysicalButton, 0)
+							vmouse.Syn()
+							e.publish(StreamEvent{Type: "release", Name: config.ButtonName(activePhysicalButton)})
+						}
+						activePhysicalButton = 0
+					}
+
+					if profile.ForcePressPressure > 0 {
+						if !forced && isPhysicallyClicked && pressure > profile.ForcePressPressure {
+							forced = true
+							action, _ := config.ParseForceAction(profile.ForceAction)
+							if action.Button != 0 {
+								// The click ends as the deep press takes over.
+								if activePhysicalButton != 0 {
+									vmouse.WriteEvent(device.EV_KEY, activePhysicalButton, 0)
+								}
+								vmouse.WriteEvent(device.EV_KEY, action.Button, 1)
+								vmouse.Syn()
+								forceButton = action.Button
+								e.Metrics.Click(action.Button)
+							} else {
+								pressChord(e.Loop, e.Outputs.Keyboard, action.Chord)
+							}
+							e.Host.Beep()
+							e.publish(StreamEvent{Type: "force-press", Name: profile.ForceAction})
+						} else if forced && pressure < profile.ForceReleaseLevel() {
+							forced = false
+							if forceButton != 0 {
+								vmouse.WriteEvent(device.EV_KEY, forceButton, 0)
+								vmouse.Syn()
+								forceButton = 0
+							}
+						}
+					}
+
+					s0, p0 := slots[0], prevSlots[0]
+
+					if profile.Output == config.OutputAbsolute && currentFingerCount == 1 && !isScrolling && !gestureTriggered &&
+						s0.Active && s0.P >= minMovePressure {
+						// The pad maps straight onto the screen, so the
+						// position is sent as is, from the first contact on.
+						vmouse.WriteEvent(device.EV_ABS, device.ABS_X, s0.X)
+						vmouse.WriteEvent(device.EV_ABS, device.ABS_Y, s0.Y)
+						if dwell != nil && (s0.X != p0.X || s0.Y != p0.Y) {
+							dwell.Moved()
+						}
+					}
+
+					if s0.Active && p0.Active {
+						dx := float64(s0.X - p0.X)
+						dy := float64(s0.Y - p0.Y)
+						if profile.RotateWithScreen {
+							dx, dy = e.Host.Rotate(dx, dy)
+						}
+						if nav != nil {
+							nav.Move(dx, dy)
+						}
+
+						if fingerDrag != nil && currentFingerCount == 3 && !fingerDrag.Dragging() && status.Active() && !isPalmRejected {
+							// Three fingers drag rather than swipe, once
+							// they have moved further than a tap may.
+							gestureAccX += dx
+							gestureAccY += dy
+							if math.Hypot(gestureAccX, gestureAccY) > profile.TapMoveDistance(info) {
+								fingerDrag.Move()
+							}
+
+						} else if fingerDrag != nil && fingerDrag.Dragging() {
+							// Fingers lifting one by one at the end of a
+							// drag neither point nor scroll.
+							if currentFingerCount == 3 {
+								accel := ptrAccel.Factor(math.Hypot(dx, dy), device.Time(event.Time))
+								vx := dx*profile.MoveSensitivity*accel + fracMX
+								vy := dy*profile.MoveSensitivity*accel + fracMY
+								mx, my := int32(vx), int32(vy)
+								fracMX, fracMY = vx-float64(mx), vy-float64(my)
+								if mx != 0 || my != 0 {
+									vmouse.WriteEvent(device.EV_REL, device.REL_X, mx)
+									vmouse.WriteEvent(device.EV_REL, device.REL_Y, my)
+									e.Metrics.Moved(mx, my)
+								}
+							}
+
+						} else if (currentFingerCount == 3 || currentFingerCount == 4) && !gestureTriggered && profile.Gestures {
+							gestureAccX += dx
+							gestureAccY += dy
+
+							threshold, prefix := GestureDistThreshold, ""
+							if currentFingerCount == 4 {
+								threshold, prefix = FourFingerGestureDistThreshold, "four-finger-"
+							}
+							gesture := ""
+							if gestureAccX > threshold {
+								gesture = prefix + "swipe-right"
+							} else if gestureAccX < -threshold {
+								gesture = prefix + "swipe-left"
+							} else if gestureAccY < -threshold {
+								gesture = prefix + "swipe-up"
+							} else if gestureAccY > threshold {
+								gesture = prefix + "swipe-down"
+							}
+							if gesture != "" && e.Locked.Load() {
+								// Swallow the swipe rather than chord into the lock screen.
+								gestureTriggered = true
+							} else if gesture != "" {
+								if gesture == profile.ScrollModeGesture {
+									e.SetOneFingerScroll(!e.OneFingerScroll.Load())
+								} else if gesture == e.CycleGesture {
+									e.Host.CycleProfile()
+								} else if !e.Host.Dispatch(gesture) {
+									pressChord(e.Loop, e.Outputs.Keyboard, GestureChords[gesture])
+								}
+								gestureTriggered = true
+								status.SetGesture(gesture)
+								e.Host.Beep()
+							}
+
+						} else if currentFingerCount == 2 || (currentFingerCount == 1 && e.OneFingerScroll.Load() && !gestureTriggered) {
+							// The touch is kept from pointing from now on,
+							// but scrolls only once it can't be a tap.
+							isScrolling = true
+							if coast != nil {
+								coast.Track(dx, dy, device.Time(event.Time))
+							}
+							if sx, sy := scroll.Move(dx, dy, device.Time(event.Time)); scroll.Scrolled() {
+								scrollBy(sx, sy, nav != nil && nav.Holding(time.Now()))
+							}
+
+						} else if currentFingerCount == 1 && !isScrolling && !gestureTriggered && profile.Output == config.OutputRelative {
+							currP := s0.P
+							moveDist := math.Abs(dx) + math.Abs(dy)
+
+							// A touch already under way holds still while
+							// keys are typed, as the hand may have shifted.
+							typing := profile.DisableWhileTyping && e.Host.Typing(time.Now(), profile.TypingTimeout())
+
+							if currP >= minMovePressure && !typing &&
+								!(currP < LowPressureThreshold && moveDist < SmallMoveCutoff) &&
+								math.Abs(dx) < 400 && math.Abs(dy) < 400 {
+								fx, fy := dx, dy
+								if smooth != nil {
+									fx, fy = smooth.Filter(dx, dy, device.Time(event.Time))
+								}
+								accel := ptrAccel.Factor(math.Hypot(fx, fy), device.Time(event.Time))
+								// Smoothed motion comes in fractions,
+								// which add up rather than being lost.
+								vx := fx*profile.MoveSensitivity*accel + fracMX
+								vy := fy*profile.MoveSensitivity*accel + fracMY
+								mx, my := int32(vx), int32(vy)
+								fracMX, fracMY = vx-float64(mx), vy-float64(my)
+								if ball != nil {
+									ball.Track(float64(mx), float64(my), device.Time(event.Time))
+								}
+								if e.Host.Saving() && !profile.LowLatency {
+									// Sum motion up and report it at a lower rate.
+									heldMX, heldMY = heldMX+mx, heldMY+my
+									mx, my = 0, 0
+									if now := device.Time(event.Time); now.Sub(lastMotionOut) >= SaverMotionInterval {
+										mx, my, heldMX, heldMY = heldMX, heldMY, 0, 0
+										lastMotionOut = now
+									}
+								}
+								if mx != 0 || my != 0 {
+									vmouse.WriteEvent(device.EV_REL, device.REL_X, mx)
+									vmouse.WriteEvent(device.EV_REL, device.REL_Y, my)
+									e.Metrics.Moved(mx, my)
+									if dwell != nil {
+										dwell.Moved()
+									}
+								}
+							}
+						}
+					}
+
+					vmouse.Syn()
+
+					prevSlots = slots
+				}
+			}
+		}
+		// One write for everything the batch produced.
+		vmouse.Flush()
+		pool <- in.events
+		e.Metrics.ObserveLoop(time.Since(batchStart))
+	}
+}
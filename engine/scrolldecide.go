@@ -0,0 +1,75 @@
+package engine
+
+import (
+	"math"
+	"time"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+// scrollDecision is what a touch that scrolls when it moves has been taken
+// for so far.
+type scrollDecision int
+
+const (
+	scrollUndecided scrollDecision = iota
+	// scrollPending holds its motion back, as it may still be a tap.
+	scrollPending
+	// scrollDecided passes its motion on as scrolling.
+	scrollDecided
+)
+
+// scrollDecider tells two fingers scrolling from a two-finger tap (and one
+// finger, in one-finger scroll mode, from a tap). Their motion is held back
+// until it goes further than a tap may, or until the touch has lasted too
+// long to be one; then it is a scroll, and what was held back is scrolled at
+// once. A touch that lifts before that was no scroll, however little it
+// moved, and may tap. All its methods run on the event loop.
+type scrollDecider struct {
+	window time.Duration
+	limit  float64
+
+	state        scrollDecision
+	since        time.Time
+	heldX, heldY float64
+}
+
+// newScrollDecider returns the decider for p's taps on the touchpad info
+// describes.
+func newScrollDecider(p config.Profile, info device.Info) *scrollDecider {
+	return &scrollDecider{window: p.TapTimeout(), limit: p.TapMoveDistance(info)}
+}
+
+// Move notes scroll motion at t and returns what of it to scroll: nothing
+// while undecided, everything held back once it is a scroll, and after that
+// the motion as it comes.
+func (d *scrollDecider) Move(dx, dy float64, t time.Time) (float64, float64) {
+	switch d.state {
+	case scrollUndecided:
+		d.state, d.since = scrollPending, t
+		d.heldX, d.heldY = 0, 0
+		fallthrough
+	case scrollPending:
+		d.heldX += dx
+		d.heldY += dy
+		if math.Hypot(d.heldX, d.heldY) <= d.limit && t.Sub(d.since) < d.window {
+			return 0, 0
+		}
+		d.state = scrollDecided
+		return d.heldX, d.heldY
+	}
+	return dx, dy
+}
+
+// Scrolled reports whether the touch was taken for a scroll, in which case
+// it was no tap.
+func (d *scrollDecider) Scrolled() bool {
+	return d.state == scrollDecided
+}
+
+// Reset forgets the touch, for the next one.
+func (d *scrollDecider) Reset() {
+	d.state = scrollUndecided
+	d.heldX, d.heldY = 0, 0
+}
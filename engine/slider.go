@@ -0,0 +1,68 @@
+package engine
+
+import (
+	"touchpad/config"
+	"touchpad/device"
+)
+
+// edgeSlider turns one-finger drags along a strip at the edge of the pad into
+// key presses, one per step of travel: right sends up, left sends down. All
+// its methods run on the event loop.
+type edgeSlider struct {
+	kbd      EventSink
+	up, down uint16
+	// strip is how far from the top edge a touch may start and still
+	// slide; step is the travel per key press.
+	strip int32
+	step  float64
+	acc   float64
+}
+
+// newBrightnessSlider returns a slider along the top edge sending the
+// brightness keys, or nil when p has it off.
+func newBrightnessSlider(kbd EventSink, p config.Profile, info device.Info) *edgeSlider {
+	if p.BrightnessStrip <= 0 || p.BrightnessSteps <= 0 || info.MaxX <= 0 {
+		return nil
+	}
+	return &edgeSlider{
+		kbd:   kbd,
+		up:    config.KeyNames["brightnessup"],
+		down:  config.KeyNames["brightnessdown"],
+		strip: int32(p.BrightnessStrip * float64(info.MaxY)),
+		step:  float64(info.MaxX) / float64(p.BrightnessSteps),
+	}
+}
+
+// Starts reports whether a touch landing on s slides, and if so begins
+// measuring its travel.
+func (e *edgeSlider) Starts(s device.Slot) bool {
+	if !s.Active || s.Y > e.strip {
+		return false
+	}
+	e.acc = 0
+	return true
+}
+
+// Slide adds dx of horizontal travel and presses a key for every full step.
+func (e *edgeSlider) Slide(dx int32) {
+	e.acc += float64(dx)
+	sent := false
+	for ; e.acc >= e.step; e.acc -= e.step {
+		e.press(e.up)
+		sent = true
+	}
+	for ; e.acc <= -e.step; e.acc += e.step {
+		e.press(e.down)
+		sent = true
+	}
+	if sent {
+		e.kbd.Flush()
+	}
+}
+
+func (e *edgeSlider) press(code uint16) {
+	e.kbd.WriteEvent(device.EV_KEY, code, 1)
+	e.kbd.Syn()
+	e.kbd.WriteEvent(device.EV_KEY, code, 0)
+	e.kbd.Syn()
+}
@@ -0,0 +1,84 @@
+package engine
+
+import (
+	"math"
+	"time"
+
+	"touchpad/config"
+)
+
+// OneEuroDerivativeCutoff is the cutoff, in Hz, for the speed estimate the
+// 1€ filter adapts by.
+const OneEuroDerivativeCutoff = 1.0
+
+// motionFilter smooths pointer motion on its way from the touchpad's
+// deltas to the pointer's, to take out the jitter of a finger held almost
+// still. Its methods run on the event loop.
+type motionFilter interface {
+	// Filter returns the smoothed motion for a frame at t that moved dx,
+	// dy touchpad units.
+	Filter(dx, dy float64, t time.Time) (float64, float64)
+	// Reset starts afresh for a new touch.
+	Reset()
+}
+
+// newMotionFilter returns nil when p doesn't smooth, which low_latency
+// implies since any filter lags.
+func newMotionFilter(p config.Profile) motionFilter {
+	if p.LowLatency {
+		return nil
+	}
+	switch p.Smoothing {
+	case config.SmoothingOneEuro:
+		return &oneEuroFilter{minCutoff: p.SmoothingMinCutoff, beta: p.SmoothingBeta}
+	}
+	return nil
+}
+
+// oneEuroFilter is the 1€ filter (Casiez, Roussel and Vogel, 2012) applied
+// to the finger's position: a low-pass filter whose cutoff rises with
+// speed, so a slow finger is smoothed heavily and a fast one hardly delayed.
+type oneEuroFilter struct {
+	minCutoff, beta float64
+
+	started bool
+	last    time.Time
+	// x, y is the finger's raw position relative to where the touch began;
+	// fx, fy the filtered one; vx, vy its filtered speed.
+	x, y, fx, fy, vx, vy float64
+}
+
+func (f *oneEuroFilter) Reset() {
+	*f = oneEuroFilter{minCutoff: f.minCutoff, beta: f.beta}
+}
+
+func (f *oneEuroFilter) Filter(dx, dy float64, t time.Time) (float64, float64) {
+	f.x += dx
+	f.y += dy
+	dt := t.Sub(f.last).Seconds()
+	f.last = t
+	if !f.started || dt <= 0 {
+		f.started = true
+		f.fx, f.fy = f.x, f.y
+		return dx, dy
+	}
+	px, py := f.fx, f.fy
+	f.fx, f.vx = f.step(f.x, f.fx, f.vx, dt)
+	f.fy, f.vy = f.step(f.y, f.fy, f.vy, dt)
+	return f.fx - px, f.fy - py
+}
+
+// step filters one axis: raw value x against the previous filtered value
+// prev and its speed v, dt seconds later.
+func (f *oneEuroFilter) step(x, prev, v, dt float64) (float64, float64) {
+	v += oneEuroAlpha(OneEuroDerivativeCutoff, dt) * ((x-prev)/dt - v)
+	cutoff := f.minCutoff + f.beta*math.Abs(v)
+	return prev + oneEuroAlpha(cutoff, dt)*(x-prev), v
+}
+
+// oneEuroAlpha is the smoothing factor of a low-pass filter with the given
+// cutoff frequency for a sample dt seconds after the last.
+func oneEuroAlpha(cutoff, dt float64) float64 {
+	tau := 1 / (2 * math.Pi * cutoff)
+	return 1 / (1 + tau/dt)
+}
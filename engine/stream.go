@@ -0,0 +1,64 @@
+package engine
+
+import "touchpad/device"
+
+// StreamEvent is one record on the processed-event broadcast stream: what the
+// driver made of the touchpad, rather than the raw evdev traffic.
+type StreamEvent struct {
+	TimeUsec int64 `json:"time_us"`
+	// Type is "frame", "gesture", "tap", "double-tap" (a tap that made
+	// the second click of a double click), "press", "release", "dwell" (a
+	// dwell click), "dwell-warning" (one is about to happen), "hot-zone"
+	// (a hot zone was tapped; Name is its command), "force-press" (a
+	// deep press; Name is its action) or "profile" (the profile gesture
+	// switched to profile Name).
+	Type    string `json:"type"`
+	Fingers int    `json:"fingers,omitempty"`
+	// Contacts is set on frames: every tracked slot after the SYN_REPORT.
+	Contacts []Contact `json:"contacts,omitempty"`
+	// Name is the gesture name or the button ("left", "right", "middle").
+	Name string `json:"name,omitempty"`
+	// Mode is set on frames: what the state machine is doing with the
+	// touch ("pointing", "scrolling", "gesture", "palm", ...).
+	Mode string `json:"mode,omitempty"`
+}
+
+type Contact struct {
+	Slot int   `json:"slot"`
+	X    int32 `json:"x"`
+	Y    int32 `json:"y"`
+	P    int32 `json:"p"`
+}
+
+// publishFrame reports the current contacts, finger count and mode.
+func (e *Engine) publishFrame(slots *device.Slots, fingers int, mode string) {
+	if e.Events == nil || !e.Events.Active() {
+		return
+	}
+	var contacts []Contact
+	for id, s := range slots {
+		if s.Active {
+			contacts = append(contacts, Contact{Slot: id, X: s.X, Y: s.Y, P: s.P})
+		}
+	}
+	e.Events.Publish(StreamEvent{Type: "frame", Fingers: fingers, Contacts: contacts, Mode: mode})
+}
+
+// touchMode names what the state machine is doing with the current touch.
+func touchMode(active, palm, scrolling, gestured bool, fingers int) string {
+	switch {
+	case !active:
+		return "paused"
+	case palm:
+		return "palm"
+	case fingers == 0:
+		return "none"
+	case gestured:
+		return "gesture-done"
+	case fingers >= 3:
+		return "gesture"
+	case scrolling || fingers == 2:
+		return "scrolling"
+	}
+	return "pointing"
+}
@@ -0,0 +1,106 @@
+package engine
+
+import (
+	"time"
+
+	"touchpad/config"
+)
+
+// tapDragState is where a tap-and-drag stands.
+type tapDragState int
+
+const (
+	tapDragIdle tapDragState = iota
+	// tapDragWaiting holds a tap's button in case a touch follows.
+	tapDragWaiting
+	// tapDragDragging holds it while the touch that followed is down.
+	tapDragDragging
+	// tapDragLocked holds it after that touch lifted, for drag lock.
+	tapDragLocked
+)
+
+// tapDragger turns a tap followed quickly by another touch into a drag: the
+// tap's left button stays pressed for the window in case a finger comes
+// down, and then until that finger lifts or, with drag lock, until the next
+// tap. All its methods run on the event loop.
+type tapDragger struct {
+	loop    *Loop
+	window  time.Duration
+	lock    bool
+	release func()
+
+	state tapDragState
+	// fromLock is set for a touch that picked up a locked drag.
+	fromLock bool
+	// gen counts windows, so that one that lapses can tell whether it is
+	// still the current one.
+	gen int
+}
+
+// newTapDragger returns nil unless p drags after a tap. release lets go of
+// the left button.
+func newTapDragger(loop *Loop, p config.Profile, release func()) *tapDragger {
+	if !p.TapDrag || p.TapDragMs <= 0 {
+		return nil
+	}
+	return &tapDragger{
+		loop:    loop,
+		window:  time.Duration(p.TapDragMs) * time.Millisecond,
+		lock:    p.TapDragLock,
+		release: release,
+	}
+}
+
+// Tapped takes over the left button a tap has just pressed, releasing it
+// once the window lapses without a touch.
+func (t *tapDragger) Tapped() {
+	t.state = tapDragWaiting
+	t.gen++
+	gen := t.gen
+	t.loop.After(t.window, func() {
+		if t.gen == gen && t.state == tapDragWaiting {
+			t.Cancel()
+		}
+	})
+}
+
+// Down picks up the drag, if any, for a touch that just landed.
+func (t *tapDragger) Down() {
+	switch t.state {
+	case tapDragWaiting, tapDragLocked:
+		t.fromLock = t.state == tapDragLocked
+		t.state = tapDragDragging
+	}
+}
+
+// Up ends the dragging touch, which had fingers and was a tap or not, and
+// reports whether it is done with the touch, in which case it doesn't click.
+func (t *tapDragger) Up(fingers int, tap bool) bool {
+	if t.state != tapDragDragging {
+		return false
+	}
+	switch {
+	case t.fromLock && tap:
+		// The final tap of drag lock lets go.
+		t.Cancel()
+		return true
+	case fingers != 1 || tap:
+		// Not a drag after all, but a double click or a tap of its
+		// own.
+		t.Cancel()
+		return false
+	case t.lock:
+		t.state = tapDragLocked
+		return true
+	}
+	t.Cancel()
+	return true
+}
+
+// Cancel lets go of the button, if held.
+func (t *tapDragger) Cancel() {
+	if t.state != tapDragIdle {
+		t.state = tapDragIdle
+		t.release()
+	}
+}
@@ -0,0 +1,60 @@
+package engine
+
+import "touchpad/device"
+
+// touchEmitter re-emits contacts on the touchscreen device using the
+// multitouch type B protocol. Only the event loop uses it.
+type touchEmitter struct {
+	// ids holds the tracking id of each slot that is down.
+	ids      [device.MaxSlots]int32
+	down     [device.MaxSlots]bool
+	nextID   int32
+	touching bool
+}
+
+// Frame sends the contacts in slots as one report. Passing an empty set
+// lifts every contact.
+func (e *touchEmitter) Frame(v EventSink, slots *device.Slots) {
+	first := -1
+	for id, s := range slots {
+		if !s.Active && !e.down[id] {
+			continue
+		}
+		v.WriteEvent(device.EV_ABS, device.ABS_MT_SLOT, int32(id))
+		if !s.Active {
+			v.WriteEvent(device.EV_ABS, device.ABS_MT_TRACKING_ID, -1)
+			e.down[id] = false
+			continue
+		}
+		if !e.down[id] {
+			e.ids[id] = e.nextID
+			e.nextID = (e.nextID + 1) & 0xffff
+			e.down[id] = true
+			v.WriteEvent(device.EV_ABS, device.ABS_MT_TRACKING_ID, e.ids[id])
+		}
+		v.WriteEvent(device.EV_ABS, device.ABS_MT_POSITION_X, s.X)
+		v.WriteEvent(device.EV_ABS, device.ABS_MT_POSITION_Y, s.Y)
+		if first < 0 {
+			first = id
+		}
+	}
+
+	// Single-touch axes follow the first contact, for clients that don't
+	// read multitouch.
+	if touching := first >= 0; touching != e.touching {
+		e.touching = touching
+		v.WriteEvent(device.EV_KEY, device.BTN_TOUCH, boolValue(touching))
+	}
+	if first >= 0 {
+		v.WriteEvent(device.EV_ABS, device.ABS_X, slots[first].X)
+		v.WriteEvent(device.EV_ABS, device.ABS_Y, slots[first].Y)
+	}
+	v.Syn()
+}
+
+func boolValue(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
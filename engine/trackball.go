@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"math"
+	"time"
+
+	"touchpad/config"
+)
+
+const (
+	// TrackballWindow is how far back the motion before a lift counts
+	// towards the ball's speed; a finger that rested longer than this
+	// before lifting leaves the ball still.
+	TrackballWindow = 60 * time.Millisecond
+	// TrackballTick is how often a coasting ball moves the pointer.
+	TrackballTick = 10 * time.Millisecond
+	// TrackballMinSpeed is the speed, in pointer units per second, below
+	// which the ball stops.
+	TrackballMinSpeed = 30.0
+)
+
+type motionSample struct {
+	at     time.Time
+	dx, dy float64
+}
+
+// trackball keeps the pointer moving after a flick, slowing down with
+// friction until it stops or a finger lands on the pad. The same physics
+// keep a scroll going (see newKineticScroll). All its methods run on the
+// event loop.
+type trackball struct {
+	loop     *Loop
+	friction float64
+	move     func(dx, dy int32)
+
+	samples [8]motionSample
+	next    int
+	// vx and vy are the speed while coasting; remX and remY carry the
+	// fractions of a unit not sent yet.
+	vx, vy     float64
+	remX, remY float64
+	last       time.Time
+	// rolling is set while the ball coasts.
+	rolling bool
+	// spin counts flicks, so ticks left from an earlier one do nothing.
+	spin int
+}
+
+// newTrackball returns nil unless p is in trackball mode. move sends
+// pointer motion.
+func newTrackball(loop *Loop, p config.Profile, move func(dx, dy int32)) *trackball {
+	if !p.Trackball {
+		return nil
+	}
+	return &trackball{loop: loop, friction: p.TrackballFriction, move: move}
+}
+
+// newKineticScroll returns nil unless p scrolls kinetically. scroll sends
+// scroll motion in touchpad units, as a two-finger drag would.
+func newKineticScroll(loop *Loop, p config.Profile, scroll func(dx, dy int32)) *trackball {
+	if !p.KineticScroll {
+		return nil
+	}
+	return &trackball{loop: loop, friction: p.KineticScrollFriction, move: scroll}
+}
+
+// Track records pointer motion sent at t while the finger is down.
+func (b *trackball) Track(dx, dy float64, t time.Time) {
+	b.samples[b.next] = motionSample{t, dx, dy}
+	b.next = (b.next + 1) % len(b.samples)
+}
+
+// Release spins the ball with the speed of the motion just before t, when
+// the finger lifted.
+func (b *trackball) Release(t time.Time) {
+	// The first sample only marks when the motion after it started.
+	var sx, sy float64
+	var first, last time.Time
+	for i := range b.samples {
+		s := b.samples[(b.next+i)%len(b.samples)]
+		if s.at.IsZero() || t.Sub(s.at) > TrackballWindow {
+			continue
+		}
+		if first.IsZero() {
+			first = s.at
+		} else {
+			sx, sy, last = sx+s.dx, sy+s.dy, s.at
+		}
+	}
+	b.samples = [len(b.samples)]motionSample{}
+	if last.IsZero() {
+		return
+	}
+	span := last.Sub(first).Seconds()
+	if span <= 0 {
+		return
+	}
+	b.vx, b.vy = sx/span, sy/span
+	if math.Hypot(b.vx, b.vy) < TrackballMinSpeed {
+		return
+	}
+	b.spin++
+	b.rolling = true
+	b.remX, b.remY = 0, 0
+	b.last = time.Now()
+	spin := b.spin
+	b.loop.After(TrackballTick, func() { b.tick(spin) })
+}
+
+// Stop brings the ball to rest, as a finger landing on it does, and reports
+// whether it was still rolling.
+func (b *trackball) Stop() bool {
+	rolling := b.rolling
+	b.spin++
+	b.rolling = false
+	b.samples = [len(b.samples)]motionSample{}
+	return rolling
+}
+
+func (b *trackball) tick(spin int) {
+	if spin != b.spin {
+		return
+	}
+	now := time.Now()
+	dt := now.Sub(b.last).Seconds()
+	b.last = now
+	decay := math.Exp(-b.friction * dt)
+	b.vx, b.vy = b.vx*decay, b.vy*decay
+	if math.Hypot(b.vx, b.vy) < TrackballMinSpeed {
+		b.rolling = false
+		return
+	}
+	b.remX += b.vx * dt
+	b.remY += b.vy * dt
+	dx, dy := int32(b.remX), int32(b.remY)
+	b.remX -= float64(dx)
+	b.remY -= float64(dy)
+	if dx != 0 || dy != 0 {
+		b.move(dx, dy)
+	}
+	b.loop.After(TrackballTick, func() { b.tick(spin) })
+}
@@ -0,0 +1,28 @@
+package engine
+
+import (
+	"touchpad/config"
+	"touchpad/device"
+)
+
+// tapZoneAt returns the first of zones with a tap action that a tap with
+// fingers at x, y lands in, or nil.
+func tapZoneAt(zones []config.HotZone, x, y int32, fingers int, info device.Info) *config.HotZone {
+	for i, z := range zones {
+		if z.TapAction() != "" && z.Contains(x, y, fingers, info) {
+			return &zones[i]
+		}
+	}
+	return nil
+}
+
+// pressZoneAt returns the first of zones with a press action that a click
+// with fingers at x, y lands in, or nil.
+func pressZoneAt(zones []config.HotZone, x, y int32, fingers int, info device.Info) *config.HotZone {
+	for i, z := range zones {
+		if z.Press != "" && z.Contains(x, y, fingers, info) {
+			return &zones[i]
+		}
+	}
+	return nil
+}
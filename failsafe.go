@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"touchpad/device"
+	"touchpad/uinput"
+)
+
+// runButtonFailsafe releases virtual buttons and keys that have been held
+// longer than maxHold while the touchpad is not being touched. Nothing the
+// driver does legitimately holds input that long without a finger down, so
+// this only catches state-machine bugs and a wedged loop, which would
+// otherwise leave BTN_LEFT or Alt stuck system-wide.
+func runButtonFailsafe(seats []*seatInstance, maxHold time.Duration, dormant *dormancy) {
+	period := max(maxHold/4, 100*time.Millisecond)
+	tick := time.NewTicker(period)
+	for range tick.C {
+		if dormant.Dormant() {
+			// Nothing was held when it went dormant, and nothing can be
+			// pressed before input wakes it.
+			tick.Stop()
+			<-dormant.Wait()
+			tick.Reset(period)
+			continue
+		}
+		for _, inst := range seats {
+			// Touchpads with one pointer share their devices, so a
+			// finger on any of them may be what holds a button.
+			if touching(seats, inst.pointer(), maxHold) {
+				continue
+			}
+			cutoff := time.Now().Add(-maxHold)
+			for _, v := range inst.outputs() {
+				if dragLocked(seats, v) {
+					// Held on purpose, until the next tap.
+					continue
+				}
+				for _, code := range v.ReleaseStuck(cutoff) {
+					inst.engine.Metrics.Stuck.Add(1)
+					slog.Warn("released stuck input", "seat", inst.cfg.Seat,
+						"device", v.Name(), "code", codeName(device.EV_KEY, code), "held_over", maxHold)
+				}
+			}
+		}
+	}
+}
+
+// touching reports whether a finger has recently been down on a touchpad
+// driving pointer v.
+func touching(seats []*seatInstance, v *uinput.Device, maxHold time.Duration) bool {
+	for _, inst := range seats {
+		if inst.pointer() == v && inst.status.Get().Fingers > 0 && inst.idle.SinceTouch() < maxHold {
+			return true
+		}
+	}
+	return false
+}
+
+// dragLocked reports whether a sticky drag holds the button of pointer v.
+func dragLocked(seats []*seatInstance, v *uinput.Device) bool {
+	for _, inst := range seats {
+		if inst.pointer() == v && inst.engine.DragLocked.Load() {
+			return true
+		}
+	}
+	return false
+}
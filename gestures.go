@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"touchpad/config"
+	"touchpad/engine"
+)
+
+// gestureBackend carries out gestures some other way than key chords.
+// Dispatch returns false if it did not handle the gesture, in which case the
+// chord is sent as usual.
+type gestureBackend interface {
+	Dispatch(gesture string) bool
+}
+
+// gestureCommands checks gesture_actions and returns the command bound to
+// each gesture.
+func gestureCommands(actions map[string]string) (map[string]string, error) {
+	commands := make(map[string]string, len(actions))
+	for gesture, action := range actions {
+		if _, ok := engine.GestureChords[gesture]; !ok {
+			return nil, fmt.Errorf("gesture_actions: unknown gesture %q", gesture)
+		}
+		command, ok := strings.CutPrefix(action, config.ExecActionPrefix)
+		if !ok {
+			return nil, fmt.Errorf("gesture_actions: %s: unknown action %q", gesture, action)
+		}
+		if strings.TrimSpace(command) == "" {
+			return nil, fmt.Errorf("gesture_actions: %s: empty command", gesture)
+		}
+		commands[gesture] = command
+	}
+	return commands, nil
+}
+
+// execBackend runs the commands gesture_actions binds gestures to through
+// the launcher, and hands any other gesture on to next.
+type execBackend struct {
+	commands map[string]string
+	seat     string
+	launcher *launcher
+	next     gestureBackend
+}
+
+func (e *execBackend) Dispatch(gesture string) bool {
+	command, ok := e.commands[gesture]
+	if !ok {
+		return e.next != nil && e.next.Dispatch(gesture)
+	}
+	e.launcher.Run(e.seat, command)
+	return true
+}
+
+// newGestureBackend returns the backend selected by gesture_backend for the
+// touchpads on seat, or nil for plain key chords.
+func newGestureBackend(cfg config.Config, seat string) gestureBackend {
+	switch cfg.GestureBackend {
+	case "hyprland", "sway", "auto":
+		return newCompositorBackend(cfg.GestureBackend, seat, cfg.CompositorCommands)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ module touchpad
 go 1.25.5
 
 require (
-	github.com/bendahl/uinput v1.7.0
+	github.com/godbus/dbus/v5 v5.2.2
 	github.com/gvalkov/golang-evdev v0.0.0-20220815104727-7e27d6ce89b6
+	golang.org/x/sys v0.27.0
 )
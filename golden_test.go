@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+// GoldenDir is where TestGolden looks for traces.
+const GoldenDir = "testdata/golden"
+
+// TestGolden replays each NAME.events recording in GoldenDir with the default
+// settings, overridden by its "# config:" header if any, and compares what
+// the virtual devices are sent with NAME.golden: one line per event, the
+// device, code and value, reports left out since batching may split them
+// differently. A change to thresholds or the state machine that alters the
+// output of a trace fails it, and -update rewrites the golden files once the
+// new output is what is wanted:
+//
+//	go test -run TestGolden -update
+var updateGolden = flag.Bool("update", false, "write the output of the golden traces as the new golden files")
+
+func TestGolden(t *testing.T) {
+	traces, err := filepath.Glob(filepath.Join(GoldenDir, "*.events"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) == 0 {
+		t.Fatalf("no .events traces in %s", GoldenDir)
+	}
+	for _, path := range traces {
+		name := strings.TrimSuffix(filepath.Base(path), ".events")
+		t.Run(name, func(t *testing.T) {
+			// Traces replay in real time, so they run side by side.
+			t.Parallel()
+			golden := strings.TrimSuffix(path, ".events") + ".golden"
+			got, err := goldenOutput(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *updateGolden {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := goldenDiff(want, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// goldenOutput replays the trace at path and returns what it produced.
+func goldenOutput(path string) ([]byte, error) {
+	rec, err := loadRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	// The user's config has no say, so traces replay alike everywhere.
+	cfg := config.Default()
+	if rec.config != nil {
+		if err := json.Unmarshal(rec.config, &cfg); err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+	}
+	var mu sync.Mutex
+	var out bytes.Buffer
+	err = replay(path, rec, cfg, nil, func(dev string) func(typ, code uint16, value int32) {
+		return func(typ, code uint16, value int32) {
+			if typ == device.EV_SYN {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(&out, "%s %s %d\n", dev, codeName(typ, code), value)
+		}
+	})
+	return out.Bytes(), err
+}
+
+// goldenDiff describes the first difference between want and got, or
+// returns "" if there is none.
+func goldenDiff(want, got []byte) string {
+	if bytes.Equal(want, got) {
+		return ""
+	}
+	w, g := strings.Split(string(want), "\n"), strings.Split(string(got), "\n")
+	for i := range min(len(w), len(g)) {
+		if w[i] != g[i] {
+			return fmt.Sprintf("line %d: want %q, got %q", i+1, w[i], g[i])
+		}
+	}
+	if len(w) > len(g) {
+		return fmt.Sprintf("line %d: want %q, got nothing more", len(g)+1, w[len(g)])
+	}
+	return fmt.Sprintf("line %d: got %q, wanted nothing more", len(w)+1, g[len(w)])
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+
+	"touchpad/device"
+)
+
+const (
+	HeatmapCols = 64
+	HeatmapRows = 40
+	// heatmapScale is how many pixels a cell gets in the PNG export.
+	heatmapScale = 8
+)
+
+// heatmap counts, per cell of a grid laid over the touchpad, how many frames
+// had a contact there. Only the event loop writes it.
+type heatmap struct {
+	maxX, maxY atomic.Int32
+	cells      [HeatmapRows * HeatmapCols]atomic.Uint64
+}
+
+// Heatmap is an exported snapshot, row by row from the top of the pad.
+type Heatmap struct {
+	Cols   int        `json:"cols"`
+	Rows   int        `json:"rows"`
+	Counts [][]uint64 `json:"counts"`
+}
+
+func (h *heatmap) SetRange(maxX, maxY int32) {
+	h.maxX.Store(max(maxX, 1))
+	h.maxY.Store(max(maxY, 1))
+}
+
+func (h *heatmap) Add(slots *device.Slots) {
+	maxX, maxY := int64(h.maxX.Load()), int64(h.maxY.Load())
+	if maxX == 0 {
+		return
+	}
+	for _, s := range slots {
+		if !s.Active {
+			continue
+		}
+		c := int(min(max(int64(s.X), 0)*HeatmapCols/(maxX+1), HeatmapCols-1))
+		r := int(min(max(int64(s.Y), 0)*HeatmapRows/(maxY+1), HeatmapRows-1))
+		h.cells[r*HeatmapCols+c].Add(1)
+	}
+}
+
+func (h *heatmap) Snapshot() Heatmap {
+	m := Heatmap{Cols: HeatmapCols, Rows: HeatmapRows, Counts: make([][]uint64, HeatmapRows)}
+	for r := range m.Counts {
+		m.Counts[r] = make([]uint64, HeatmapCols)
+		for c := range m.Counts[r] {
+			m.Counts[r][c] = h.cells[r*HeatmapCols+c].Load()
+		}
+	}
+	return m
+}
+
+func (m Heatmap) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	for _, row := range m.Counts {
+		rec := make([]string, len(row))
+		for i, n := range row {
+			rec[i] = strconv.FormatUint(n, 10)
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WritePNG renders the counts from black through red to yellow, scaled to
+// the busiest cell.
+func (m Heatmap) WritePNG(w io.Writer) error {
+	var peak uint64
+	for _, row := range m.Counts {
+		for _, n := range row {
+			peak = max(peak, n)
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, m.Cols*heatmapScale, m.Rows*heatmapScale))
+	for r, row := range m.Counts {
+		for c, n := range row {
+			v := 0.0
+			if peak > 0 {
+				v = float64(n) / float64(peak)
+			}
+			col := color.RGBA{uint8(min(v*2, 1) * 255), uint8(max(v*2-1, 0) * 255), 0, 255}
+			for y := r * heatmapScale; y < (r+1)*heatmapScale; y++ {
+				for x := c * heatmapScale; x < (c+1)*heatmapScale; x++ {
+					img.SetRGBA(x, y, col)
+				}
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// runHeatmap implements the "heatmap" subcommand, which fetches the running
+// driver's heatmap and writes it as CSV or PNG.
+func runHeatmap(args []string) error {
+	fs := flag.NewFlagSet("heatmap", flag.ExitOnError)
+	format := fs.String("format", "csv", "output format: csv or png")
+	output := fs.String("o", "-", "output file, - for stdout")
+	socket := fs.String("socket", "", "control socket path (default: the user service's, else the system one)")
+	seat := fs.String("seat", "", "seat to export (default: the first configured)")
+	fs.Parse(args)
+
+	conn, err := dialControl(*socket)
+	if err != nil {
+		return fmt.Errorf("connect to driver: %w", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(controlRequest{Cmd: "heatmap", Seat: *seat}); err != nil {
+		return err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.OK || resp.Heatmap == nil {
+		return fmt.Errorf("driver: %s", resp.Error)
+	}
+
+	var buf bytes.Buffer
+	switch *format {
+	case "csv":
+		err = resp.Heatmap.WriteCSV(&buf)
+	case "png":
+		err = resp.Heatmap.WritePNG(&buf)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(*output, buf.Bytes(), 0644)
+}
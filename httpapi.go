@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// httpTokenPath is where the HTTP API's bearer token is written, next to
+// the control socket.
+func httpTokenPath() string {
+	return strings.TrimSuffix(controlSocketPath(), ".sock") + ".token"
+}
+
+// writeHTTPToken makes a new token for the HTTP API and writes it to path,
+// readable by the driver's user and, through the file's group, by its
+// control group: those the control socket trusts.
+func writeHTTPToken(path string, access controlAccess) (string, error) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	os.Remove(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if access.gid >= 0 {
+		if err := f.Chown(-1, access.gid); err != nil {
+			return "", err
+		}
+		if err := f.Chmod(0640); err != nil {
+			return "", err
+		}
+	}
+	if _, err := f.WriteString(token + "\n"); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// serveHTTP exposes the control protocol over HTTP for GUIs and web-based
+// tools:
+//
+//	GET  /v1/status?seat=S         one status object
+//	GET  /v1/status/stream?seat=S  server-sent events, one per change
+//	GET  /v1/events?seat=S         server-sent events, the processed-event feed
+//	POST /v1/command               body is a control request, reply as on the socket
+//
+// It only ever listens on loopback. Commands require a JSON body and requests
+// must name a loopback host, so web pages can neither post forms to it nor
+// reach it through DNS rebinding. Anything but the status needs the token at
+// httpTokenPath as a bearer token, since every local user can reach the port.
+func serveHTTP(addr string, seats []*seatInstance, access controlAccess) (*http.Server, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !isLoopbackHost(host) {
+		return nil, fmt.Errorf("refusing to serve the HTTP API on non-loopback address %s", addr)
+	}
+	tokenPath := httpTokenPath()
+	token, err := writeHTTPToken(tokenPath, access)
+	if err != nil {
+		return nil, fmt.Errorf("write %s: %w", tokenPath, err)
+	}
+	trusted := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "missing or wrong token from "+tokenPath, http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/status", func(w http.ResponseWriter, r *http.Request) {
+		writeControlResponse(w, execControl(seats, controlRequest{Cmd: "status", Seat: r.URL.Query().Get("seat")}))
+	})
+	mux.HandleFunc("GET /metrics", trusted(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, seats)
+	}))
+	mux.HandleFunc("GET /v1/heatmap.csv", trusted(func(w http.ResponseWriter, r *http.Request) {
+		writeHeatmap(w, r, seats, "text/csv", Heatmap.WriteCSV)
+	}))
+	mux.HandleFunc("GET /v1/heatmap.png", trusted(func(w http.ResponseWriter, r *http.Request) {
+		writeHeatmap(w, r, seats, "image/png", Heatmap.WritePNG)
+	}))
+	mux.HandleFunc("GET /v1/latency", trusted(func(w http.ResponseWriter, r *http.Request) {
+		writeControlResponse(w, execControl(seats, controlRequest{Cmd: "latency", Seat: r.URL.Query().Get("seat")}))
+	}))
+	mux.HandleFunc("GET /v1/status/stream", func(w http.ResponseWriter, r *http.Request) {
+		streamStatus(w, r, seats)
+	})
+	mux.HandleFunc("GET /v1/events", trusted(func(w http.ResponseWriter, r *http.Request) {
+		streamEvents(w, r, seats)
+	}))
+	mux.HandleFunc("POST /v1/command", trusted(func(w http.ResponseWriter, r *http.Request) {
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			http.Error(w, "expected application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		var req controlRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeControlResponse(w, execControl(seats, req))
+	}))
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Handler: loopbackOnly(mux)}
+	go srv.Serve(l)
+	return srv, nil
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if !isLoopbackHost(host) {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeHeatmap(w http.ResponseWriter, r *http.Request, seats []*seatInstance, contentType string, write func(Heatmap, io.Writer) error) {
+	inst := findSeat(seats, r.URL.Query().Get("seat"))
+	if inst == nil {
+		http.Error(w, "unknown seat", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	write(inst.heatmap.Snapshot(), w)
+}
+
+func writeControlResponse(w http.ResponseWriter, resp controlResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.OK {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func streamStatus(w http.ResponseWriter, r *http.Request, seats []*seatInstance) {
+	inst := findSeat(seats, r.URL.Query().Get("seat"))
+	if inst == nil {
+		http.Error(w, "unknown seat", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	ch, stop := inst.status.Subscribe()
+	defer stop()
+	for {
+		select {
+		case s := <-ch:
+			b, _ := json.Marshal(s)
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func streamEvents(w http.ResponseWriter, r *http.Request, seats []*seatInstance) {
+	inst := findSeat(seats, r.URL.Query().Get("seat"))
+	if inst == nil {
+		http.Error(w, "unknown seat", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	ch, stop := inst.events.Subscribe()
+	defer stop()
+	for {
+		select {
+		case ev := <-ch:
+			b, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
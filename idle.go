@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// idleState tracks whether the user is idle. The seat drops into low-power
+// mode with OnChange, and the periodic work parks once every seat is idle.
+// Touch input ends idleness at once, without waiting for logind to notice.
+type idleState struct {
+	idle atomic.Bool
+	// lastTouch is when input last arrived, in Unix nanoseconds.
+	lastTouch atomic.Int64
+	mu        sync.Mutex
+	listeners []func(idle bool)
+}
+
+func (s *idleState) Idle() bool {
+	return s.idle.Load()
+}
+
+func (s *idleState) OnChange(fn func(idle bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *idleState) Set(idle bool) {
+	if s.idle.Swap(idle) == idle {
+		return
+	}
+	s.mu.Lock()
+	listeners := s.listeners
+	s.mu.Unlock()
+	for _, fn := range listeners {
+		fn(idle)
+	}
+}
+
+// Touch is called by the event loop for every batch of input.
+func (s *idleState) Touch() {
+	s.lastTouch.Store(time.Now().UnixNano())
+	if s.idle.Load() {
+		s.Set(false)
+	}
+}
+
+// SinceTouch returns how long ago input last arrived.
+func (s *idleState) SinceTouch() time.Duration {
+	return time.Since(time.Unix(0, s.lastTouch.Load()))
+}
+
+// watchIdle follows the seat's IdleHint, which logind derives from what the
+// compositor or screensaver reports for the sessions on it.
+func watchIdle(seat string, onChange func(idle bool)) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("connect system bus: %w", err)
+	}
+
+	seatPath := dbus.ObjectPath(logindPath + "/seat/" + seat)
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(seatPath),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+	)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("subscribe to %s: %w", seatPath, err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	seatObj := conn.Object(logindName, seatPath)
+	idleHint := func() bool {
+		v, err := seatObj.GetProperty("org.freedesktop.login1.Seat.IdleHint")
+		if err != nil {
+			return false
+		}
+		idle, _ := v.Value().(bool)
+		return idle
+	}
+	onChange(idleHint())
+
+	go func() {
+		for range signals {
+			onChange(idleHint())
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"touchpad/config"
+	"touchpad/device"
+	"touchpad/engine"
+)
+
+// inspectInterval caps how often the inspector prints a frame.
+const inspectInterval = 100 * time.Millisecond
+
+// runInspect implements the "inspect" subcommand: it prints every contact's
+// raw position and pressure as you touch the pad, along with the zones of
+// the configuration that it falls in, for placing zones without guesswork.
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	socket := fs.String("socket", "", "control socket path (default: the user service's, else the system one)")
+	seat := fs.String("seat", "", "seat to inspect (default: the first configured)")
+	configFile := fs.String("config", "", "config file whose zones to show (default: the driver's)")
+	fs.Parse(args)
+
+	path := *configFile
+	if path == "" {
+		path = config.Path()
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v; showing the default zones\n", err)
+	}
+
+	conn, err := dialControl(*socket)
+	if err != nil {
+		return fmt.Errorf("connect to driver: %w", err)
+	}
+	defer conn.Close()
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+
+	if err := enc.Encode(controlRequest{Cmd: "device", Seat: *seat}); err != nil {
+		return err
+	}
+	var resp controlResponse
+	if err := dec.Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.OK || resp.Device == nil {
+		return fmt.Errorf("driver: %s", resp.Error)
+	}
+	info := *resp.Device
+	if err := enc.Encode(controlRequest{Cmd: "events", Seat: *seat}); err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		conn.Close()
+	}()
+
+	fmt.Printf("%s (%s): x 0-%d, y 0-%d, pressure 0-%d. Touch the pad; Ctrl-C to stop.\n",
+		info.Name, info.Path, info.MaxX, info.MaxY, info.MaxPressure)
+	var last time.Time
+	touching := false
+	for {
+		var ev engine.StreamEvent
+		if err := dec.Decode(&ev); err != nil {
+			return nil
+		}
+		if ev.Type != "frame" {
+			continue
+		}
+		if len(ev.Contacts) == 0 {
+			if touching {
+				fmt.Println("lifted")
+			}
+			touching = false
+			continue
+		}
+		// Always show where a touch lands, then sample as it moves.
+		if touching && time.Since(last) < inspectInterval {
+			continue
+		}
+		touching, last = true, time.Now()
+		for _, ct := range ev.Contacts {
+			fmt.Printf("slot %d  x %5d (%5.1f%%)  y %5d (%5.1f%%)  p %4d  %s\n",
+				ct.Slot, ct.X, percent(ct.X, info.MaxX), ct.Y, percent(ct.Y, info.MaxY), ct.P,
+				strings.Join(zonesAt(cfg.Profile, info, ct, ev.Fingers), ", "))
+		}
+	}
+}
+
+func percent(v, limit int32) float64 {
+	if limit <= 0 {
+		return 0
+	}
+	return 100 * float64(v) / float64(limit)
+}
+
+// zonesAt names the zones of p that contact ct is in.
+func zonesAt(p config.Profile, info device.Info, ct engine.Contact, fingers int) []string {
+	var zones []string
+	if p.PalmRejection && ct.Y < info.PadY(engine.PalmZoneTop) {
+		zones = append(zones, "palm zone")
+	}
+	if p.BrightnessStrip > 0 && float64(ct.Y) <= p.BrightnessStrip*float64(info.MaxY) {
+		zones = append(zones, "brightness strip")
+	}
+	for i, z := range p.HotZones {
+		if z.Contains(ct.X, ct.Y, fingers, info) {
+			zones = append(zones, fmt.Sprintf("hot zone %d (%s)", i+1, z.Describe()))
+		}
+	}
+	if p.RightClickCorner && config.RightClickCorner.Contains(ct.X, ct.Y, fingers, info) {
+		zones = append(zones, "right-click corner")
+	}
+	if len(zones) == 0 {
+		zones = append(zones, "-")
+	}
+	return zones
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+const (
+	ServiceName     = "touchpad2mouse.service"
+	UdevRulePath    = "/etc/udev/rules.d/70-touchpad2mouse.rules"
+	IgnoreRulePath  = "/etc/udev/rules.d/71-touchpad2mouse-libinput-ignore.rules"
+	DBusPolicyPath  = "/etc/dbus-1/system.d/" + DBusName + ".conf"
+	CtlPath         = "/usr/local/bin/" + CtlName
+	SystemUnitDir   = "/etc/systemd/system"
+	UserUnitSubpath = ".config/systemd/user"
+	// DBusServiceSubpath holds session bus activation files.
+	DBusServiceSubpath = ".local/share/dbus-1/services"
+)
+
+var serviceTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=Touchpad to mouse translation driver
+After=systemd-udevd.service
+
+[Service]
+Type=notify
+{{- if .BusName}}
+BusName={{.BusName}}
+{{- end}}
+ExecStart={{.Exec}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+WatchdogSec=10
+{{- if .WantedBy}}
+
+[Install]
+WantedBy={{.WantedBy}}
+{{- end}}
+`))
+
+// The input group covers the system service. uaccess lets logind hand the
+// touchpad to the active seat user, which is what a user service needs, but
+// not uinput: whoever can create a virtual keyboard can type into every
+// session and VT, so that takes membership of the input group. The rules go
+// by udev's own classification of touchpads, set by 60-input-id.rules.
+const udevRule = `# Installed by touchpad-driver install
+KERNEL=="uinput", SUBSYSTEM=="misc", GROUP="input", MODE="0660", OPTIONS+="static_node=uinput"
+SUBSYSTEM=="input", KERNEL=="event*", ENV{ID_INPUT_TOUCHPAD}=="1", GROUP="input", MODE="0660", TAG+="uaccess"
+`
+
+// For a user service, the touchpad's arrival pulls the service into every
+// logged-in user's manager, so it only runs when there is a pad to drive.
+const userWantsRule = `SUBSYSTEM=="input", KERNEL=="event*", ENV{ID_INPUT_TOUCHPAD}=="1", TAG+="systemd", ENV{SYSTEMD_USER_WANTS}+="` + ServiceName + `"
+`
+
+// dbusActivation lets the session bus start the user service on demand when
+// a client calls DBusName.
+const dbusActivation = `[D-BUS Service]
+Name=` + DBusName + `
+Exec=/bin/false
+SystemdService=` + ServiceName + `
+`
+
+// The driver grabs the touchpad anyway; this keeps libinput from briefly
+// handling it before the grab and from listing it as a second pointer.
+const ignoreRule = `# Installed by touchpad-driver install --libinput-ignore
+SUBSYSTEM=="input", KERNEL=="event*", ENV{ID_INPUT_TOUCHPAD}=="1", ENV{LIBINPUT_IGNORE_DEVICE}="1"
+`
+
+// dbusPolicy lets anyone read the status over the system bus, but only root
+// and members of the control group, if there is one, call the methods that
+// change the driver. The driver checks the caller again itself.
+func dbusPolicy(group string) string {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE busconfig PUBLIC "-//freedesktop//DTD D-BUS Bus Configuration 1.0//EN"
+ "http://www.freedesktop.org/standards/dbus/1.0/busconfig.dtd">
+<busconfig>
+	<policy user="root">
+		<allow own="` + DBusName + `"/>
+		<allow send_destination="` + DBusName + `"/>
+	</policy>
+`)
+	if group != "" {
+		b.WriteString(`	<policy group="` + html.EscapeString(group) + `">
+		<allow send_destination="` + DBusName + `"/>
+	</policy>
+`)
+	}
+	b.WriteString(`	<policy context="default">
+		<allow send_destination="` + DBusName + `" send_interface="` + DBusInterface + `" send_member="Status"/>
+		<allow send_destination="` + DBusName + `" send_interface="org.freedesktop.DBus.Introspectable"/>
+	</policy>
+</busconfig>
+`)
+	return b.String()
+}
+
+// seatRules assigns the virtual devices of every configured seat other than
+// seat0 to that seat; seat0 is where unassigned devices end up anyway.
+func seatRules(cfg config.Config) (string, error) {
+	var b bytes.Buffer
+	done := make(map[string]bool)
+	for _, sc := range cfg.SeatList() {
+		base := outputBase(cfg.VirtualDevice.Name, sc)
+		if sc.Seat == device.DefaultSeat || done[base+"\x00"+sc.Seat] {
+			continue
+		}
+		done[base+"\x00"+sc.Seat] = true
+		if err := checkUdevValue(sc.Seat); err != nil {
+			return "", err
+		}
+		for _, name := range []string{virtualDeviceName(base, sc.Seat), keyboardDeviceName(base, sc.Seat)} {
+			if err := checkUdevValue(name); err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "SUBSYSTEM==\"input\", ATTRS{name}==\"%s\", ENV{ID_SEAT}=\"%s\"\n",
+				name, sc.Seat)
+		}
+	}
+	return b.String(), nil
+}
+
+// checkUdevValue rejects a value that would end the quoted string it goes in
+// in a udev rule, or the rule itself.
+func checkUdevValue(s string) error {
+	if strings.ContainsAny(s, "\"\\") || strings.ContainsFunc(s, unicode.IsControl) {
+		return fmt.Errorf("%q can't go in a udev rule; leave out quotes, backslashes and control characters", s)
+	}
+	return nil
+}
+
+type installPaths struct {
+	unit string
+	// activation is the session bus service file, set for --user only.
+	activation string
+	user       bool
+	// sudoUser is the invoking user when a --user install runs under sudo;
+	// the unit goes to their home and systemctl targets their manager.
+	sudoUser *user.User
+}
+
+func resolveInstallPaths(userMode bool) (installPaths, error) {
+	if !userMode {
+		return installPaths{unit: filepath.Join(SystemUnitDir, ServiceName)}, nil
+	}
+	p := installPaths{user: true}
+	home, err := os.UserHomeDir()
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		p.sudoUser, err = user.Lookup(name)
+		if err == nil {
+			home = p.sudoUser.HomeDir
+		}
+	}
+	if err != nil {
+		return installPaths{}, err
+	}
+	p.unit = filepath.Join(home, UserUnitSubpath, ServiceName)
+	p.activation = filepath.Join(home, DBusServiceSubpath, DBusName+".service")
+	return p, nil
+}
+
+// chownToUser hands files and directories written into a home directory back
+// to the sudo user.
+func (p installPaths) chownToUser(path string) {
+	if p.sudoUser == nil {
+		return
+	}
+	uid, _ := strconv.Atoi(p.sudoUser.Uid)
+	gid, _ := strconv.Atoi(p.sudoUser.Gid)
+	os.Chown(path, uid, gid)
+}
+
+// mkdirAll creates dir like os.MkdirAll and, when own is set, hands every
+// directory it had to create to the sudo user, so ~/.config and the like
+// don't end up owned by root.
+func (p installPaths) mkdirAll(dir string, own bool) error {
+	var created []string
+	for d := dir; d != filepath.Dir(d); d = filepath.Dir(d) {
+		if _, err := os.Lstat(d); err == nil {
+			break
+		}
+		created = append(created, d)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if own {
+		for _, d := range created {
+			p.chownToUser(d)
+		}
+	}
+	return nil
+}
+
+// runInstall implements the "install" subcommand. The udev and D-Bus files
+// always go to /etc, so it needs root even for --user.
+func runInstall(args []string) error {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	userMode := fs.Bool("user", false, "install a systemd user service instead of a system service")
+	ignore := fs.Bool("libinput-ignore", false, "also install a rule making libinput ignore the touchpad")
+	enable := fs.Bool("enable", false, "enable and start the service after installing")
+	fs.Parse(args)
+
+	paths, err := resolveInstallPaths(*userMode)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate executable: %w", err)
+	}
+
+	// A user service has no [Install] section: the udev rule starts it when
+	// the touchpad appears and the session bus when a client asks for it.
+	unitVars := struct{ Exec, WantedBy, BusName string }{exe, "multi-user.target", ""}
+	if *userMode {
+		unitVars.WantedBy, unitVars.BusName = "", DBusName
+	}
+	var unit bytes.Buffer
+	serviceTemplate.Execute(&unit, unitVars)
+
+	cfg, err := config.Load(config.DefaultPath)
+	if err != nil {
+		return err
+	}
+
+	seatRules, err := seatRules(cfg)
+	if err != nil {
+		return err
+	}
+	rules := udevRule + seatRules
+	if *userMode {
+		rules += userWantsRule
+	}
+	files := map[string][]byte{
+		paths.unit:     unit.Bytes(),
+		UdevRulePath:   []byte(rules),
+		DBusPolicyPath: []byte(dbusPolicy(cfg.ControlGroup)),
+	}
+	if paths.activation != "" {
+		files[paths.activation] = []byte(dbusActivation)
+	}
+	if *ignore {
+		files[IgnoreRulePath] = []byte(ignoreRule)
+	}
+	for path, data := range files {
+		own := path == paths.unit || path == paths.activation
+		if err := paths.mkdirAll(filepath.Dir(path), own); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	paths.chownToUser(paths.unit)
+	paths.chownToUser(paths.activation)
+	if err := linkCtl(exe); err != nil {
+		fmt.Printf("Warning: %s not linked: %v\n", CtlPath, err)
+	} else {
+		fmt.Printf("Linked %s\n", CtlPath)
+	}
+
+	if err := reloadDaemons(paths); err != nil {
+		return err
+	}
+	if *enable && *userMode {
+		return paths.systemctl("start", ServiceName)
+	}
+	if *enable {
+		return paths.systemctl("enable", "--now", ServiceName)
+	}
+	return nil
+}
+
+// runUninstall implements the "uninstall" subcommand.
+func runUninstall(args []string) error {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	userMode := fs.Bool("user", false, "remove the systemd user service instead of the system service")
+	fs.Parse(args)
+
+	paths, err := resolveInstallPaths(*userMode)
+	if err != nil {
+		return err
+	}
+
+	paths.systemctl("disable", "--now", ServiceName)
+	if fi, err := os.Lstat(CtlPath); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		os.Remove(CtlPath)
+		fmt.Printf("Removed %s\n", CtlPath)
+	}
+	for _, path := range []string{paths.unit, paths.activation, UdevRulePath, IgnoreRulePath, DBusPolicyPath} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err == nil {
+			fmt.Printf("Removed %s\n", path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return reloadDaemons(paths)
+}
+
+// linkCtl points CtlPath at exe, replacing an earlier link but nothing else.
+func linkCtl(exe string) error {
+	if fi, err := os.Lstat(CtlPath); err == nil {
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s exists and is not a link", CtlPath)
+		}
+		os.Remove(CtlPath)
+	}
+	return os.Symlink(exe, CtlPath)
+}
+
+func reloadDaemons(paths installPaths) error {
+	if err := paths.systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if err := run("udevadm", "control", "--reload"); err != nil {
+		return err
+	}
+	return run("udevadm", "trigger", "--subsystem-match=input", "--subsystem-match=misc")
+}
+
+func (p installPaths) systemctl(args ...string) error {
+	switch {
+	case p.sudoUser != nil:
+		args = append([]string{"--user", "--machine=" + p.sudoUser.Username + "@"}, args...)
+	case p.user:
+		args = append([]string{"--user"}, args...)
+	}
+	return run("systemctl", args...)
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
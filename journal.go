@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const (
+	JournalSocket = "/run/systemd/journal/socket"
+	// SyslogIdentifier is what journalctl -t filters on.
+	SyslogIdentifier = "touchpad-driver"
+)
+
+// stderrIsJournal reports whether stderr is the journal stream systemd set up
+// for the service, as opposed to, say, a terminal the service was run from.
+func stderrIsJournal() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stderr.Fd()), &st); err != nil {
+		return false
+	}
+	return stream == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
+
+// journalHandler sends records to journald over its native protocol, one
+// datagram per record, with each attribute as its own field: "device" becomes
+// DEVICE=, "profile" PROFILE= and so on, so journalctl can filter on them.
+type journalHandler struct {
+	conn  *net.UnixConn
+	level slog.Leveler
+	// attrs are fields added with WithAttrs, already encoded.
+	attrs  []byte
+	prefix string
+}
+
+func newJournalHandler(level slog.Leveler) (*journalHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JournalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journalHandler{conn: conn, level: level}, nil
+}
+
+func (h *journalHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+// journalPriority maps slog levels onto syslog priorities.
+func journalPriority(l slog.Level) int {
+	switch {
+	case l >= slog.LevelError:
+		return 3
+	case l >= slog.LevelWarn:
+		return 4
+	case l >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// journalField appends one field. Values with newlines use the binary form:
+// the name, a newline, the length as a 64-bit little-endian integer, the
+// value.
+func journalField(b []byte, name, value string) []byte {
+	if !strings.Contains(value, "\n") {
+		return fmt.Appendf(b, "%s=%s\n", name, value)
+	}
+	b = append(b, name...)
+	b = append(b, '\n')
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(value)))
+	b = append(b, value...)
+	return append(b, '\n')
+}
+
+// journalName turns an attribute key into a valid field name: upper case
+// letters, digits and underscores, not starting with an underscore, which
+// journald reserves for its own fields.
+func journalName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	return strings.TrimLeft(name, "_")
+}
+
+func (h *journalHandler) appendAttr(b []byte, prefix string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			b = h.appendAttr(b, prefix+a.Key+"_", ga)
+		}
+		return b
+	}
+	if name := journalName(prefix + a.Key); name != "" {
+		b = journalField(b, name, a.Value.String())
+	}
+	return b
+}
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	b := journalField(nil, "MESSAGE", r.Message)
+	b = journalField(b, "PRIORITY", fmt.Sprint(journalPriority(r.Level)))
+	b = journalField(b, "SYSLOG_IDENTIFIER", SyslogIdentifier)
+	b = append(b, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		b = h.appendAttr(b, h.prefix, a)
+		return true
+	})
+	_, err := h.conn.Write(b)
+	return err
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = bytes.Clone(h.attrs)
+	for _, a := range attrs {
+		h2.attrs = h.appendAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.prefix = h.prefix + name + "_"
+	return &h2
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// LauncherCommand is the hidden subcommand the launcher runs as.
+const LauncherCommand = "launcher"
+
+const (
+	// LauncherInterval is the least time between two commands; ones that
+	// come sooner are dropped, so a finger drumming on a hot zone or a
+	// stream of swipes can't fork a process each.
+	LauncherInterval = 250 * time.Millisecond
+	// LauncherMaxRunning is how many commands may run at once; more are
+	// refused until some exit.
+	LauncherMaxRunning = 8
+)
+
+// launcher runs configured shell commands on the driver's behalf. The
+// sandbox forbids exec in the driver itself, so a helper process is started
+// before it is applied and is handed the commands over a pipe.
+type launcher struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	last time.Time
+}
+
+// launchRequest is a command as the driver hands it to the helper, with the
+// seat whose touchpad ran it.
+type launchRequest struct {
+	Seat    string `json:"seat"`
+	Command string `json:"command"`
+}
+
+// startLauncher starts the helper, which runs with the driver's identity at
+// the time. Started as root, it runs each command as commandUser or, with
+// none, as the user of the session active on the command's seat. It exits
+// once the driver's end of the pipe closes, which happens however the driver
+// exits.
+func startLauncher(commandUser string) (*launcher, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, LauncherCommand, "-user", commandUser)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start launcher: %w", err)
+	}
+	go cmd.Wait()
+	return &launcher{enc: json.NewEncoder(w)}, nil
+}
+
+// Run has command run by the shell on behalf of seat. It does not wait for
+// it; a nil launcher only logs.
+func (l *launcher) Run(seat, command string) {
+	if l == nil {
+		slog.Warn("cannot run command: no launcher", "command", command)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.last) < LauncherInterval {
+		slog.Warn("command dropped: too soon after the last", "command", command)
+		return
+	}
+	l.last = now
+	if err := l.enc.Encode(launchRequest{Seat: seat, Command: command}); err != nil {
+		slog.Warn("cannot run command", "command", command, "err", err)
+	}
+}
+
+// runLauncher implements the launcher side: every request read from in is
+// run with sh -c. It returns once the driver closes the pipe.
+func runLauncher(args []string, in io.Reader) error {
+	fs := flag.NewFlagSet(LauncherCommand, flag.ExitOnError)
+	as := fs.String("user", "", "run commands as `user` rather than the seat's session user")
+	fs.Parse(args)
+
+	dec := json.NewDecoder(bufio.NewReader(in))
+	var running atomic.Int32
+	var bus *dbus.Conn
+	for {
+		var req launchRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		command := req.Command
+		if running.Load() >= LauncherMaxRunning {
+			slog.Warn("command refused: too many running", "command", command, "running", running.Load())
+			continue
+		}
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		// Commands outlive a restart of the driver rather than being
+		// killed with it.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		if os.Geteuid() == 0 {
+			if bus == nil && *as == "" {
+				var err error
+				if bus, err = dbus.ConnectSystemBus(); err != nil {
+					slog.Warn("command refused: no session user", "command", command, "err", err)
+					continue
+				}
+			}
+			u, err := launchUser(bus, *as, req.Seat)
+			if err != nil {
+				slog.Warn("command refused", "command", command, "seat", req.Seat, "err", err)
+				continue
+			}
+			if u != nil {
+				runAs(cmd, u)
+			}
+		}
+		if err := cmd.Start(); err != nil {
+			slog.Warn("command failed", "command", command, "err", err)
+			continue
+		}
+		running.Add(1)
+		go func() {
+			cmd.Wait()
+			running.Add(-1)
+		}()
+	}
+}
+
+// launchUser returns the user a command on seat runs as when the launcher is
+// root: the one named, or the user of the session active on seat. Root is
+// only ever used when named, for which nil is returned.
+func launchUser(bus *dbus.Conn, name, seat string) (*user.User, error) {
+	switch name {
+	case "root":
+		return nil, nil
+	case "":
+		uid, ok := sessionUser(bus, seat)
+		if !ok {
+			return nil, fmt.Errorf("nobody is logged in on %s", seat)
+		}
+		if uid == 0 {
+			return nil, errors.New(`the session user is root; set command_user to "root" to run commands as root`)
+		}
+		return user.LookupId(strconv.Itoa(uid))
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if u.Uid == "0" {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// runAs has cmd run as u, with u's groups and the environment of a login of
+// theirs, rather than with the launcher's.
+func runAs(cmd *exec.Cmd, u *user.User) {
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	var groups []uint32
+	ids, _ := u.GroupIds()
+	for _, id := range ids {
+		if g, err := strconv.Atoi(id); err == nil {
+			groups = append(groups, uint32(g))
+		}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}
+	runtimeDir := "/run/user/" + u.Uid
+	cmd.Env = []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+		"PATH=/usr/local/bin:/usr/bin:/bin",
+		"XDG_RUNTIME_DIR=" + runtimeDir,
+		"DBUS_SESSION_BUS_ADDRESS=unix:path=" + runtimeDir + "/bus",
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+
+	"touchpad/device"
+)
+
+// The libinput debug-events format (--debug-format libinput) prints what
+// libinput would make of the virtual devices, so tooling and habits built
+// around `libinput debug-events` carry over: each frame written to a device
+// becomes POINTER_MOTION, POINTER_SCROLL_WHEEL, POINTER_BUTTON or
+// KEYBOARD_KEY lines, and a three- or four-finger touch on the pad becomes a
+// GESTURE_SWIPE_BEGIN, UPDATE and END sequence. Raw touchpad events are not
+// shown, as libinput doesn't either.
+
+// libinputWheelAngle is the angle libinput reports for one wheel click.
+const libinputWheelAngle = 15.0
+
+// libinputFrame gathers the events written to a device up to a SYN_REPORT.
+type libinputFrame struct {
+	dx, dy int32
+	// wheel and hwheel are in 1/120 of a click.
+	wheel, hwheel int32
+	absX, absY    int32
+	abs           bool
+	keys          []keyChange
+}
+
+type keyChange struct {
+	code  uint16
+	value int32
+}
+
+// swipeState is the swipe in progress on one touchpad.
+type swipeState struct {
+	fingers int
+	// x and y are where the contacts' centre was at the last frame.
+	x, y float64
+	// done is set once the swipe has triggered its action.
+	done bool
+}
+
+func (d *eventDump) libinputLine(path, name string, at time.Time, detail string) {
+	d.line(" "+filepath.Base(path), name, at, detail)
+}
+
+// libinputDevice returns the trace hook for the virtual device named device
+// in the libinput format. Its events are reported against the touchpad at
+// path, as libinput has no better name for them.
+func (d *eventDump) libinputDevice(dev, path string) func(typ, code uint16, value int32) {
+	d.line("-"+filepath.Base(path), "DEVICE_ADDED", time.Now(), dev)
+	var f libinputFrame
+	held := make(map[uint16]bool)
+	return func(typ, code uint16, value int32) {
+		switch typ {
+		case device.EV_REL:
+			switch code {
+			case device.REL_X:
+				f.dx += value
+			case device.REL_Y:
+				f.dy += value
+			// libinput goes by the high-resolution wheels where a
+			// dev has them, as the virtual ones do, and ignores the
+			// legacy clicks that follow along.
+			case device.REL_WHEEL_HI_RES:
+				f.wheel += value
+			case device.REL_HWHEEL_HI_RES:
+				f.hwheel += value
+			}
+		case device.EV_ABS:
+			switch code {
+			case device.ABS_X:
+				f.absX, f.abs = value, true
+			case device.ABS_Y:
+				f.absY, f.abs = value, true
+			}
+		case device.EV_KEY:
+			f.keys = append(f.keys, keyChange{code, value})
+		case device.EV_SYN:
+			d.printFrame(path, &f, held)
+			f = libinputFrame{}
+		}
+	}
+}
+
+func (d *eventDump) printFrame(path string, f *libinputFrame, held map[uint16]bool) {
+	now := time.Now()
+	if f.abs {
+		d.libinputLine(path, "POINTER_MOTION_ABSOLUTE", now, fmt.Sprintf("%6.2f/%6.2f", float64(f.absX), float64(f.absY)))
+	}
+	if f.dx != 0 || f.dy != 0 {
+		// The driver has already applied acceleration, so the
+		// unaccelerated deltas are the same.
+		x, y := float64(f.dx), float64(f.dy)
+		d.libinputLine(path, "POINTER_MOTION", now, fmt.Sprintf("%6.2f/%6.2f (%+6.2f/%+6.2f)", x, y, x, y))
+	}
+	if f.wheel != 0 || f.hwheel != 0 {
+		// REL_WHEEL counts up and libinput down.
+		v, h := float64(f.wheel)/120, float64(f.hwheel)/120
+		d.libinputLine(path, "POINTER_SCROLL_WHEEL", now, fmt.Sprintf("vert %.2f/%.1f%s horiz %.2f/%.1f%s",
+			-v*libinputWheelAngle, -float64(f.wheel), axisMark(f.wheel),
+			h*libinputWheelAngle, float64(f.hwheel), axisMark(f.hwheel)))
+	}
+	for _, k := range f.keys {
+		if k.value > 1 || k.code >= evdev.BTN_DIGI && k.code < evdev.BTN_WHEEL {
+			// libinput drops the kernel's autorepeat, and touch
+			// contacts are not buttons.
+			continue
+		}
+		state := "released"
+		if k.value == 1 {
+			state = "pressed"
+			held[k.code] = true
+		} else {
+			delete(held, k.code)
+		}
+		if k.code >= evdev.BTN_MISC && k.code < evdev.KEY_OK {
+			d.libinputLine(path, "POINTER_BUTTON", now, fmt.Sprintf("%s (%d) %s, seat count: %d",
+				codeName(device.EV_KEY, k.code), k.code, state, len(held)))
+		} else {
+			d.libinputLine(path, "KEYBOARD_KEY", now, fmt.Sprintf("%s (%d) %s", codeName(device.EV_KEY, k.code), k.code, state))
+		}
+	}
+}
+
+// axisMark flags the scroll axes that moved, as libinput does.
+func axisMark(v int32) string {
+	if v != 0 {
+		return "*"
+	}
+	return ""
+}
+
+// swipe prints the swipe gesture lines for a touchpad frame.
+func (d *eventDump) swipe(path string, at time.Time, fingers int, mode string, slots *device.Slots) {
+	d.mu.Lock()
+	st := d.swipes[path]
+	if st == nil {
+		st = &swipeState{}
+		d.swipes[path] = st
+	}
+	d.mu.Unlock()
+
+	var cx, cy float64
+	n := 0
+	for _, s := range slots {
+		if s.Active {
+			cx, cy, n = cx+float64(s.X), cy+float64(s.Y), n+1
+		}
+	}
+	if n > 0 {
+		cx, cy = cx/float64(n), cy/float64(n)
+	}
+
+	gesturing := mode == "gesture" || mode == "gesture-done"
+	switch {
+	case gesturing && st.fingers == 0:
+		st.fingers, st.x, st.y = fingers, cx, cy
+		d.libinputLine(path, "GESTURE_SWIPE_BEGIN", at, fmt.Sprint(fingers))
+	case gesturing && fingers != st.fingers:
+		// The centre jumps when a finger lands or lifts.
+		st.fingers, st.x, st.y = fingers, cx, cy
+	case gesturing:
+		dx, dy := cx-st.x, cy-st.y
+		st.x, st.y = cx, cy
+		if dx != 0 || dy != 0 {
+			d.libinputLine(path, "GESTURE_SWIPE_UPDATE", at, fmt.Sprintf("%d %6.2f/%6.2f (%+6.2f/%+6.2f unaccelerated)",
+				st.fingers, dx, dy, dx, dy))
+		}
+	case st.fingers != 0:
+		detail := fmt.Sprint(st.fingers)
+		if !st.done {
+			detail += " cancelled"
+		}
+		d.libinputLine(path, "GESTURE_SWIPE_END", at, detail)
+		*st = swipeState{}
+	}
+	if mode == "gesture-done" && st.fingers != 0 {
+		st.done = true
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+
+	evdev "github.com/gvalkov/golang-evdev"
+)
+
+// watchLid calls onOpen whenever the lid switch reports the lid opening. It
+// reads the switch's input device without grabbing it, so logind still sees
+// it too.
+func watchLid(onOpen func()) error {
+	devices, _ := evdev.ListInputDevices()
+	var lid *evdev.InputDevice
+	for _, dev := range devices {
+		if lid == nil && slices.Contains(dev.CapabilitiesFlat[evdev.EV_SW], evdev.SW_LID) {
+			lid = dev
+			continue
+		}
+		dev.File.Close()
+	}
+	if lid == nil {
+		return errors.New("no lid switch")
+	}
+	go func() {
+		defer lid.File.Close()
+		for {
+			events, err := lid.Read()
+			if err != nil {
+				slog.Warn("lid switch lost", "err", err)
+				return
+			}
+			for _, ev := range events {
+				if ev.Type == evdev.EV_SW && ev.Code == evdev.SW_LID && ev.Value == 0 {
+					onOpen()
+				}
+			}
+		}
+	}()
+	return nil
+}
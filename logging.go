@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// logLevelVar is the minimum level logged. The control socket's "log-level"
+// command changes it while the driver runs.
+var logLevelVar = new(slog.LevelVar)
+
+// setupLogging installs the default logger for the driver. "auto" logs
+// straight to the journal when running as a service and as text to stderr
+// otherwise. With a file, the log is appended to it instead, as text unless
+// the format is json.
+func setupLogging(level, format, file string) error {
+	if err := logLevelVar.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log level %q: %w", level, err)
+	}
+	opts := &slog.HandlerOptions{Level: logLevelVar}
+
+	var w io.Writer = os.Stderr
+	if file != "" {
+		if format == "journal" {
+			return fmt.Errorf("the journal format can't be written to a file")
+		}
+		// Opened before the sandbox goes up and kept open, so the
+		// driver can go on writing to it afterwards.
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("log file: %w", err)
+		}
+		w = f
+	}
+	if format == "auto" {
+		format = "text"
+		if file == "" && stderrIsJournal() {
+			format = "journal"
+		}
+	}
+
+	var h slog.Handler
+	switch format {
+	case "journal":
+		jh, err := newJournalHandler(logLevelVar)
+		if err != nil {
+			return fmt.Errorf("connect to journal: %w", err)
+		}
+		h = jh
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// setLogLevel changes the minimum level logged from now on.
+func setLogLevel(level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log level %q: %w", level, err)
+	}
+	logLevelVar.Set(lvl)
+	slog.Info("log level changed", "level", lvl.String())
+	return nil
+}
@@ -1,470 +1,523 @@
 package main
 
 import (
-	"encoding/binary"
+	"flag"
 	"fmt"
-	"math"
+	"log/slog"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
-	"unsafe"
 
-	evdev "github.com/gvalkov/golang-evdev"
+	"touchpad/config"
+	"touchpad/engine"
 )
 
-const (
-	DeviceNameKeyword     = "GXTP"
-	DeviceNameMustContain = "Touchpad"
+const DeviceNameMustContain = "Touchpad"
 
-	MoveSensitivity  = 0.6
-	AccelFactor      = 1.5
-	ScrollDivider    = 40.0
-	NaturalScrolling = true
-
-	PalmZoneTopY          = 500
-	PalmPressureThreshold = 45
-
-	MinMovePressure      = 2
-	LowPressureThreshold = 15
-	SmallMoveCutoff      = 2.0
-
-	TapTimeout          = 200 * time.Millisecond
-	TapMovementLimit    = 40.0
-	PressThreshold      = 140
-	ReleaseThreshold    = 80
-	CooldownAfterScroll = 250 * time.Millisecond
-
-	GestureDistThreshold = 100.0
-
-	RightClickZoneX = 3000
-	BottomZoneY     = 1800
-)
-
-const (
-	EV_SYN = 0x00
-	EV_KEY = 0x01
-	EV_REL = 0x02
-
-	SYN_REPORT = 0x00
-
-	REL_X      = 0x00
-	REL_Y      = 0x01
-	REL_HWHEEL = 0x06
-	REL_WHEEL  = 0x08
-
-	BTN_LEFT   = 0x110
-	BTN_RIGHT  = 0x111
-	BTN_MIDDLE = 0x112
-
-	KEY_LEFTMETA  = 125
-	KEY_LEFTALT   = 56
-	KEY_LEFTSHIFT = 42
-	KEY_TAB       = 15
-	KEY_D         = 32
-
-	UINPUT_MAX_NAME_SIZE = 80
-
-	UI_SET_EVBIT  = 0x40045564
-	UI_SET_KEYBIT = 0x40045565
-	UI_SET_RELBIT = 0x40045566
-	UI_DEV_CREATE = 0x5501
-)
-
-type inputEvent struct {
-	Time  syscall.Timeval
-	Type  uint16
-	Code  uint16
-	Value int32
-}
+func main() {
+	if filepath.Base(os.Args[0]) == CtlName {
+		if err := runCtl(os.Args[1:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		var err error
+		switch os.Args[1] {
+		case "status":
+			err = runStatus(os.Args[2:])
+		case "install":
+			err = runInstall(os.Args[2:])
+		case "uninstall":
+			err = runUninstall(os.Args[2:])
+		case "monitor":
+			err = runMonitor(os.Args[2:])
+		case "heatmap":
+			err = runHeatmap(os.Args[2:])
+		case "inspect":
+			err = runInspect(os.Args[2:])
+		case "strokes":
+			err = runStrokes(os.Args[2:])
+		case "profile":
+			err = runProfile(os.Args[2:])
+		case "ctl":
+			err = runCtl(os.Args[2:])
+		case LauncherCommand:
+			err = runLauncher(os.Args[2:], os.Stdin)
+		default:
+			err = fmt.Errorf("unknown command %q", os.Args[1])
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-type uinputUserDev struct {
-	Name       [UINPUT_MAX_NAME_SIZE]byte
-	ID         inputID
-	EffectsMax uint32
-	Absmax     [64]int32
-	Absmin     [64]int32
-	Absfuzz    [64]int32
-	Absflat    [64]int32
-}
+	fs := flag.NewFlagSet("touchpad-driver", flag.ExitOnError)
+	logLevel := fs.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := fs.String("log-format", "auto", "log format: auto, journal, text or json")
+	logFile := fs.String("log-file", "", "append the log to `file` instead of stderr or the journal")
+	verbose := fs.Bool("verbose", false, "log at debug level, as --log-level debug")
+	var opts driverOptions
+	fs.BoolVar(&opts.debugEvents, "debug-events", false, "print every touchpad event, synthesized event and frame state")
+	fs.StringVar(&opts.debugFormat, "debug-format", DebugFormatDefault, "format of --debug-events: default, libinput to mimic libinput debug-events, or summary to describe it in words")
+	fs.StringVar(&opts.device, "device", "", "name keyword of the touchpad, instead of the first seat's \"device\" or detecting it")
+	fs.Float64Var(&opts.sensitivity, "sensitivity", 0, "pointer sensitivity, instead of \"sensitivity\"")
+	fs.BoolFunc("natural-scroll", "scroll naturally (--natural-scroll=false for traditional), instead of \"natural_scrolling\"", func(s string) error {
+		on, err := strconv.ParseBool(s)
+		opts.naturalScroll = &on
+		return err
+	})
+	fs.BoolVar(&opts.noGestures, "no-gestures", false, "turn three-finger gestures off")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "neither grab the touchpad nor create virtual devices; print what would be sent")
+	fs.StringVar(&opts.record, "record", "", "write the first touchpad's events to `file`, for --replay")
+	replay := fs.String("replay", "", "play the recording `file` through the configured settings and print what would be sent, instead of running")
+	fs.Parse(os.Args[1:])
+	if *verbose {
+		*logLevel = "debug"
+	}
+	if err := setupLogging(*logLevel, *logFormat, *logFile); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
 
-type inputID struct {
-	Bustype uint16
-	Vendor  uint16
-	Product uint16
-	Version uint16
+	if *replay != "" {
+		if err := runReplay(*replay, opts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := runDriver(opts); err != nil {
+		slog.Error("driver stopped", "err", err)
+		if hint := errorHint(err); hint != "" {
+			fmt.Fprintln(os.Stderr, hint)
+		}
+		os.Exit(1)
+	}
 }
 
-type Slot struct {
-	X, Y, P int32
+// reloadConfig re-reads the config file and swaps the new profile settings
+// into every seat. Everything else, such as the seats themselves, takes a
+// restart; a config that fails to load leaves the old settings running.
+func reloadConfig(path string, opts driverOptions, seats []*seatInstance) {
+	cfg, err := config.Load(path)
+	opts.apply(&cfg)
+	if err == nil {
+		err = config.CheckProfileCycle(cfg)
+	}
+	if err != nil {
+		slog.Warn("config not reloaded", "err", err)
+		return
+	}
+	for _, inst := range seats {
+		profiles, err := config.SeatProfiles(cfg, inst.cfg)
+		if err == nil {
+			err = inst.reload(profiles)
+		}
+		if err != nil {
+			slog.Warn("config not reloaded", "seat", inst.cfg.Seat, "touchpad", inst.cfg.Name, "err", err)
+			continue
+		}
+		slog.Info("config reloaded", "seat", inst.cfg.Seat, "touchpad", inst.cfg.Name)
+	}
 }
 
-type VirtualDevice struct {
-	fd *os.File
+// driverOptions are the command-line settings of the driver itself.
+type driverOptions struct {
+	debugEvents bool
+	debugFormat string
+	// dryRun leaves the touchpad to the desktop and sends nothing, only
+	// printing the output.
+	dryRun bool
+	// record is where to record the first touchpad's events.
+	record string
+
+	// The rest override the config file.
+	device        string
+	sensitivity   float64
+	naturalScroll *bool
+	noGestures    bool
 }
 
-func ioctl(fd uintptr, request uintptr, val uintptr) error {
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, val)
-	if errno != 0 {
-		return errno
+// apply overrides cfg's settings with those given on the command line. They
+// go into the top-level profile, which the built-in profiles start from.
+func (o driverOptions) apply(cfg *config.Config) {
+	if o.device != "" {
+		seats := cfg.SeatList()
+		seats[0].Device = o.device
+		cfg.Seats = seats
+	}
+	if o.sensitivity > 0 {
+		cfg.Profile.MoveSensitivity = o.sensitivity
+	}
+	if o.naturalScroll != nil {
+		cfg.Profile.NaturalScrolling = *o.naturalScroll
+	}
+	if o.noGestures {
+		cfg.Profile.Gestures = false
+	}
+	if o.dryRun {
+		// Grabbing is what dry runs leave out.
+		cfg.SessionReleaseGrab = false
 	}
-	return nil
-}
-
-func ioctlInt(fd uintptr, request uintptr, val int) error {
-	return ioctl(fd, request, uintptr(val))
 }
 
-func createVirtualDevice(name string) (*VirtualDevice, error) {
-	f, err := os.OpenFile("/dev/uinput", os.O_WRONLY|syscall.O_NONBLOCK, 0)
+// runDriver sets everything up and translates input until a seat fails for
+// good. Returning, rather than exiting, lets the deferred cleanup run.
+func runDriver(opts driverOptions) error {
+	cfgPath := config.Path()
+	cfg, err := config.Load(cfgPath)
 	if err != nil {
-		return nil, fmt.Errorf("open /dev/uinput: %w", err)
+		return err
 	}
+	opts.apply(&cfg)
 
-	fd := f.Fd()
+	if err := config.CheckProfileCycle(cfg); err != nil {
+		return err
+	}
+	commands, err := gestureCommands(cfg.GestureActions)
+	if err != nil {
+		return err
+	}
 
-	for _, ev := range []int{EV_KEY, EV_REL, EV_SYN} {
-		if err := ioctlInt(fd, UI_SET_EVBIT, ev); err != nil {
-			f.Close()
-			return nil, fmt.Errorf("set evbit %d: %w", ev, err)
+	// The profile each touchpad was last switched to is switched back to.
+	var state *driverState
+	if !opts.dryRun {
+		if state, err = openDriverState(statePath()); err != nil {
+			slog.Warn("the profile in use won't be remembered", "err", err)
+		} else {
+			defer state.Close()
 		}
 	}
 
-	for _, rel := range []int{REL_X, REL_Y, REL_WHEEL, REL_HWHEEL} {
-		if err := ioctlInt(fd, UI_SET_RELBIT, rel); err != nil {
-			f.Close()
-			return nil, fmt.Errorf("set relbit %d: %w", rel, err)
+	var seats []*seatInstance
+	names := make(map[string]bool)
+	// Gestures go to the compositor of the session on the touchpad's seat.
+	actions := make(map[string]gestureBackend)
+	for _, sc := range cfg.SeatList() {
+		if names[sc.Name] {
+			return fmt.Errorf("two touchpads are called %q; give the second one a \"name\"", sc.Name)
+		}
+		names[sc.Name] = true
+		profiles, err := config.SeatProfiles(cfg, sc)
+		if err != nil {
+			return err
+		}
+		var shared *seatInstance
+		for _, other := range seats {
+			if other.cfg.Seat == sc.Seat && other.cfg.Pointer == sc.Pointer {
+				shared = other
+				break
+			}
+		}
+		if _, ok := actions[sc.Seat]; !ok {
+			actions[sc.Seat] = newGestureBackend(cfg, sc.Seat)
 		}
+		inst, err := openSeat(sc, profiles, cfg.VirtualDevice, actions[sc.Seat], shared, opts.dryRun)
+		if err != nil && shared != nil {
+			// An external touchpad may just not be plugged in.
+			slog.Warn("additional touchpad unavailable", "seat", sc.Seat, "touchpad", sc.Name, "err", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		defer inst.Close()
+		inst.cycle, inst.engine.CycleGesture = cfg.ProfileCycle, cfg.ProfileCycleGesture
+		inst.resumeBlank = time.Duration(cfg.ResumeBlankMs) * time.Millisecond
+		if name := state.Profile(sc.Name); name != "" {
+			if err := inst.SetProfile(name); err != nil {
+				slog.Warn("remembered profile unavailable", "seat", sc.Seat, "touchpad", sc.Name, "err", err)
+			}
+		}
+		inst.state = state
+		seats = append(seats, inst)
 	}
-
-	for _, key := range []int{BTN_LEFT, BTN_RIGHT, BTN_MIDDLE, KEY_LEFTMETA, KEY_TAB, KEY_LEFTALT, KEY_LEFTSHIFT, KEY_D} {
-		if err := ioctlInt(fd, UI_SET_KEYBIT, key); err != nil {
-			f.Close()
-			return nil, fmt.Errorf("set keybit %d: %w", key, err)
+	if cfg.FeedbackBeep {
+		if b, err := openBeeper(); err != nil {
+			slog.Warn("audible feedback unavailable", "err", err)
+		} else {
+			for _, inst := range seats {
+				inst.beeper = b
+			}
+		}
+	}
+	if opts.debugEvents || opts.debugFormat != DebugFormatDefault || opts.dryRun {
+		dump, err := newEventDump(os.Stdout, opts.debugFormat)
+		if err != nil {
+			return err
+		}
+		for _, inst := range seats {
+			inst.engine.Trace = dump
+			for _, v := range inst.outputs() {
+				// Shared devices are named after the first touchpad.
+				if v.Trace == nil {
+					v.Trace = dump.virtual(v.Name(), inst.pad.Device().Fn)
+				}
+			}
 		}
 	}
 
-	var dev uinputUserDev
-	copy(dev.Name[:], name)
-	dev.ID.Bustype = 0x03
-	dev.ID.Vendor = 0x1234
-	dev.ID.Product = 0x5678
-	dev.ID.Version = 1
+	if opts.record != "" {
+		r, err := newEventRecorder(opts.record, seats[0].pad.Device())
+		if err != nil {
+			return fmt.Errorf("record: %w", err)
+		}
+		defer r.Close()
+		seats[0].record = r
+		slog.Info("recording", "seat", seats[0].cfg.Seat, "touchpad", seats[0].cfg.Name, "file", opts.record)
+	}
 
-	buf := (*[4096]byte)(unsafe.Pointer(&dev))[:unsafe.Sizeof(dev)]
-	if _, err := f.Write(buf); err != nil {
-		f.Close()
-		return nil, fmt.Errorf("write dev info: %w", err)
+	access := newControlAccess(cfg.ControlGroup)
+	ctl, err := serveControl(controlSocketPath(), seats, access)
+	if err != nil {
+		slog.Warn("control socket unavailable", "err", err)
+	} else {
+		defer ctl.Close()
 	}
 
-	if err := ioctl(fd, UI_DEV_CREATE, 0); err != nil {
-		f.Close()
-		return nil, fmt.Errorf("dev create: %w", err)
+	if cfg.HTTPListen != "" {
+		srv, err := serveHTTP(cfg.HTTPListen, seats, access)
+		if err != nil {
+			slog.Warn("HTTP API unavailable", "err", err)
+		} else {
+			defer srv.Close()
+		}
 	}
 
-	time.Sleep(200 * time.Millisecond)
-	return &VirtualDevice{fd: f}, nil
-}
+	bus, err := startDBus(seats, access)
+	if err != nil {
+		slog.Warn("D-Bus unavailable", "err", err)
+	} else {
+		defer bus.Close()
+	}
 
-func (v *VirtualDevice) writeEvent(typ uint16, code uint16, value int32) {
-	var tv syscall.Timeval
-	syscall.Gettimeofday(&tv)
-	binary.Write(v.fd, binary.LittleEndian, inputEvent{Time: tv, Type: typ, Code: code, Value: value})
-}
+	for _, inst := range seats {
+		inst := inst
+		owner := inst.cfg.SessionUser
+		if owner == "" {
+			owner = cfg.SessionOwner(os.Getuid())
+		}
+		err = watchSession(inst.cfg.Seat, owner, func(active bool) {
+			if active {
+				slog.Info("session active, resuming", "seat", inst.cfg.Seat)
+				if cfg.SessionReleaseGrab {
+					inst.pad.Grab()
+				}
+				inst.status.Resume("session")
+				return
+			}
+			slog.Info("session inactive, pausing", "seat", inst.cfg.Seat)
+			inst.status.Pause("session")
+			if cfg.SessionReleaseGrab {
+				inst.pad.Release()
+			}
+		})
+		if err != nil {
+			slog.Warn("session tracking unavailable", "err", err)
+		}
 
-func (v *VirtualDevice) syn() {
-	v.writeEvent(EV_SYN, SYN_REPORT, 0)
-}
+		err = watchLock(inst.cfg.Seat, func(locked bool) {
+			slog.Info("session lock changed", "seat", inst.cfg.Seat, "locked", locked)
+			inst.engine.Locked.Store(locked)
+		})
+		if err != nil {
+			slog.Warn("lock tracking unavailable", "err", err)
+		}
 
-func (v *VirtualDevice) Close() {
-	v.fd.Close()
-}
+		if err := watchIdle(inst.cfg.Seat, inst.idle.Set); err != nil {
+			slog.Warn("idle tracking unavailable", "err", err)
+		}
+	}
 
-func findDevice(keyword, mustContain string) (string, error) {
-	devices, _ := evdev.ListInputDevices()
-	var fallback string
-	for _, dev := range devices {
-		nameLower := strings.ToLower(dev.Name)
-		if strings.Contains(nameLower, strings.ToLower(keyword)) {
-			if strings.Contains(nameLower, strings.ToLower(mustContain)) {
-				return dev.Fn, nil
-			}
-			if fallback == "" {
-				fallback = dev.Fn
+	if cfg.Notifications {
+		if n, err := newNotifier(); err != nil {
+			slog.Warn("notifications unavailable", "err", err)
+		} else {
+			for _, inst := range seats {
+				n.watch(inst)
 			}
 		}
 	}
-	if fallback != "" {
-		return fallback, nil
-	}
-	return "", fmt.Errorf("device with keyword '%s' not found", keyword)
-}
 
-func main() {
-	devicePath, err := findDevice(DeviceNameKeyword, DeviceNameMustContain)
+	for _, inst := range seats {
+		if err := inst.saver.SetMode(cfg.BatterySaver); err != nil {
+			slog.Warn("invalid battery_saver", "err", err)
+		}
+	}
+	err = watchPower(func(onBattery bool) {
+		for _, inst := range seats {
+			inst.saver.SetOnBattery(onBattery)
+		}
+	})
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		slog.Warn("power supply tracking unavailable", "err", err)
 	}
-	fmt.Printf("Found touchpad at %s\n", devicePath)
 
-	dev, err := evdev.Open(devicePath)
-	if err != nil {
-		fmt.Printf("Error opening device: %v\n", err)
-		os.Exit(1)
+	for _, inst := range seats {
+		if !slices.ContainsFunc(inst.profiles, func(np config.NamedProfile) bool { return np.DisableWhileTyping }) {
+			continue
+		}
+		if err := inst.watchTyping(cfg.VirtualDevice.Name); err != nil {
+			slog.Warn("disable-while-typing unavailable", "seat", inst.cfg.Seat, "err", err)
+		}
+	}
+
+	rotating := false
+	for _, inst := range seats {
+		for _, np := range inst.profiles {
+			rotating = rotating || np.Profile.RotateWithScreen
+		}
+	}
+	if rotating {
+		err = watchOrientation(func(name string) {
+			slog.Info("screen orientation changed", "orientation", name)
+			for _, inst := range seats {
+				inst.orientation.Set(name)
+			}
+		})
+		if err != nil {
+			slog.Warn("screen orientation tracking unavailable; use the orientation command", "err", err)
+		}
 	}
-	dev.Grab()
-	defer dev.Release()
 
-	vmouse, err := createVirtualDevice("Goodix-Driver")
+	// SIGHUP reloads the profile settings in place.
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+	go func() {
+		for range hups {
+			sdNotify("RELOADING=1")
+			reloadConfig(cfgPath, opts, seats)
+			sdNotify("READY=1")
+		}
+	}()
+
+	// Stop requests are handled here rather than by unwinding the loops.
+	// Closing each seat lifts whatever it holds down, releases the grab and
+	// destroys its virtual devices.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		// A second Ctrl-C kills the driver outright should shutting down
+		// hang, say on a write to uinput; the kernel still drops the grab
+		// and the virtual devices along with the file descriptors.
+		signal.Stop(sigs)
+		slog.Info("shutting down", "signal", sig.String())
+		sdNotify("STOPPING=1")
+		for _, inst := range seats {
+			logStats(inst.stats())
+			inst.Close()
+		}
+		if ctl != nil {
+			ctl.Close()
+		}
+		os.Exit(0)
+	}()
+
+	err = watchSleep(func() {
+		slog.Info("preparing for sleep")
+		for _, inst := range seats {
+			inst.sleep()
+		}
+	}, func() {
+		for _, inst := range seats {
+			inst.resume()
+		}
+	})
 	if err != nil {
-		fmt.Printf("Error creating virtual device: %v\n", err)
-		os.Exit(1)
+		slog.Warn("sleep handling unavailable", "err", err)
 	}
-	defer vmouse.Close()
-
-	slots := make(map[int]*Slot)
-	prevSlots := make(map[int]*Slot)
-	activeSlot := 0
-
-	var (
-		currentFingerCount     int
-		maxFingersDuringTouch  int
-		maxPressureDuringTouch int32
-		touchStartTime         time.Time
-		touchStartX, touchStartY int32
-		isPhysicallyClicked    bool
-		activePhysicalButton   uint16
-		lastScrollTime         time.Time
-		scrollAccX, scrollAccY float64
-		isScrolling            bool
-		isPalmRejected         bool
-		gestureAccX, gestureAccY float64
-		gestureTriggered       bool
-	)
-
-	fmt.Println("Driver started.")
-
-	for {
-		events, err := dev.Read()
+	if cfg.ResumeBlankMs > 0 {
+		err = watchLid(func() {
+			slog.Debug("lid opened")
+			for _, inst := range seats {
+				inst.blank()
+			}
+		})
 		if err != nil {
-			break
+			slog.Debug("lid switch unavailable", "err", err)
 		}
+	}
 
-		for _, event := range events {
-			switch event.Type {
-			case evdev.EV_ABS:
-				if event.Code == evdev.ABS_MT_SLOT {
-					activeSlot = int(event.Value)
-				}
-				if _, ok := slots[activeSlot]; !ok {
-					slots[activeSlot] = &Slot{}
-				}
-				switch event.Code {
-				case evdev.ABS_MT_POSITION_X:
-					slots[activeSlot].X = event.Value
-				case evdev.ABS_MT_POSITION_Y:
-					slots[activeSlot].Y = event.Value
-				case evdev.ABS_MT_PRESSURE:
-					slots[activeSlot].P = event.Value
-					if event.Value > maxPressureDuringTouch {
-						maxPressureDuringTouch = event.Value
-					}
-				case evdev.ABS_MT_TRACKING_ID:
-					if event.Value == -1 {
-						delete(slots, activeSlot)
-					}
-				}
+	// Opened while still privileged and before the sandbox forbids
+	// creating files.
+	if crash, err := openCrashReporter(); err != nil {
+		slog.Warn("crash reports unavailable", "err", err)
+	} else {
+		for _, inst := range seats {
+			inst.crash = crash
+		}
+	}
 
-			case evdev.EV_KEY:
-				switch event.Code {
-				case evdev.BTN_TOOL_FINGER:
-					if event.Value == 1 { currentFingerCount = 1 } else { currentFingerCount = 0 }
-				case evdev.BTN_TOOL_DOUBLETAP:
-					if event.Value == 1 { currentFingerCount = 2 } else { currentFingerCount = 0 }
-				case evdev.BTN_TOOL_TRIPLETAP:
-					if event.Value == 1 { currentFingerCount = 3 } else { currentFingerCount = 0 }
-				}
-				if currentFingerCount > maxFingersDuringTouch {
-					maxFingersDuringTouch = currentFingerCount
-				}
+	// Started while still root, so that it can run commands as the session
+	// user, and before the sandbox forbids exec.
+	if slices.ContainsFunc(seats, func(inst *seatInstance) bool { return config.HasZoneCommand(inst.profiles) }) || len(commands) > 0 {
+		l, err := startLauncher(cfg.CommandUser)
+		if err != nil {
+			slog.Warn("hot zone and gesture commands unavailable", "err", err)
+		}
+		for _, inst := range seats {
+			inst.launcher = l
+			if len(commands) > 0 {
+				inst.actions = &execBackend{commands: commands, seat: inst.cfg.Seat, launcher: l, next: inst.actions}
+			}
+		}
+	}
 
-				if event.Code == evdev.BTN_TOUCH {
-					now := time.Now()
-					if event.Value == 1 {
-						touchStartTime = now
-						maxFingersDuringTouch = currentFingerCount
-						maxPressureDuringTouch = 0
-						isScrolling = false
-						gestureTriggered = false
-						gestureAccX, gestureAccY = 0, 0
-						if s, ok := slots[0]; ok {
-							touchStartX, touchStartY = s.X, s.Y
-							isPalmRejected = s.Y < PalmZoneTopY && s.P > PalmPressureThreshold
-						}
-						prevSlots = make(map[int]*Slot)
-					} else {
-						duration := now.Sub(touchStartTime)
-						timeSinceScroll := now.Sub(lastScrollTime)
-						wasPhysicalClick := maxPressureDuringTouch > PressThreshold
-
-						if !isPalmRejected && duration < TapTimeout && !wasPhysicalClick &&
-							timeSinceScroll > CooldownAfterScroll && !gestureTriggered {
-
-							lastX, lastY := touchStartX, touchStartY
-							if ps, ok := prevSlots[0]; ok {
-								lastX, lastY = ps.X, ps.Y
-							}
-							dist := math.Sqrt(math.Pow(float64(lastX-touchStartX), 2) + math.Pow(float64(lastY-touchStartY), 2))
-
-							if dist < TapMovementLimit {
-								clickBtn := uint16(BTN_LEFT)
-								if maxFingersDuringTouch == 2 {
-									clickBtn = BTN_RIGHT
-								} else if maxFingersDuringTouch == 3 {
-									clickBtn = BTN_MIDDLE
-								} else if lastX > RightClickZoneX && lastY > BottomZoneY {
-									clickBtn = BTN_RIGHT
-								}
-								vmouse.writeEvent(EV_KEY, clickBtn, 1)
-								vmouse.syn()
-								time.Sleep(15 * time.Millisecond)
-								vmouse.writeEvent(EV_KEY, clickBtn, 0)
-								vmouse.syn()
-							}
-						}
-					}
-				}
+	if err := dropPrivileges(cfg); err != nil {
+		return fmt.Errorf("dropping privileges: %w", err)
+	}
+	if cfg.Sandbox {
+		applySandbox(filepath.Dir(cfgPath))
+	}
 
-			case evdev.EV_SYN:
-				if event.Code == evdev.SYN_REPORT {
-					if isPalmRejected {
-						for k, v := range slots {
-							prevSlots[k] = &Slot{X: v.X, Y: v.Y, P: v.P}
-						}
-						continue
-					}
-
-					pressure := int32(0)
-					if s, ok := slots[0]; ok {
-						pressure = s.P
-					}
-
-					if !isPhysicallyClicked && pressure > PressThreshold {
-						isPhysicallyClicked = true
-						activePhysicalButton = BTN_LEFT
-						if s, ok := slots[0]; ok && s.X > RightClickZoneX && s.Y > BottomZoneY {
-							activePhysicalButton = BTN_RIGHT
-						}
-						vmouse.writeEvent(EV_KEY, activePhysicalButton, 1)
-						vmouse.syn()
-					} else if isPhysicallyClicked && pressure < ReleaseThreshold {
-						isPhysicallyClicked = false
-						vmouse.writeEvent(EV_KEY, activePhysicalButton, 0)
-						vmouse.syn()
-						activePhysicalButton = 0
-					}
-
-					s0, hasS0 := slots[0]
-					p0, hasP0 := prevSlots[0]
-
-					if hasS0 && hasP0 {
-						dx := float64(s0.X - p0.X)
-						dy := float64(s0.Y - p0.Y)
-
-						if currentFingerCount == 3 && !gestureTriggered {
-							gestureAccX += dx
-							gestureAccY += dy
-
-							if gestureAccX > GestureDistThreshold {
-								vmouse.writeEvent(EV_KEY, KEY_LEFTALT, 1)
-								vmouse.writeEvent(EV_KEY, KEY_LEFTSHIFT, 1)
-								vmouse.writeEvent(EV_KEY, KEY_TAB, 1)
-								vmouse.syn()
-								time.Sleep(50 * time.Millisecond)
-								vmouse.writeEvent(EV_KEY, KEY_TAB, 0)
-								vmouse.writeEvent(EV_KEY, KEY_LEFTSHIFT, 0)
-								vmouse.writeEvent(EV_KEY, KEY_LEFTALT, 0)
-								vmouse.syn()
-								gestureTriggered = true
-							} else if gestureAccX < -GestureDistThreshold {
-								vmouse.writeEvent(EV_KEY, KEY_LEFTALT, 1)
-								vmouse.writeEvent(EV_KEY, KEY_TAB, 1)
-								vmouse.syn()
-								time.Sleep(50 * time.Millisecond)
-								vmouse.writeEvent(EV_KEY, KEY_TAB, 0)
-								vmouse.writeEvent(EV_KEY, KEY_LEFTALT, 0)
-								vmouse.syn()
-								gestureTriggered = true
-							} else if gestureAccY < -GestureDistThreshold {
-								vmouse.writeEvent(EV_KEY, KEY_LEFTMETA, 1)
-								vmouse.syn()
-								time.Sleep(50 * time.Millisecond)
-								vmouse.writeEvent(EV_KEY, KEY_LEFTMETA, 0)
-								vmouse.syn()
-								gestureTriggered = true
-							} else if gestureAccY > GestureDistThreshold {
-								vmouse.writeEvent(EV_KEY, KEY_LEFTMETA, 1)
-								vmouse.writeEvent(EV_KEY, KEY_D, 1)
-								vmouse.syn()
-								time.Sleep(50 * time.Millisecond)
-								vmouse.writeEvent(EV_KEY, KEY_D, 0)
-								vmouse.writeEvent(EV_KEY, KEY_LEFTMETA, 0)
-								vmouse.syn()
-								gestureTriggered = true
-							}
-
-						} else if currentFingerCount == 2 {
-							isScrolling = true
-							scrollAccY += dy
-							scrollAccX += dx
-							direction := 1
-							if !NaturalScrolling {
-								direction = -1
-							}
-
-							if math.Abs(scrollAccY) > ScrollDivider {
-								ticks := int(scrollAccY / ScrollDivider)
-								vmouse.writeEvent(EV_REL, REL_WHEEL, int32(ticks*direction))
-								scrollAccY -= float64(ticks) * ScrollDivider
-								lastScrollTime = time.Now()
-							}
-							if math.Abs(scrollAccX) > ScrollDivider {
-								ticks := int(scrollAccX / ScrollDivider)
-								vmouse.writeEvent(EV_REL, REL_HWHEEL, int32(ticks*-direction))
-								scrollAccX -= float64(ticks) * ScrollDivider
-								lastScrollTime = time.Now()
-							}
-
-						} else if currentFingerCount == 1 && !isScrolling && !gestureTriggered {
-							currP := s0.P
-							moveDist := math.Abs(dx) + math.Abs(dy)
-
-							if currP >= MinMovePressure &&
-								!(currP < LowPressureThreshold && moveDist < SmallMoveCutoff) &&
-								math.Abs(dx) < 400 && math.Abs(dy) < 400 {
-								accel := 1.0
-								if moveDist > 15 {
-									accel = AccelFactor
-								}
-								mx := int32(dx * MoveSensitivity * accel)
-								my := int32(dy * MoveSensitivity * accel)
-								if mx != 0 || my != 0 {
-									vmouse.writeEvent(EV_REL, REL_X, mx)
-									vmouse.writeEvent(EV_REL, REL_Y, my)
-								}
-							}
-						}
-					}
-
-					vmouse.syn()
-
-					prevSlots = make(map[int]*Slot)
-					for k, v := range slots {
-						prevSlots[k] = &Slot{X: v.X, Y: v.Y, P: v.P}
-					}
+	slog.Info("driver started", "seats", len(seats))
+	sdNotify(fmt.Sprintf("READY=1\nSTATUS=Translating %d touchpad(s)", len(seats)))
+
+	heartbeats := make([]*engine.Heartbeat, len(seats))
+	for i, inst := range seats {
+		heartbeats[i] = &inst.engine.Heartbeat
+	}
+	var dormant *dormancy
+	if cfg.IdleSuspendMs > 0 {
+		dormant = newDormancy(time.Duration(cfg.IdleSuspendMs) * time.Millisecond)
+		for _, inst := range seats {
+			inst.dormant = dormant
+			// An idle seat is one nobody is about to touch.
+			inst.idle.OnChange(func(idle bool) {
+				if idle {
+					dormant.Idle(seats)
+				} else {
+					dormant.Wake()
 				}
-			}
+			})
 		}
+		dormant.Idle(seats)
+		go dormant.run(seats)
+	}
+	go runWatchdog(dormant, heartbeats...)
+	if cfg.MaxButtonHoldMs > 0 {
+		go runButtonFailsafe(seats, time.Duration(cfg.MaxButtonHoldMs)*time.Millisecond, dormant)
 	}
-}
\ No newline at end of file
+
+	// Each seat runs independently under its own supervisor; the first one
+	// to give up ends the process so the service manager can restart it.
+	failed := make(chan error, len(seats))
+	for _, inst := range seats {
+		go func(inst *seatInstance) { failed <- inst.supervise() }(inst)
+	}
+	err = <-failed
+	for _, inst := range seats {
+		logStats(inst.stats())
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"touchpad/config"
+	"touchpad/device"
+	"touchpad/engine"
+	"touchpad/uinput"
+)
+
+// SessionStats summarizes a seat's use since the driver started.
+type SessionStats struct {
+	Seat            string            `json:"seat"`
+	Since           time.Time         `json:"since"`
+	PointerDistance float64           `json:"pointer_distance_px"`
+	Clicks          map[string]uint64 `json:"clicks"`
+	Taps            uint64            `json:"taps"`
+	ScrollTicks     uint64            `json:"scroll_ticks"`
+	Gestures        map[string]uint64 `json:"gestures"`
+	PalmRejections  uint64            `json:"palm_rejections"`
+}
+
+// sessionStats summarizes m for seat, counted since the given time.
+func sessionStats(m *engine.Metrics, seat string, since time.Time) SessionStats {
+	s := SessionStats{
+		Seat:            seat,
+		Since:           since,
+		PointerDistance: math.Round(m.Distance()),
+		Clicks:          make(map[string]uint64),
+		Taps:            m.Taps.Load(),
+		ScrollTicks:     m.ScrollTicks.Load(),
+		Gestures:        m.Gestures(),
+		PalmRejections:  m.Palms.Load(),
+	}
+	for i := range m.Clicks {
+		s.Clicks[config.ButtonName(uint16(device.BTN_LEFT+i))] = m.Clicks[i].Load()
+	}
+	return s
+}
+
+// writeMetrics renders every seat's metrics in the Prometheus text format.
+func writeMetrics(w io.Writer, seats []*seatInstance) {
+	counter := func(name, help string, get func(*engine.Metrics) uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, inst := range seats {
+			fmt.Fprintf(w, "%s{seat=%q} %d\n", name, inst.cfg.Name, get(&inst.engine.Metrics))
+		}
+	}
+	counter("touchpad_events_total", "Raw touchpad events processed.", func(m *engine.Metrics) uint64 { return m.Events.Load() })
+	counter("touchpad_frames_total", "Touchpad frames (SYN_REPORT) processed.", func(m *engine.Metrics) uint64 { return m.Frames.Load() })
+	counter("touchpad_taps_total", "Taps turned into clicks.", func(m *engine.Metrics) uint64 { return m.Taps.Load() })
+	counter("touchpad_palms_rejected_total", "Touches rejected as palms.", func(m *engine.Metrics) uint64 { return m.Palms.Load() })
+	counter("touchpad_scroll_ticks_total", "Wheel ticks sent.", func(m *engine.Metrics) uint64 { return m.ScrollTicks.Load() })
+	counter("touchpad_dropped_total", "Kernel buffer overruns (SYN_DROPPED).", func(m *engine.Metrics) uint64 { return m.Dropped.Load() })
+	counter("touchpad_stuck_released_total", "Buttons and keys released by the stuck-input failsafe.", func(m *engine.Metrics) uint64 { return m.Stuck.Load() })
+
+	devices := func(name, help string, get func(*uinput.Device) uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, inst := range seats {
+			fmt.Fprintf(w, "%s{seat=%q,device=\"mouse\"} %d\n", name, inst.cfg.Name, get(inst.vmouse))
+			fmt.Fprintf(w, "%s{seat=%q,device=\"keyboard\"} %d\n", name, inst.cfg.Name, get(inst.vkbd))
+		}
+	}
+	devices("touchpad_uinput_write_errors_total", "Writes to a virtual device that failed after retrying.", (*uinput.Device).WriteErrors)
+	devices("touchpad_uinput_dropped_events_total", "Events lost to failed writes.", (*uinput.Device).Dropped)
+
+	fmt.Fprintf(w, "# HELP touchpad_clicks_total Button presses sent, taps included.\n# TYPE touchpad_clicks_total counter\n")
+	for _, inst := range seats {
+		for i := range inst.engine.Metrics.Clicks {
+			fmt.Fprintf(w, "touchpad_clicks_total{seat=%q,button=%q} %d\n", inst.cfg.Name, config.ButtonName(uint16(device.BTN_LEFT+i)), inst.engine.Metrics.Clicks[i].Load())
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP touchpad_gestures_total Gestures recognized, by name.\n# TYPE touchpad_gestures_total counter\n")
+	for _, inst := range seats {
+		gestures := inst.engine.Metrics.Gestures()
+		for _, name := range slices.Sorted(maps.Keys(gestures)) {
+			fmt.Fprintf(w, "touchpad_gestures_total{seat=%q,gesture=%q} %d\n", inst.cfg.Name, name, gestures[name])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP touchpad_loop_seconds Time to handle one batch of touchpad events.\n# TYPE touchpad_loop_seconds histogram\n")
+	for _, inst := range seats {
+		counts, sum := inst.engine.Metrics.LoopHistogram()
+		var cum uint64
+		for i, n := range counts {
+			cum += n
+			le := "+Inf"
+			if i < len(engine.LoopBuckets) {
+				le = fmt.Sprint(engine.LoopBuckets[i].Seconds())
+			}
+			fmt.Fprintf(w, "touchpad_loop_seconds_bucket{seat=%q,le=%q} %d\n", inst.cfg.Name, le, cum)
+		}
+		fmt.Fprintf(w, "touchpad_loop_seconds_sum{seat=%q} %g\n", inst.cfg.Name, sum.Seconds())
+		fmt.Fprintf(w, "touchpad_loop_seconds_count{seat=%q} %d\n", inst.cfg.Name, cum)
+	}
+
+	fmt.Fprintf(w, "# HELP touchpad_output_latency_seconds Kernel timestamp to uinput write, over recent reports.\n# TYPE touchpad_output_latency_seconds summary\n")
+	for _, inst := range seats {
+		l := inst.pointer().Latency()
+		for _, q := range []struct {
+			q    string
+			usec int64
+		}{{"0.5", l.P50Usec}, {"0.9", l.P90Usec}, {"0.99", l.P99Usec}} {
+			fmt.Fprintf(w, "touchpad_output_latency_seconds{seat=%q,quantile=%q} %g\n", inst.cfg.Name, q.q, float64(q.usec)/1e6)
+		}
+		fmt.Fprintf(w, "touchpad_output_latency_seconds_count{seat=%q} %d\n", inst.cfg.Name, l.Samples)
+	}
+}
+
+// logStats prints the session summary, as the driver does on exit.
+func logStats(s SessionStats) {
+	slog.Info("session summary", "seat", s.Seat,
+		"duration", time.Since(s.Since).Round(time.Second),
+		"pointer_distance_px", s.PointerDistance,
+		"clicks", s.Clicks, "taps", s.Taps, "scroll_ticks", s.ScrollTicks,
+		"gestures", s.Gestures, "palm_rejections", s.PalmRejections)
+}
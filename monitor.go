@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"touchpad/device"
+	"touchpad/engine"
+)
+
+const (
+	monitorCols = 64
+	monitorRows = 20
+	// monitorRedraw caps the redraw rate; frames arrive at 100+ Hz.
+	monitorRedraw = 33 * time.Millisecond
+)
+
+// runMonitor implements the "monitor" subcommand: a live view of the
+// contacts on the touchpad and of what the driver makes of them, drawn with
+// plain ANSI escapes from the processed-event stream.
+func runMonitor(args []string) error {
+	fs := flag.NewFlagSet("monitor", flag.ExitOnError)
+	socket := fs.String("socket", "", "control socket path (default: the user service's, else the system one)")
+	seat := fs.String("seat", "", "seat to monitor (default: the first configured)")
+	fs.Parse(args)
+
+	conn, err := dialControl(*socket)
+	if err != nil {
+		return fmt.Errorf("connect to driver: %w", err)
+	}
+	defer conn.Close()
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+
+	if err := enc.Encode(controlRequest{Cmd: "device", Seat: *seat}); err != nil {
+		return err
+	}
+	var resp controlResponse
+	if err := dec.Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.OK || resp.Device == nil {
+		return fmt.Errorf("driver: %s", resp.Error)
+	}
+	info := *resp.Device
+	if err := enc.Encode(controlRequest{Cmd: "events", Seat: *seat}); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	// Hide the cursor while drawing and bring it back however we exit.
+	out.WriteString("\x1b[?25l\x1b[2J")
+	restore := func() {
+		out.WriteString("\x1b[?25h\n")
+		out.Flush()
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		conn.Close()
+	}()
+	defer restore()
+
+	var (
+		frame    engine.StreamEvent
+		last     string
+		lastDraw time.Time
+	)
+	for {
+		var ev engine.StreamEvent
+		if err := dec.Decode(&ev); err != nil {
+			return nil
+		}
+		if ev.Type != "frame" {
+			last = ev.Type
+			if ev.Name != "" {
+				last += " " + ev.Name
+			}
+			continue
+		}
+		frame = ev
+		if time.Since(lastDraw) < monitorRedraw {
+			continue
+		}
+		lastDraw = time.Now()
+		drawMonitor(out, info, frame, last)
+		out.Flush()
+	}
+}
+
+func drawMonitor(out *bufio.Writer, info device.Info, frame engine.StreamEvent, last string) {
+	var grid [monitorRows][monitorCols]byte
+	for r := range grid {
+		for c := range grid[r] {
+			grid[r][c] = ' '
+		}
+	}
+	for _, ct := range frame.Contacts {
+		c := int(int64(ct.X) * (monitorCols - 1) / int64(max(info.MaxX, 1)))
+		r := int(int64(ct.Y) * (monitorRows - 1) / int64(max(info.MaxY, 1)))
+		if r >= 0 && r < monitorRows && c >= 0 && c < monitorCols {
+			grid[r][c] = byte('0' + ct.Slot%10)
+		}
+	}
+
+	out.WriteString("\x1b[H")
+	fmt.Fprintf(out, "%s (%s)\x1b[K\n", info.Name, info.Path)
+	fmt.Fprintf(out, "fingers %d  mode %-12s  last %s\x1b[K\n", frame.Fingers, frame.Mode, last)
+	out.WriteString("+" + strings.Repeat("-", monitorCols) + "+\n")
+	for _, row := range grid {
+		out.WriteString("|" + string(row[:]) + "|\n")
+	}
+	out.WriteString("+" + strings.Repeat("-", monitorCols) + "+\n")
+	for _, ct := range frame.Contacts {
+		bar := 0
+		if info.MaxPressure > 0 {
+			bar = int(ct.P * 30 / info.MaxPressure)
+		}
+		fmt.Fprintf(out, "slot %d  x %5d  y %5d  p %4d %s\x1b[K\n", ct.Slot, ct.X, ct.Y, ct.P, strings.Repeat("#", max(bar, 0)))
+	}
+	// Clear what a frame with more contacts left below.
+	out.WriteString("\x1b[J")
+}
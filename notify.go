@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+
+	"touchpad/device"
+)
+
+const (
+	notifyName = "org.freedesktop.Notifications"
+	notifyPath = "/org/freedesktop/Notifications"
+
+	// NotifyTimeoutMs is how long notifications stay up.
+	NotifyTimeoutMs = 4000
+)
+
+// notifier posts desktop notifications on the session bus. Each seat's
+// notifications replace the previous one, so toggling repeatedly doesn't
+// stack them up.
+type notifier struct {
+	conn *dbus.Conn
+	mu   sync.Mutex
+	// replaces maps a seat to the id of its last notification.
+	replaces map[string]uint32
+}
+
+func newNotifier() (*notifier, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("connect session bus: %w", err)
+	}
+	return &notifier{conn: conn, replaces: make(map[string]uint32)}, nil
+}
+
+// watch registers with the seat's status tracker and posts a notification
+// for every state change a user would otherwise have to guess at.
+func (n *notifier) watch(inst *seatInstance) {
+	seat := inst.cfg.Name
+	inst.status.OnEvent(func(ev Event) {
+		var summary, body string
+		switch ev.Kind {
+		case EventEnabled:
+			summary = "Touchpad disabled"
+			if ev.Enabled {
+				summary = "Touchpad enabled"
+			}
+		case EventProfile:
+			summary, body = "Touchpad profile changed", ev.Name
+		case EventDevice:
+			summary, body = "Touchpad lost", "Trying to reconnect…"
+			if ev.Connected {
+				summary, body = "Touchpad reconnected", ""
+			}
+		default:
+			return
+		}
+		// Listeners run on the event loop; don't wait on the bus there.
+		go n.send(seat, summary, body)
+	})
+}
+
+func (n *notifier) send(seat, summary, body string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if seat != device.DefaultSeat {
+		summary += " (" + seat + ")"
+	}
+	call := n.conn.Object(notifyName, notifyPath).Call(notifyName+".Notify", 0,
+		"touchpad-driver", n.replaces[seat], "input-touchpad", summary, body,
+		[]string{}, map[string]dbus.Variant{}, int32(NotifyTimeoutMs))
+	var id uint32
+	if err := call.Store(&id); err != nil {
+		slog.Warn("notification failed", "err", err)
+		return
+	}
+	n.replaces[seat] = id
+}
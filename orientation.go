@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	sensorProxyName = "net.hadess.SensorProxy"
+	sensorProxyPath = "/net/hadess/SensorProxy"
+)
+
+// Screen orientations, as iio-sensor-proxy names them: which edge of the
+// screen is at the top.
+const (
+	OrientationNormal   = "normal"
+	OrientationBottomUp = "bottom-up"
+	OrientationLeftUp   = "left-up"
+	OrientationRightUp  = "right-up"
+)
+
+// orientation is the current screen orientation of a seat. The zero value is
+// OrientationNormal.
+type orientation struct {
+	v atomic.Value
+}
+
+func (o *orientation) Get() string {
+	if s, ok := o.v.Load().(string); ok {
+		return s
+	}
+	return OrientationNormal
+}
+
+func (o *orientation) Set(name string) error {
+	switch name {
+	case OrientationNormal, OrientationBottomUp, OrientationLeftUp, OrientationRightUp:
+	default:
+		return fmt.Errorf("unknown orientation %q", name)
+	}
+	o.v.Store(name)
+	return nil
+}
+
+// Rotate turns motion on the pad into motion on the screen, so that moving
+// the finger up moves the pointer up however the machine is turned. The pad
+// turns along with the screen on a convertible.
+func (o *orientation) Rotate(dx, dy float64) (float64, float64) {
+	switch o.Get() {
+	case OrientationLeftUp:
+		return -dy, dx
+	case OrientationRightUp:
+		return dy, -dx
+	case OrientationBottomUp:
+		return -dx, -dy
+	}
+	return dx, dy
+}
+
+// watchOrientation follows the accelerometer through iio-sensor-proxy and
+// calls onChange with the orientation, once up front and then on every
+// change. The claim on the sensor lasts as long as the connection.
+func watchOrientation(onChange func(name string)) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("connect system bus: %w", err)
+	}
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(sensorProxyPath),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+	)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("subscribe to %s: %w", sensorProxyPath, err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	obj := conn.Object(sensorProxyName, sensorProxyPath)
+	if err := obj.Call(sensorProxyName+".ClaimAccelerometer", 0).Err; err != nil {
+		conn.Close()
+		return fmt.Errorf("claim accelerometer: %w", err)
+	}
+	current := func() string {
+		v, err := obj.GetProperty(sensorProxyName + ".AccelerometerOrientation")
+		if err != nil {
+			return ""
+		}
+		s, _ := v.Value().(string)
+		return s
+	}
+	if has, err := obj.GetProperty(sensorProxyName + ".HasAccelerometer"); err != nil {
+		conn.Close()
+		return fmt.Errorf("query iio-sensor-proxy: %w", err)
+	} else if ok, _ := has.Value().(bool); !ok {
+		conn.Close()
+		return fmt.Errorf("no accelerometer")
+	}
+
+	last := current()
+	if last != "" && last != "undefined" {
+		onChange(last)
+	}
+	go func() {
+		for range signals {
+			// The proxy signals its other sensors' changes too.
+			if o := current(); o != last && o != "" && o != "undefined" {
+				last = o
+				onChange(o)
+			}
+		}
+	}()
+	return nil
+}
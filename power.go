@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	upowerName = "org.freedesktop.UPower"
+	upowerPath = "/org/freedesktop/UPower"
+
+	// SaverTimerSlack is what deferred actions are rounded up to in
+	// battery-saver mode, so nearby ones share a wakeup.
+	SaverTimerSlack = 10 * time.Millisecond
+)
+
+// batterySaver decides whether a seat runs in battery-saver mode: always,
+// never, or in "auto" mode whenever the machine is on battery.
+type batterySaver struct {
+	mu        sync.Mutex
+	mode      string
+	onBattery bool
+	active    atomic.Bool
+	onChange  func(active bool)
+}
+
+func (b *batterySaver) Active() bool {
+	return b.active.Load()
+}
+
+// SetMode switches between "auto", "on" and "off".
+func (b *batterySaver) SetMode(mode string) error {
+	switch mode {
+	case "auto", "on", "off":
+	default:
+		return fmt.Errorf("unknown battery saver mode %q", mode)
+	}
+	b.mu.Lock()
+	b.mode = mode
+	b.mu.Unlock()
+	b.apply()
+	return nil
+}
+
+func (b *batterySaver) SetOnBattery(on bool) {
+	b.mu.Lock()
+	b.onBattery = on
+	b.mu.Unlock()
+	b.apply()
+}
+
+func (b *batterySaver) apply() {
+	b.mu.Lock()
+	active := b.mode == "on" || b.mode == "auto" && b.onBattery
+	b.mu.Unlock()
+	if b.active.Swap(active) != active && b.onChange != nil {
+		b.onChange(active)
+	}
+}
+
+// watchPower follows UPower's OnBattery and calls onChange with it, once up
+// front and then on every change.
+func watchPower(onChange func(onBattery bool)) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("connect system bus: %w", err)
+	}
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(upowerPath),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+	)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("subscribe to %s: %w", upowerPath, err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	obj := conn.Object(upowerName, upowerPath)
+	onBattery := func() bool {
+		v, err := obj.GetProperty(upowerName + ".OnBattery")
+		if err != nil {
+			return false
+		}
+		on, _ := v.Value().(bool)
+		return on
+	}
+	if _, err := obj.GetProperty(upowerName + ".OnBattery"); err != nil {
+		conn.Close()
+		return fmt.Errorf("query UPower: %w", err)
+	}
+	onChange(onBattery())
+
+	go func() {
+		for range signals {
+			onChange(onBattery())
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"touchpad/config"
+)
+
+// dropPrivileges switches to the configured user, group and supplementary
+// groups. Already-open fds (evdev, uinput, control socket, D-Bus) keep working;
+// only new opens are subject to the reduced identity.
+func dropPrivileges(cfg config.Config) error {
+	if os.Geteuid() != 0 || cfg.RunAsUser == "" {
+		return nil
+	}
+
+	u, err := user.Lookup(cfg.RunAsUser)
+	if err != nil {
+		return err
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	if cfg.RunAsGroup != "" {
+		g, err := user.LookupGroup(cfg.RunAsGroup)
+		if err != nil {
+			return err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	var groups []int
+	for _, name := range cfg.ExtraGroups {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			slog.Warn("skipping group", "group", name, "err", err)
+			continue
+		}
+		id, _ := strconv.Atoi(g.Gid)
+		groups = append(groups, id)
+	}
+
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d: %w", uid, err)
+	}
+	if syscall.Setuid(0) == nil {
+		return fmt.Errorf("regained root after dropping to %s", cfg.RunAsUser)
+	}
+	return nil
+}
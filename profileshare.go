@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+
+	"touchpad/config"
+	"touchpad/device"
+)
+
+// ProfileBundle is an exported profile: the settings, and the touchpad they
+// were tuned on, so they can be adapted to another one on import.
+type ProfileBundle struct {
+	Device  device.Info    `json:"device"`
+	Profile config.Profile `json:"profile"`
+}
+
+// runProfile implements the "profile" subcommand: "export" writes the
+// configured settings and a fingerprint of the touchpad as a bundle, and
+// "import" merges a bundle into the config file, scaling it to this pad.
+func runProfile(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: profile export [-o file] | profile import file")
+	}
+	switch args[0] {
+	case "export":
+		return runProfileExport(args[1:])
+	case "import":
+		return runProfileImport(args[1:])
+	}
+	return fmt.Errorf("unknown profile command %q", args[0])
+}
+
+func runProfileExport(args []string) error {
+	fs := flag.NewFlagSet("profile export", flag.ExitOnError)
+	out := fs.String("o", "", "file to write (default: standard output)")
+	socket := fs.String("socket", "", "control socket path (default: the user service's, else the system one)")
+	seat := fs.String("seat", "", "seat whose touchpad to fingerprint (default: the first configured)")
+	configFile := fs.String("config", "", "config file to export (default: the driver's)")
+	fs.Parse(args)
+
+	path := *configFile
+	if path == "" {
+		path = config.Path()
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	info, err := queryDevice(*socket, *seat)
+	if err != nil {
+		return err
+	}
+	info.Path = ""
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(ProfileBundle{Device: info, Profile: cfg.Profile})
+}
+
+func runProfileImport(args []string) error {
+	fs := flag.NewFlagSet("profile import", flag.ExitOnError)
+	socket := fs.String("socket", "", "control socket path (default: the user service's, else the system one)")
+	seat := fs.String("seat", "", "seat whose touchpad to adapt to (default: the first configured)")
+	configFile := fs.String("config", "", "config file to import into (default: the driver's)")
+	noScale := fs.Bool("no-scale", false, "keep the values as they are on a different touchpad")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: profile import [flags] file")
+	}
+
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// Settings the bundle leaves out keep their defaults.
+	bundle := ProfileBundle{Profile: config.DefaultProfile()}
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return fmt.Errorf("parse %s: %w", fs.Arg(0), err)
+	}
+	info, err := queryDevice(*socket, *seat)
+	if err != nil {
+		return err
+	}
+
+	p := bundle.Profile
+	from := bundle.Device
+	if from.Name != info.Name || from.Vendor != info.Vendor || from.Product != info.Product {
+		fmt.Fprintf(os.Stderr, "Warning: exported from %q (%04x:%04x), this is %q (%04x:%04x)\n",
+			from.Name, from.Vendor, from.Product, info.Name, info.Vendor, info.Product)
+	}
+	if changes := scaleProfile(&p, from, info); len(changes) > 0 {
+		if *noScale {
+			p = bundle.Profile
+			fmt.Fprintln(os.Stderr, "Warning: the touchpads differ; keeping the values unscaled")
+		} else {
+			fmt.Fprintln(os.Stderr, "The touchpads differ; scaled:")
+			for _, c := range changes {
+				fmt.Fprintln(os.Stderr, "  "+c)
+			}
+		}
+	}
+
+	path := *configFile
+	if path == "" {
+		path = config.Path()
+	}
+	if err := mergeProfile(path, p); err != nil {
+		return err
+	}
+	fmt.Printf("Imported into %s; restart the driver to apply.\n", path)
+	return nil
+}
+
+// queryDevice asks the driver which touchpad seat uses.
+func queryDevice(socket, seat string) (device.Info, error) {
+	conn, err := dialControl(socket)
+	if err != nil {
+		return device.Info{}, fmt.Errorf("connect to driver: %w", err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(controlRequest{Cmd: "device", Seat: seat}); err != nil {
+		return device.Info{}, err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return device.Info{}, err
+	}
+	if !resp.OK || resp.Device == nil {
+		return device.Info{}, fmt.Errorf("driver: %s", resp.Error)
+	}
+	return *resp.Device, nil
+}
+
+// scaleProfile adapts the settings in p measured in touchpad units from pad
+// from to pad to, so that the same finger movement and force do the same
+// thing, and describes each change. Distances scale by resolution where
+// both pads report one, else by the axis ranges; pressures by their ranges.
+func scaleProfile(p *config.Profile, from, to device.Info) []string {
+	var changes []string
+	dist := 1.0
+	switch {
+	case from.ResX > 0 && to.ResX > 0:
+		dist = float64(to.ResX) / float64(from.ResX)
+	case from.MaxX > 0 && to.MaxX > 0:
+		dist = float64(to.MaxX) / float64(from.MaxX)
+	}
+	if dist != 1 {
+		before := *p
+		p.MoveSensitivity /= dist
+		changes = append(changes, fmt.Sprintf("sensitivity %.3g -> %.3g", before.MoveSensitivity, p.MoveSensitivity))
+		// tap_move_percent is relative to the pad already.
+		if p.TapMoveLimit > 0 {
+			p.TapMoveLimit *= dist
+			changes = append(changes, fmt.Sprintf("tap_move_limit %.3g -> %.3g", before.TapMoveLimit, p.TapMoveLimit))
+		}
+	}
+
+	if from.MaxPressure > 0 && to.MaxPressure > 0 && from.MaxPressure != to.MaxPressure {
+		ratio := float64(to.MaxPressure) / float64(from.MaxPressure)
+		for _, v := range []struct {
+			name string
+			p    *int32
+		}{
+			{"press_pressure", &p.PressPressure},
+			{"release_pressure", &p.ReleasePressure},
+			{"min_move_pressure", &p.MinMovePressure},
+			{"force_press_pressure", &p.ForcePressPressure},
+			{"force_release_pressure", &p.ForceReleasePressure},
+			{"press_pressure_min", &p.PressPressureMin},
+			{"press_pressure_max", &p.PressPressureMax},
+		} {
+			if *v.p == 0 {
+				continue
+			}
+			before := *v.p
+			*v.p = int32(math.Round(float64(*v.p) * ratio))
+			changes = append(changes, fmt.Sprintf("%s %d -> %d", v.name, before, *v.p))
+		}
+	}
+	return changes
+}
+
+// mergeProfile writes p's settings into the config file at path, keeping
+// whatever else it holds.
+func mergeProfile(path string, p config.Profile) error {
+	fields := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	profile, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(profile, &settings); err != nil {
+		return err
+	}
+	for k, v := range settings {
+		fields[k] = v
+	}
+	out, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+	"golang.org/x/sys/unix"
+
+	"touchpad/config"
+	"touchpad/device"
+	"touchpad/engine"
+)
+
+// A recording (--record) is a text file: a header of "# key: value" lines
+// describing the touchpad, then one line per event read from it, timestamp,
+// type, code and value, as in crash reports:
+//
+//	# touchpad2mouse recording
+//	# device: {"name":"GXTP7863:00 27C6:01E0 Touchpad",...}
+//	# capabilities: {"1":[272,325,...],"3":[0,1,...]}
+//	1760000000.123456 EV_ABS ABS_MT_POSITION_X 1234
+//
+// --replay plays one back through the state machine at its original pace.
+// A hand-written one may also carry a "# config:" header, settings for
+// TestGolden to replay it with.
+
+const recordingMagic = "# touchpad2mouse recording"
+
+// ReplayTail is how long a replay goes on after the last event, so that
+// releases and other deferred output still come out.
+const ReplayTail = time.Second
+
+// eventRecorder writes the events the state machine is given to a recording.
+type eventRecorder struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+	f  *os.File
+}
+
+// newEventRecorder creates the recording at path for the touchpad dev.
+func newEventRecorder(path string, dev *evdev.InputDevice) (*eventRecorder, error) {
+	info, err := device.ReadInfo(dev)
+	if err != nil {
+		return nil, err
+	}
+	device, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := json.Marshal(dev.CapabilitiesFlat)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &eventRecorder{w: bufio.NewWriter(f), f: f}
+	fmt.Fprintf(r.w, "%s\n# device: %s\n# capabilities: %s\n", recordingMagic, device, caps)
+	return r, r.w.Flush()
+}
+
+// Record writes the batch events. Nothing is recorded while r is nil.
+func (r *eventRecorder) Record(events []evdev.InputEvent) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ev := range events {
+		fmt.Fprintf(r.w, "%d.%06d %s %s %d\n", ev.Time.Sec, ev.Time.Usec,
+			evdev.EV[int(ev.Type)], codeName(ev.Type, ev.Code), ev.Value)
+	}
+	// Flushed with each batch, so a recording ended by a crash or a kill
+	// is still complete up to that point.
+	r.w.Flush()
+}
+
+func (r *eventRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Flush()
+	return r.f.Close()
+}
+
+// recording is a recording read back.
+type recording struct {
+	info   device.Info
+	caps   map[int][]int
+	config json.RawMessage
+	events []evdev.InputEvent
+}
+
+func loadRecording(path string) (*recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec := &recording{}
+	scan := bufio.NewScanner(f)
+	for n := 1; scan.Scan(); n++ {
+		line := strings.TrimSpace(scan.Text())
+		if n == 1 && line != recordingMagic {
+			return nil, fmt.Errorf("%s: not a recording", path)
+		}
+		if line == "" {
+			continue
+		}
+		if header, ok := strings.CutPrefix(line, "# "); ok {
+			key, value, _ := strings.Cut(header, ": ")
+			switch key {
+			case "device":
+				err = json.Unmarshal([]byte(value), &rec.info)
+			case "capabilities":
+				err = json.Unmarshal([]byte(value), &rec.caps)
+			case "config":
+				rec.config = json.RawMessage(value)
+			}
+		} else {
+			var ev evdev.InputEvent
+			ev, err = parseEventLine(line)
+			rec.events = append(rec.events, ev)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	if rec.caps == nil {
+		return nil, fmt.Errorf("%s: no capabilities", path)
+	}
+	return rec, nil
+}
+
+// parseEventLine reads an event line of a recording.
+func parseEventLine(line string) (evdev.InputEvent, error) {
+	var ev evdev.InputEvent
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return ev, fmt.Errorf("malformed event %q", line)
+	}
+	sec, usec, _ := strings.Cut(fields[0], ".")
+	s, err1 := strconv.ParseInt(sec, 10, 64)
+	us, err2 := strconv.ParseInt(usec, 10, 64)
+	value, err3 := strconv.ParseInt(fields[3], 10, 32)
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return ev, fmt.Errorf("malformed event %q", line)
+	}
+	typ, ok := eventType(fields[1])
+	if !ok {
+		return ev, fmt.Errorf("unknown event type %s", fields[1])
+	}
+	code, ok := eventCode(typ, fields[2])
+	if !ok {
+		return ev, fmt.Errorf("unknown event code %s", fields[2])
+	}
+	ev.Time = syscall.NsecToTimeval(s*1e9 + us*1e3)
+	ev.Type, ev.Code, ev.Value = typ, code, int32(value)
+	return ev, nil
+}
+
+// eventType and eventCode undo the naming of events in recordings.
+func eventType(name string) (uint16, bool) {
+	for typ, n := range evdev.EV {
+		if n == name {
+			return uint16(typ), true
+		}
+	}
+	return 0, false
+}
+
+func eventCode(typ uint16, name string) (uint16, bool) {
+	// Codes without a name are written in hex, as codeName does.
+	if hex, ok := strings.CutPrefix(name, evdev.EV[int(typ)]+"_0x"); ok {
+		code, err := strconv.ParseUint(hex, 16, 16)
+		return uint16(code), err == nil
+	}
+	code, ok := eventCodes()[typ][name]
+	return code, ok
+}
+
+// eventCodes maps the names codeName gives back to codes, by event type.
+var eventCodes = sync.OnceValue(func() map[uint16]map[string]uint16 {
+	codes := make(map[uint16]map[string]uint16)
+	for typ := range evdev.EV {
+		names := make(map[string]uint16)
+		for code := range device.KEY_MAX + 1 {
+			names[codeName(uint16(typ), uint16(code))] = uint16(code)
+		}
+		codes[uint16(typ)] = names
+	}
+	return codes
+})
+
+// replaySource feeds a recording to the state machine as an EventSource,
+// each event when its time comes relative to the first, so timeouts behave
+// as they did. Events are stamped with the time they are replayed at.
+type replaySource struct {
+	path  string
+	rec   *recording
+	next  int
+	start time.Time
+	// timer wakes the event loop when the next event is due.
+	timer int
+	out   []evdev.InputEvent
+}
+
+func newReplaySource(path string, rec *recording) (*replaySource, error) {
+	timer, err := unix.TimerfdCreate(unix.CLOCK_MONOTONIC, unix.TFD_CLOEXEC|unix.TFD_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("timerfd_create: %w", err)
+	}
+	r := &replaySource{path: path, rec: rec, start: time.Now(), timer: timer}
+	r.arm()
+	return r, nil
+}
+
+// due returns when the event at i is replayed; one past the end is the end
+// of the replay.
+func (r *replaySource) due(i int) time.Time {
+	events := r.rec.events
+	if len(events) == 0 {
+		return r.start.Add(ReplayTail)
+	}
+	if i == len(events) {
+		return r.due(i - 1).Add(ReplayTail)
+	}
+	return r.start.Add(device.Time(events[i].Time).Sub(device.Time(events[0].Time)))
+}
+
+func (r *replaySource) arm() {
+	spec := unix.ItimerSpec{Value: unix.NsecToTimespec(max(time.Until(r.due(r.next)), 1).Nanoseconds())}
+	unix.TimerfdSettime(r.timer, 0, &spec, nil)
+}
+
+func (r *replaySource) Path() string                { return r.path }
+func (r *replaySource) Fd() (int, error)            { return r.timer, nil }
+func (r *replaySource) Info() (device.Info, error)  { return r.rec.info, nil }
+func (r *replaySource) Capabilities() map[int][]int { return r.rec.caps }
+
+func (r *replaySource) Read() ([]evdev.InputEvent, error) {
+	var buf [8]byte
+	unix.Read(r.timer, buf[:])
+	now := time.Now()
+	if r.next == len(r.rec.events) {
+		if now.Before(r.due(r.next)) {
+			return nil, nil
+		}
+		return nil, io.EOF
+	}
+	r.out = r.out[:0]
+	for ; r.next < len(r.rec.events); r.next++ {
+		at := r.due(r.next)
+		if at.After(now) {
+			break
+		}
+		ev := r.rec.events[r.next]
+		ev.Time = syscall.NsecToTimeval(at.UnixNano())
+		r.out = append(r.out, ev)
+	}
+	r.arm()
+	return r.out, nil
+}
+
+// TouchState can't be had from a recording, which has no more than the
+// events that were read.
+func (r *replaySource) TouchState() (device.TouchState, error) {
+	return device.TouchState{}, errors.New("the recording has a gap where the kernel dropped events")
+}
+
+func (r *replaySource) Close() {
+	unix.Close(r.timer)
+}
+
+// runReplay plays the recording at path through the state machine with the
+// configured settings of the first touchpad, printing what the virtual
+// devices would be sent, until its end.
+func runReplay(path string, opts driverOptions) error {
+	rec, err := loadRecording(path)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(config.Path())
+	if err != nil {
+		return err
+	}
+	opts.apply(&cfg)
+	dump, err := newEventDump(os.Stdout, opts.debugFormat)
+	if err != nil {
+		return err
+	}
+	// As when running, the other formats describe the touch too.
+	var raw *eventDump
+	if opts.debugEvents || opts.debugFormat != DebugFormatDefault {
+		raw = dump
+	}
+	return replay(path, rec, cfg, raw, func(device string) func(typ, code uint16, value int32) {
+		return dump.virtual(device, path)
+	})
+}
+
+// replay plays rec through the state machine with cfg's settings for its
+// first touchpad. trace returns the hook that sees what the virtual device
+// named device is sent; dump, if set, is given the recorded events and
+// frame states.
+func replay(path string, rec *recording, cfg config.Config, dump *eventDump, trace func(device string) func(typ, code uint16, value int32)) error {
+	if err := config.CheckProfileCycle(cfg); err != nil {
+		return err
+	}
+	sc := cfg.SeatList()[0]
+	profiles, err := config.SeatProfiles(cfg, sc)
+	if err != nil {
+		return err
+	}
+	outputs, err := config.CheckProfiles(profiles)
+	if err != nil {
+		return err
+	}
+
+	loop, err := engine.NewLoop()
+	if err != nil {
+		return err
+	}
+	defer loop.Close()
+	// Gestures come out as key chords whatever the backend, so they show.
+	inst, err := newSeat(sc, profiles, outputs, cfg.VirtualDevice, nil, nil, loop, rec.info, nil, true)
+	if err != nil {
+		return err
+	}
+	inst.cycle, inst.engine.CycleGesture = cfg.ProfileCycle, cfg.ProfileCycleGesture
+	defer func() {
+		for _, v := range inst.outputs() {
+			v.Close()
+		}
+	}()
+	if dump != nil {
+		inst.engine.Trace = dump
+	}
+	for _, v := range inst.outputs() {
+		v.Trace = trace(v.Name())
+	}
+
+	src, err := newReplaySource(path, rec)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := inst.process(src); !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
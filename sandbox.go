@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Syscalls the event loop never needs. A compromised parser of the kernel
+// event stream should not be able to use them either.
+var deniedSyscalls = []uintptr{
+	unix.SYS_EXECVE, unix.SYS_EXECVEAT,
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_CHROOT,
+	unix.SYS_UNSHARE, unix.SYS_SETNS, unix.SYS_OPEN_BY_HANDLE_AT,
+	unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD, unix.SYS_REBOOT,
+	unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY,
+	unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_ACCT,
+}
+
+// sandboxReadPaths are the only places the driver reads after setup:
+// touchpads when reopening after resume, udev's database for seat lookup,
+// and compositor IPC sockets for the gesture backend. The config directory
+// is added by the caller.
+var sandboxReadPaths = []string{
+	"/dev/input",
+	"/run/udev/data",
+	"/run/user",
+	"/tmp/hypr",
+}
+
+// applySandbox restricts the process for the rest of its life. Every
+// already-open fd keeps working. Both layers are best effort: a kernel
+// without Landlock or seccomp just gets a warning.
+func applySandbox(configDir string) {
+	// Both layers require no_new_privs. Set it on every thread where the
+	// runtime allows; otherwise seccomp's TSYNC spreads it from this one.
+	_, _, errno := syscall.AllThreadsSyscall6(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0, 0)
+	if errno != 0 {
+		unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+	}
+
+	if err := applySeccomp(); err != nil {
+		slog.Warn("seccomp filter not applied", "err", err)
+	}
+	if err := applyLandlock(append(sandboxReadPaths, configDir)); err != nil {
+		slog.Warn("Landlock ruleset not applied", "err", err)
+	}
+}
+
+// x32Bit is __X32_SYSCALL_BIT, set in the numbers of x32 syscalls. They
+// come with the x86-64 audit arch, so the filter rejects them by number.
+const x32Bit = 0x40000000
+
+func applySeccomp() error {
+	prog, err := seccompFilter(runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	// TSYNC applies the filter, and no_new_privs, to every thread.
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// seccompFilter returns the BPF program that denies deniedSyscalls, and
+// every syscall of an architecture other than goarch's.
+func seccompFilter(goarch string) ([]unix.SockFilter, error) {
+	var arch uint32
+	switch goarch {
+	case "amd64":
+		arch = unix.AUDIT_ARCH_X86_64
+	case "arm64":
+		arch = unix.AUDIT_ARCH_AARCH64
+	default:
+		return nil, fmt.Errorf("unsupported architecture %s", goarch)
+	}
+
+	const (
+		offNr   = 0 // offsetof(struct seccomp_data, nr)
+		offArch = 4 // offsetof(struct seccomp_data, arch)
+	)
+	deny := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	prog := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offArch},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: arch, Jt: 1},
+		{Code: unix.BPF_RET | unix.BPF_K, K: deny},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offNr},
+	}
+	if arch == unix.AUDIT_ARCH_X86_64 {
+		prog = append(prog,
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, K: x32Bit, Jf: 1},
+			unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: deny},
+		)
+	}
+	for _, nr := range deniedSyscalls {
+		prog = append(prog,
+			unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(nr), Jf: 1},
+			unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: deny},
+		)
+	}
+	return append(prog, unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ALLOW}), nil
+}
+
+func applyLandlock(readPaths []string) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return fmt.Errorf("not supported by this kernel: %w", errno)
+	}
+
+	// Handle every filesystem right this kernel knows about, so anything
+	// not granted below is denied.
+	handled := uint64(0x1fff) // EXECUTE through MAKE_SYM, ABI 1
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		handled |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	read := uint64(unix.LANDLOCK_ACCESS_FS_READ_FILE|unix.LANDLOCK_ACCESS_FS_READ_DIR) |
+		handled&unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("create ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	for _, path := range readPaths {
+		dir, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			continue
+		}
+		rule := unix.LandlockPathBeneathAttr{Allowed_access: read, Parent_fd: int32(dir)}
+		_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, fd, unix.LANDLOCK_RULE_PATH_BENEATH,
+			uintptr(unsafe.Pointer(&rule)), 0, 0, 0)
+		unix.Close(dir)
+		if errno != 0 {
+			return fmt.Errorf("add rule for %s: %w", path, errno)
+		}
+	}
+
+	// Landlock restricts only the calling thread, so it has to run on all of
+	// them, which the Go runtime can only do in binaries built without cgo.
+	_, _, errno = syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0)
+	if errors.Is(errno, syscall.ENOTSUP) {
+		return errors.New("needs a binary built with CGO_ENABLED=0")
+	}
+	if errno != 0 {
+		return fmt.Errorf("restrict self: %w", errno)
+	}
+	return nil
+}
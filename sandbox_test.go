@@ -0,0 +1,75 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// runFilter runs a seccomp program, as built by seccompFilter, on a syscall
+// nr of arch. It knows only the instructions seccompFilter uses.
+func runFilter(t *testing.T, prog []unix.SockFilter, arch, nr uint32) uint32 {
+	t.Helper()
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			switch ins.K {
+			case 0:
+				acc = nr
+			case 4:
+				acc = arch
+			default:
+				t.Fatalf("load of seccomp_data offset %d", ins.K)
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			if acc >= ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", ins.Code, pc)
+		}
+	}
+	t.Fatal("program ran off its end")
+	return 0
+}
+
+func TestSeccompFilter(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the syscall numbers below are amd64's")
+	}
+	prog, err := seccompFilter("amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	for _, tc := range []struct {
+		name string
+		arch uint32
+		nr   uint32
+		want uint32
+	}{
+		{"read", unix.AUDIT_ARCH_X86_64, unix.SYS_READ, unix.SECCOMP_RET_ALLOW},
+		{"execve", unix.AUDIT_ARCH_X86_64, unix.SYS_EXECVE, deny},
+		{"ptrace", unix.AUDIT_ARCH_X86_64, unix.SYS_PTRACE, deny},
+		{"x32 execve", unix.AUDIT_ARCH_X86_64, x32Bit | 520, deny},
+		{"x32 read", unix.AUDIT_ARCH_X86_64, x32Bit | unix.SYS_READ, deny},
+		{"i386 read", unix.AUDIT_ARCH_I386, 3, deny},
+	} {
+		if got := runFilter(t, prog, tc.arch, tc.nr); got != tc.want {
+			t.Errorf("%s: filter returned %#x, want %#x", tc.name, got, tc.want)
+		}
+	}
+}
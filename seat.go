@@ -0,0 +1,579 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+
+	"touchpad/config"
+	"touchpad/device"
+	"touchpad/engine"
+	"touchpad/uinput"
+)
+
+// seatInstance is one independent translation pipeline: a source touchpad,
+// the virtual device it drives and the state exposed for it.
+type seatInstance struct {
+	cfg     config.SeatConfig
+	pad     *device.Touchpad
+	loop    *engine.Loop
+	vmouse  *uinput.Device
+	vkbd    *uinput.Device
+	status  *statusTracker
+	events  *eventHub
+	actions gestureBackend
+	// engine is the state machine translating the touchpad's input.
+	engine  *engine.Engine
+	resumed chan struct{}
+	idle    idleState
+	saver   batterySaver
+	heatmap heatmap
+	started time.Time
+	// profiles are those the seat can switch between, the first being the
+	// one it starts with; profile points at the current one. profilesMu
+	// guards profiles, which a reload replaces.
+	profiles   []config.NamedProfile
+	profile    atomic.Pointer[config.Profile]
+	profilesMu sync.Mutex
+	// cycle is the profiles the engine's CycleGesture steps through.
+	cycle []string
+	// vabs is the absolute pointer, set when a profile's output is
+	// "absolute", and vtouch the touchscreen, set for "touchscreen". Either
+	// takes over from vmouse while such a profile is current.
+	vabs   *uinput.Device
+	vtouch *uinput.Device
+	// beeper is set when feedback_beep is on.
+	beeper *beeper
+	// launcher runs hot zone and gesture commands; it is set when any are
+	// configured.
+	launcher *launcher
+	// dormant is woken by every batch of input; nil with idle_suspend_ms 0.
+	dormant *dormancy
+	// orientation is the screen's, for rotate_with_screen.
+	orientation orientation
+	// resumeBlank is resume_blank_ms, and blankUntil the end of the
+	// current window in Unix nanoseconds.
+	resumeBlank time.Duration
+	blankUntil  atomic.Int64
+	// record is set with --record.
+	record *eventRecorder
+	// recent and crash feed the report written if the loop panics.
+	recent eventRing
+	crash  *crashReporter
+	// asleep is set while the fd is closed for suspend, which tells run
+	// that the resulting read error is expected.
+	asleep atomic.Bool
+	// keyboards are those watched for disable_while_typing, and lastTyped
+	// when a typing key was last pressed on one, in Unix nanoseconds.
+	keyboards []*os.File
+	lastTyped atomic.Int64
+	// state remembers the profile switched to; nil for a dry run.
+	state *driverState
+}
+
+// virtualDeviceName names the uinput pointer for seat, based on the configured
+// name. Seats other than seat0 get a suffix so udev rules can assign them with
+// ENV{ID_SEAT}.
+func virtualDeviceName(base, seat string) string {
+	if seat == device.DefaultSeat {
+		return base
+	}
+	return base + " " + seat
+}
+
+// outputBase is the base name of the virtual devices for sc's pointer: the
+// configured name, plus the pointer's name unless it is the seat's own.
+func outputBase(name string, sc config.SeatConfig) string {
+	if sc.Pointer == sc.Seat {
+		return name
+	}
+	return name + " " + sc.Pointer
+}
+
+// keyboardDeviceName names the uinput keyboard that sends seat's gesture
+// chords.
+func keyboardDeviceName(base, seat string) string {
+	return virtualDeviceName(base, seat) + " Keyboard"
+}
+
+// keyboardCaps are those of the keyboard that sends the gesture chords; the
+// named keys are there for keypad mode.
+var keyboardCaps = uinput.Caps{
+	Keys: append([]int{device.KEY_LEFTMETA, device.KEY_TAB, device.KEY_LEFTALT, device.KEY_LEFTSHIFT, device.KEY_D, device.KEY_LEFTCTRL, device.KEY_LEFT, device.KEY_RIGHT}, config.NamedKeys()...),
+}
+
+// virtualID is the identity configured for the virtual devices.
+func virtualID(vd config.VirtualDeviceConfig) uinput.ID {
+	return uinput.ID{
+		Bustype: uint16(vd.Bustype),
+		Vendor:  uint16(vd.Vendor),
+		Product: uint16(vd.Product),
+		Version: uint16(vd.Version),
+	}
+}
+
+// openSeat sets up the pipeline for one touchpad. shared is the instance of
+// an earlier touchpad with the same pointer, whose virtual devices this one
+// feeds too, or nil. A dry run leaves the touchpad ungrabbed and creates
+// virtual devices that send nothing.
+func openSeat(sc config.SeatConfig, profiles []config.NamedProfile, vd config.VirtualDeviceConfig, actions gestureBackend, shared *seatInstance, dryRun bool) (*seatInstance, error) {
+	// Every output any profile uses is created up front, since the sandbox
+	// rules out opening /dev/uinput later.
+	outputs, err := config.CheckProfiles(profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := device.Find(sc.Device, DeviceNameMustContain, sc.Seat)
+	if err != nil {
+		return nil, withHint(err, inputAccessHint())
+	}
+	slog.Info("found touchpad", "seat", sc.Seat, "device", path)
+
+	loop, err := engine.NewLoop()
+	if err != nil {
+		return nil, err
+	}
+	pad := &device.Touchpad{OnClose: loop.Wake}
+	if err := pad.Open(path); err != nil {
+		loop.Close()
+		return nil, fmt.Errorf("open %s: %w", path, withHint(err, openHint(path, err)))
+	}
+	if dryRun {
+		slog.Info("dry run: not grabbing the touchpad", "seat", sc.Seat)
+	} else if err := pad.Grab(); err != nil {
+		slog.Warn("cannot grab touchpad", "seat", sc.Seat, "err", err)
+		if hint := openHint(path, err); hint != "" {
+			fmt.Fprintln(os.Stderr, hint)
+		}
+	} else {
+		slog.Info("grabbed touchpad", "seat", sc.Seat)
+	}
+	dev := pad.Device()
+	slog.Debug("touchpad capabilities", "seat", sc.Seat, "name", dev.Name, "caps", device.CapabilitySummary(dev))
+
+	info, infoErr := device.ReadInfo(dev)
+	s, err := newSeat(sc, profiles, outputs, vd, actions, shared, loop, info, infoErr, dryRun)
+	if err != nil {
+		pad.Close()
+		loop.Close()
+		return nil, err
+	}
+	s.pad = pad
+	return s, nil
+}
+
+// newSeat creates the virtual devices for a touchpad described by info, or
+// takes them over from shared, and the instance translating for it on loop.
+// Absolute outputs need info, so infoErr fails them. A dry run creates
+// devices that send nothing.
+func newSeat(sc config.SeatConfig, profiles []config.NamedProfile, outputs map[string]bool, vd config.VirtualDeviceConfig, actions gestureBackend,
+	shared *seatInstance, loop *engine.Loop, info device.Info, infoErr error, dryRun bool) (*seatInstance, error) {
+	props, err := vd.Props()
+	if err != nil {
+		return nil, fmt.Errorf("virtual_device: %w", err)
+	}
+	pointerCaps := uinput.MouseCaps
+	pointerCaps.Props = props
+
+	// A further touchpad for a pointer feeds the devices of the first one
+	// and only creates the outputs its own profiles add.
+	var vmouse, vkbd, vabs, vtouch *uinput.Device
+	if shared != nil {
+		vmouse, vkbd, vabs, vtouch = shared.vmouse, shared.vkbd, shared.vabs, shared.vtouch
+	}
+	base := outputBase(vd.Name, sc)
+	mouseName, kbdName := virtualDeviceName(base, sc.Seat), keyboardDeviceName(base, sc.Seat)
+	// Absolute outputs take their ranges from the touchpad.
+	wanted := []struct {
+		v      **uinput.Device
+		need   bool
+		ranged bool
+		what   string
+		name   string
+		caps   func() uinput.Caps
+	}{
+		{&vmouse, true, false, "virtual mouse", mouseName, func() uinput.Caps { return pointerCaps }},
+		{&vkbd, true, false, "virtual keyboard", kbdName, func() uinput.Caps { return keyboardCaps }},
+		{&vabs, outputs[config.OutputAbsolute], true, "absolute pointer", tabletDeviceName(base, sc.Seat), func() uinput.Caps { return uinput.TabletCaps(info) }},
+		{&vtouch, outputs[config.OutputTouchscreen], true, "touchscreen", touchscreenDeviceName(base, sc.Seat), func() uinput.Caps { return uinput.TouchscreenCaps(info) }},
+	}
+	// created are destroyed again if a later device fails.
+	var created []*uinput.Device
+	for _, w := range wanted {
+		if !w.need || *w.v != nil {
+			continue
+		}
+		var err error
+		if w.ranged {
+			err = infoErr
+		}
+		if err == nil && dryRun {
+			*w.v = uinput.New(nil, w.name, "")
+		} else if err == nil {
+			*w.v, err = uinput.Create(w.name, virtualID(vd), w.caps())
+			var pe *fs.PathError
+			if errors.As(err, &pe) {
+				err = withHint(err, openHint(pe.Path, err))
+			}
+		}
+		if err != nil {
+			for _, v := range created {
+				v.Close()
+			}
+			return nil, fmt.Errorf("create %s: %w", w.what, err)
+		}
+		created = append(created, *w.v)
+	}
+	slog.Info("created virtual devices", "seat", sc.Seat, "touchpad", sc.Name, "shared", shared != nil,
+		"pointer", sc.Pointer, "mouse", mouseName, "keyboard", kbdName, "absolute", vabs != nil, "touchscreen", vtouch != nil)
+
+	s := &seatInstance{
+		cfg:      sc,
+		profiles: profiles,
+		loop:     loop,
+		vmouse:   vmouse,
+		vkbd:     vkbd,
+		vabs:     vabs,
+		vtouch:   vtouch,
+		status:   newStatusTracker(),
+		events:   newEventHub(),
+		actions:  actions,
+		resumed:  make(chan struct{}, 1),
+		started:  time.Now(),
+	}
+	s.engine = &engine.Engine{
+		Seat:    sc.Seat,
+		Loop:    loop,
+		Profile: s.profile.Load,
+		Outputs: engine.Outputs{Mouse: vmouse, Keyboard: vkbd},
+		Status:  s.status,
+		Host:    s,
+		Events:  s.events,
+	}
+	// A nil device must stay a nil interface, or the engine would write to it.
+	if vabs != nil {
+		s.engine.Outputs.Absolute = vabs
+	}
+	if vtouch != nil {
+		s.engine.Outputs.Touchscreen = vtouch
+	}
+	s.profile.Store(&profiles[0].Profile)
+	s.status.SetProfile(profiles[0].Name, config.AccelName(profiles[0].Profile))
+	s.idle.OnChange(s.status.SetIdle)
+	s.idle.OnChange(s.setLowPower)
+	s.saver.onChange = func(on bool) {
+		slog.Info("battery saver changed", "seat", sc.Seat, "on", on)
+		s.updateSlack()
+		s.status.SetBatterySaver(on)
+	}
+	s.status.OnEvent(func(ev Event) {
+		switch ev.Kind {
+		case EventGesture:
+			slog.Debug("gesture", "seat", sc.Seat, "gesture", ev.Name)
+			s.engine.Metrics.Gesture(ev.Name)
+			s.events.Publish(engine.StreamEvent{Type: "gesture", Name: ev.Name})
+		case EventProfile:
+			slog.Info("profile switched", "seat", sc.Seat, "profile", ev.Name)
+		case EventEnabled:
+			slog.Info("enabled changed", "seat", sc.Seat, "enabled", ev.Enabled)
+		case EventDevice:
+			slog.Info("touchpad connection changed", "seat", sc.Seat, "connected", ev.Connected)
+		}
+	})
+	return s, nil
+}
+
+func (s *seatInstance) stats() SessionStats {
+	return sessionStats(&s.engine.Metrics, s.cfg.Name, s.started)
+}
+
+// run processes events until the touchpad fails for a reason other than
+// being closed for suspend.
+func (s *seatInstance) run() error {
+	for {
+		src, err := device.NewSource(s.pad.Device())
+		if err == nil {
+			err = s.process(src)
+		}
+		if !errors.Is(err, os.ErrClosed) || !s.asleep.Load() {
+			return fmt.Errorf("%s: reading touchpad: %w", s.cfg.Seat, err)
+		}
+
+		// The fd was closed for suspend; pick up with fresh state on resume.
+		<-s.resumed
+		s.asleep.Store(false)
+		path := reopenTouchpad(s.pad, s.cfg.Device, s.cfg.Seat)
+		s.status.Resume("sleep")
+		slog.Info("resumed", "seat", s.cfg.Seat, "device", path)
+	}
+}
+
+// updateSlack lets deferred actions run late, sharing wakeups, while
+// battery-saver mode is on or the seat is idle.
+func (s *seatInstance) updateSlack() {
+	if s.saver.Active() || s.idle.Idle() {
+		s.loop.SetSlack(SaverTimerSlack)
+	} else {
+		s.loop.SetSlack(0)
+	}
+}
+
+// setLowPower switches low-power mode, which the seat is in while idle: the
+// keyboards watched for typing aren't read and deferred actions may run
+// late. Whatever was typed meanwhile is read once they are back.
+func (s *seatInstance) setLowPower(on bool) {
+	slog.Debug("low-power mode changed", "seat", s.cfg.Seat, "on", on)
+	s.updateSlack()
+	for _, f := range s.keyboards {
+		// A keyboard that went away is closed, with no fd left.
+		fd := int(f.Fd())
+		if fd < 0 {
+			continue
+		}
+		if on {
+			s.loop.Remove(fd)
+		} else {
+			s.loop.Add(fd, func() { s.readKeyboard(f) })
+		}
+	}
+}
+
+// process runs the state machine on src until it fails.
+func (s *seatInstance) process(src engine.EventSource) error {
+	if info, err := src.Info(); err == nil {
+		s.heatmap.SetRange(info.MaxX, info.MaxY)
+	}
+	return s.engine.Run(src)
+}
+
+// The methods below make the seat the state machine's engine.Host; they are
+// called on the event loop.
+
+func (s *seatInstance) Beep() { s.beeper.Beep(s.loop) }
+
+func (s *seatInstance) RunCommand(command string) { s.launcher.Run(s.cfg.Seat, command) }
+
+func (s *seatInstance) Dispatch(gesture string) bool {
+	return s.actions != nil && s.actions.Dispatch(gesture)
+}
+
+func (s *seatInstance) Saving() bool { return s.saver.Active() }
+
+func (s *seatInstance) Rotate(dx, dy float64) (float64, float64) {
+	return s.orientation.Rotate(dx, dy)
+}
+
+// Input notes a batch of input as activity, for the recording and for a
+// crash report.
+func (s *seatInstance) Input(events []evdev.InputEvent) {
+	s.idle.Touch()
+	s.dormant.Wake()
+	s.record.Record(events)
+	for _, ev := range events {
+		s.recent.Add(ev)
+	}
+}
+
+func (s *seatInstance) Frame(slots *device.Slots) { s.heatmap.Add(slots) }
+
+func (s *seatInstance) Crashed(path string, value any, stack []byte, state string) {
+	s.crash.Write(crashReport(s.cfg.Seat, path, value, stack, state, s.recent.Events()))
+}
+
+func (s *seatInstance) sleep() {
+	s.asleep.Store(true)
+	s.status.Pause("sleep")
+	s.releaseAll()
+	s.pad.Close()
+}
+
+func (s *seatInstance) resume() {
+	s.blank()
+	select {
+	case s.resumed <- struct{}{}:
+	default:
+	}
+}
+
+// blank starts a resume_blank_ms window in which new touches are ignored.
+func (s *seatInstance) blank() {
+	if s.resumeBlank > 0 {
+		s.blankUntil.Store(time.Now().Add(s.resumeBlank).UnixNano())
+	}
+}
+
+// Blanked reports whether t falls in the window started by blank.
+func (s *seatInstance) Blanked(t time.Time) bool {
+	return t.UnixNano() < s.blankUntil.Load()
+}
+
+// outputs returns the seat's virtual devices.
+func (s *seatInstance) outputs() []*uinput.Device {
+	out := []*uinput.Device{s.vmouse, s.vkbd}
+	for _, v := range []*uinput.Device{s.vabs, s.vtouch} {
+		if v != nil {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// pointer returns the device that pointer input goes to under the current
+// profile.
+func (s *seatInstance) pointer() *uinput.Device {
+	switch s.profile.Load().Output {
+	case config.OutputAbsolute:
+		return s.vabs
+	case config.OutputTouchscreen:
+		return s.vtouch
+	}
+	return s.vmouse
+}
+
+// SetProfile switches to the named profile. The event loop picks it up with
+// the next batch of input.
+func (s *seatInstance) SetProfile(name string) error {
+	s.profilesMu.Lock()
+	defer s.profilesMu.Unlock()
+	for i := range s.profiles {
+		if s.profiles[i].Name == name {
+			s.profile.Store(&s.profiles[i].Profile)
+			s.status.SetProfile(name, config.AccelName(s.profiles[i].Profile))
+			s.state.SetProfile(s.cfg.Name, name)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown profile %q", name)
+}
+
+// reload swaps in new settings for the seat's profiles and carries on with
+// the current one's. The event loop picks them up with its next batch, as
+// for a profile switch; the grab and the virtual devices stay as they are,
+// so profiles needing an output the seat lacks are refused.
+func (s *seatInstance) reload(profiles []config.NamedProfile) error {
+	outputs, err := config.CheckProfiles(profiles)
+	if err != nil {
+		return err
+	}
+	for output := range outputs {
+		if output == config.OutputAbsolute && s.vabs == nil || output == config.OutputTouchscreen && s.vtouch == nil {
+			return fmt.Errorf("output %q needs a restart", output)
+		}
+	}
+	s.profilesMu.Lock()
+	s.profiles = profiles
+	s.profilesMu.Unlock()
+	if s.SetProfile(s.status.Get().Profile) != nil {
+		s.SetProfile(profiles[0].Name)
+	}
+	return nil
+}
+
+// configOnlySettings can't be changed at runtime, as their actions may run
+// shell commands.
+var configOnlySettings = []string{"hot_zones"}
+
+// set changes the current profile's setting key, named as in the config
+// file, to the JSON value until the config is next loaded.
+func (s *seatInstance) set(key string, value json.RawMessage) error {
+	s.profilesMu.Lock()
+	profiles := slices.Clone(s.profiles)
+	s.profilesMu.Unlock()
+	current := s.status.Get().Profile
+	i := slices.IndexFunc(profiles, func(p config.NamedProfile) bool { return p.Name == current })
+	if i < 0 {
+		return fmt.Errorf("unknown profile %q", current)
+	}
+	fields, err := profileFields(profiles[i].Profile)
+	if err != nil {
+		return err
+	}
+	if _, ok := fields[key]; !ok {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	if slices.Contains(configOnlySettings, key) {
+		return fmt.Errorf("%q can only be changed in the config file", key)
+	}
+	fields[key] = value
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var p config.Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	profiles[i].Profile = p
+	return s.reload(profiles)
+}
+
+// toggleSetting flips the current profile's boolean setting key.
+func (s *seatInstance) toggleSetting(key string) error {
+	fields, err := profileFields(*s.profile.Load())
+	if err != nil {
+		return err
+	}
+	var on bool
+	if err := json.Unmarshal(fields[key], &on); err != nil {
+		return fmt.Errorf("%q is not a setting that can be toggled", key)
+	}
+	return s.set(key, json.RawMessage(strconv.FormatBool(!on)))
+}
+
+// profileFields returns p's settings by their names in the config file.
+func profileFields(p config.Profile) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// CycleProfile switches to the profile after the current one in the cycle,
+// or to the first if the current one isn't in it.
+func (s *seatInstance) CycleProfile() {
+	if len(s.cycle) == 0 {
+		return
+	}
+	next := s.cycle[(slices.Index(s.cycle, s.status.Get().Profile)+1)%len(s.cycle)]
+	if err := s.SetProfile(next); err != nil {
+		slog.Warn("cannot cycle profile", "seat", s.cfg.Seat, "err", err)
+		return
+	}
+	s.events.Publish(engine.StreamEvent{Type: "profile", Name: next})
+}
+
+// releaseAll lifts every button and key still held on the virtual devices.
+func (s *seatInstance) releaseAll() {
+	for _, v := range s.outputs() {
+		v.ReleaseAll()
+	}
+}
+
+// Close shuts the seat down for good: held buttons and keys are lifted, the
+// touchpad is released and the virtual devices are destroyed.
+func (s *seatInstance) Close() {
+	s.releaseAll()
+	s.pad.Release()
+	s.pad.Close()
+	for _, v := range s.outputs() {
+		v.Close()
+	}
+	for _, f := range s.keyboards {
+		f.Close()
+	}
+	s.loop.Close()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	logindName = "org.freedesktop.login1"
+	logindPath = "/org/freedesktop/login1"
+)
+
+// watchSession follows the active session on seat via logind and calls
+// onChange whenever the driver's session gains or loses the seat. onChange is
+// called once with the initial state before watchSession returns.
+func watchSession(seat, owner string, onChange func(active bool)) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("connect system bus: %w", err)
+	}
+
+	seatPath := dbus.ObjectPath(logindPath + "/seat/" + seat)
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(seatPath),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+	)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("subscribe to %s: %w", seatPath, err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	active := sessionActive(conn, seatPath, owner)
+	onChange(active)
+
+	go func() {
+		for range signals {
+			if now := sessionActive(conn, seatPath, owner); now != active {
+				active = now
+				onChange(active)
+			}
+		}
+	}()
+	return nil
+}
+
+// sessionActive reports whether the seat's active session belongs to owner,
+// or, with no owner, whether the seat has an active session at all.
+func sessionActive(conn *dbus.Conn, seatPath dbus.ObjectPath, owner string) bool {
+	v, err := conn.Object(logindName, seatPath).GetProperty("org.freedesktop.login1.Seat.ActiveSession")
+	if err != nil {
+		// Without logind there is nothing to follow; don't lock the user out.
+		return true
+	}
+	var ref struct {
+		ID   string
+		Path dbus.ObjectPath
+	}
+	if err := dbus.Store([]interface{}{v.Value()}, &ref); err != nil || ref.ID == "" {
+		return false
+	}
+	if owner == "" {
+		return true
+	}
+
+	name, err := conn.Object(logindName, ref.Path).GetProperty("org.freedesktop.login1.Session.Name")
+	if err != nil {
+		return false
+	}
+	return name.Value() == owner
+}
+
+// watchLock follows the lock state of the seat's active session and calls
+// onChange with it, once up front and again on every change. It uses the
+// session's LockedHint, which screen lockers set, and also goes by logind's
+// Lock and Unlock signals straight away so nothing slips through while the
+// locker starts. Only the active session is listened to, and the match moves
+// along when another session takes the seat.
+func watchLock(seat string, onChange func(locked bool)) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("connect system bus: %w", err)
+	}
+
+	seatPath := dbus.ObjectPath(logindPath + "/seat/" + seat)
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(seatPath),
+		dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+		dbus.WithMatchMember("PropertiesChanged"),
+	)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("subscribe to %s: %w", seatPath, err)
+	}
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+
+	session, _ := activeSession(conn, seatPath)
+	if err := matchSession(conn, session, true); err != nil {
+		conn.Close()
+		return fmt.Errorf("subscribe to %s: %w", session, err)
+	}
+	locked := sessionLocked(conn, session)
+	onChange(locked)
+
+	go func() {
+		for sig := range signals {
+			now := locked
+			switch {
+			case sig.Path == seatPath:
+				next, _ := activeSession(conn, seatPath)
+				if next == session {
+					continue
+				}
+				matchSession(conn, session, false)
+				session = next
+				if err := matchSession(conn, session, true); err != nil {
+					slog.Warn("lock tracking lost", "session", session, "err", err)
+				}
+				now = sessionLocked(conn, session)
+			case sig.Path != session:
+				// Left over from the session that had the seat before.
+				continue
+			case sig.Name == "org.freedesktop.login1.Session.Lock":
+				now = true
+			case sig.Name == "org.freedesktop.login1.Session.Unlock":
+				now = false
+			case lockedHintChanged(sig):
+				now = sessionLocked(conn, session)
+			}
+			if now != locked {
+				locked = now
+				onChange(locked)
+			}
+		}
+	}()
+	return nil
+}
+
+// matchSession adds, or with add unset removes, the matches for the lock
+// signals and property changes of one session. An empty path, for a seat
+// nobody is logged in on, needs none.
+func matchSession(conn *dbus.Conn, session dbus.ObjectPath, add bool) error {
+	if session == "" {
+		return nil
+	}
+	matches := [][]dbus.MatchOption{
+		{
+			dbus.WithMatchObjectPath(session),
+			dbus.WithMatchInterface("org.freedesktop.DBus.Properties"),
+			dbus.WithMatchMember("PropertiesChanged"),
+		},
+		{
+			dbus.WithMatchObjectPath(session),
+			dbus.WithMatchInterface("org.freedesktop.login1.Session"),
+			dbus.WithMatchMember("Lock"),
+		},
+		{
+			dbus.WithMatchObjectPath(session),
+			dbus.WithMatchInterface("org.freedesktop.login1.Session"),
+			dbus.WithMatchMember("Unlock"),
+		},
+	}
+	for _, m := range matches {
+		var err error
+		if add {
+			err = conn.AddMatchSignal(m...)
+		} else {
+			err = conn.RemoveMatchSignal(m...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockedHintChanged reports whether sig is a PropertiesChanged that touches
+// a session's LockedHint, so that changes to its other properties don't
+// undo a Lock signal before the locker has set the hint.
+func lockedHintChanged(sig *dbus.Signal) bool {
+	if sig.Name != "org.freedesktop.DBus.Properties.PropertiesChanged" || len(sig.Body) < 3 {
+		return false
+	}
+	if changed, ok := sig.Body[1].(map[string]dbus.Variant); ok {
+		if _, ok := changed["LockedHint"]; ok {
+			return true
+		}
+	}
+	invalidated, _ := sig.Body[2].([]string)
+	return slices.Contains(invalidated, "LockedHint")
+}
+
+// sessionLocked reports whether session is locked; no session is not.
+func sessionLocked(conn *dbus.Conn, session dbus.ObjectPath) bool {
+	if session == "" {
+		return false
+	}
+	hint, err := conn.Object(logindName, session).GetProperty("org.freedesktop.login1.Session.LockedHint")
+	if err != nil {
+		return false
+	}
+	locked, _ := hint.Value().(bool)
+	return locked
+}
+
+// activeSession returns the object path of the session active on the seat
+// at seatPath, or "" if there is none.
+func activeSession(conn *dbus.Conn, seatPath dbus.ObjectPath) (dbus.ObjectPath, error) {
+	v, err := conn.Object(logindName, seatPath).GetProperty("org.freedesktop.login1.Seat.ActiveSession")
+	if err != nil {
+		return "", err
+	}
+	var ref struct {
+		ID   string
+		Path dbus.ObjectPath
+	}
+	if err := dbus.Store([]interface{}{v.Value()}, &ref); err != nil || ref.ID == "" {
+		return "", err
+	}
+	return ref.Path, nil
+}
+
+// sessionUser returns the uid of the user whose session is active on seat.
+func sessionUser(conn *dbus.Conn, seat string) (int, bool) {
+	path, err := activeSession(conn, dbus.ObjectPath(logindPath+"/seat/"+seat))
+	if err != nil || path == "" {
+		return 0, false
+	}
+	v, err := conn.Object(logindName, path).GetProperty("org.freedesktop.login1.Session.User")
+	if err != nil {
+		return 0, false
+	}
+	var user struct {
+		UID  uint32
+		Path dbus.ObjectPath
+	}
+	if err := dbus.Store([]interface{}{v.Value()}, &user); err != nil {
+		return 0, false
+	}
+	return int(user.UID), true
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// watchSleep calls onSleep when logind announces suspend or hibernation and
+// onResume when the system is back. A delay inhibitor is held while awake so
+// onSleep gets to run before the system actually goes down.
+func watchSleep(onSleep, onResume func()) error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("connect system bus: %w", err)
+	}
+	err = conn.AddMatchSignal(
+		dbus.WithMatchObjectPath(logindPath),
+		dbus.WithMatchInterface("org.freedesktop.login1.Manager"),
+		dbus.WithMatchMember("PrepareForSleep"),
+	)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("subscribe to PrepareForSleep: %w", err)
+	}
+	signals := make(chan *dbus.Signal, 4)
+	conn.Signal(signals)
+
+	manager := conn.Object(logindName, logindPath)
+	inhibit := func() *os.File {
+		var fd dbus.UnixFD
+		err := manager.Call("org.freedesktop.login1.Manager.Inhibit", 0,
+			"sleep", "touchpad-driver", "Release held buttons and the touchpad grab", "delay").Store(&fd)
+		if err != nil {
+			slog.Warn("no sleep inhibitor", "err", err)
+			return nil
+		}
+		return os.NewFile(uintptr(fd), "inhibitor")
+	}
+	lock := inhibit()
+
+	go func() {
+		for sig := range signals {
+			if len(sig.Body) != 1 {
+				continue
+			}
+			if start, _ := sig.Body[0].(bool); start {
+				onSleep()
+				if lock != nil {
+					lock.Close()
+					lock = nil
+				}
+			} else {
+				lock = inhibit()
+				onResume()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"touchpad/device"
+)
+
+// DefaultStatePath is where the system service keeps what it remembers
+// across restarts.
+const DefaultStatePath = "/var/lib/touchpad2mouse/state.json"
+
+// statePath is DefaultStatePath for the system service and the same name
+// below $XDG_STATE_HOME for a user service; Android has a path of its own.
+func statePath() string {
+	if device.OnAndroid() {
+		return AndroidStatePath
+	}
+	if os.Getuid() == 0 {
+		return DefaultStatePath
+	}
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return DefaultStatePath
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "touchpad2mouse", filepath.Base(DefaultStatePath))
+}
+
+// driverState is what the driver remembers across restarts: the profile
+// each touchpad was last switched to, by the touchpad's name. The sandbox
+// rules out opening the file later, so it is opened at startup and
+// rewritten in place.
+type driverState struct {
+	mu       sync.Mutex
+	f        *os.File
+	Profiles map[string]string `json:"profiles"`
+}
+
+func openDriverState(path string) (*driverState, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &driverState{f: f}
+	data, err := io.ReadAll(f)
+	if err == nil && len(data) > 0 {
+		err = json.Unmarshal(data, s)
+	}
+	if err != nil {
+		// Only a remembered profile is lost; it is written afresh on
+		// the next switch.
+		slog.Warn("ignoring unreadable state", "path", path, "err", err)
+	}
+	if s.Profiles == nil {
+		s.Profiles = make(map[string]string)
+	}
+	return s, nil
+}
+
+// Profile returns the profile the touchpad called name was last switched
+// to, or "" if none was remembered.
+func (s *driverState) Profile(name string) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Profiles[name]
+}
+
+// SetProfile remembers profile for the touchpad called name. Nothing is
+// remembered while s is nil.
+func (s *driverState) SetProfile(name, profile string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Profiles[name] == profile {
+		return
+	}
+	s.Profiles[name] = profile
+	data, err := json.Marshal(s)
+	if err == nil {
+		err = s.f.Truncate(0)
+	}
+	if err == nil {
+		_, err = s.f.WriteAt(append(data, '\n'), 0)
+	}
+	if err != nil {
+		slog.Warn("cannot save state", "path", s.f.Name(), "err", err)
+	}
+}
+
+func (s *driverState) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Status is the snapshot of driver state exposed to status bars and other
+// clients of the control socket.
+type Status struct {
+	Enabled bool `json:"enabled"`
+	// Paused lists, comma-separated, the reasons the driver is currently
+	// holding back input even though it is enabled (e.g. "session").
+	Paused string `json:"paused,omitempty"`
+	// Idle is set while logind considers the seat idle and no touch
+	// has arrived since.
+	Idle bool `json:"idle,omitempty"`
+	// BatterySaver is set while motion is decimated to save power.
+	BatterySaver bool `json:"battery_saver,omitempty"`
+	// OneFingerScroll is set while a single finger scrolls.
+	OneFingerScroll bool   `json:"one_finger_scroll,omitempty"`
+	Profile         string `json:"profile"`
+	Accel           string `json:"accel"`
+	Fingers         int    `json:"fingers"`
+	LastGesture     string `json:"last_gesture"`
+	// Pressure is the first contact's at the time of a request; it is
+	// not followed, as it changes with every frame.
+	Pressure int32 `json:"pressure,omitempty"`
+}
+
+// EventKind identifies a discrete driver event.
+type EventKind int
+
+const (
+	EventGesture EventKind = iota
+	EventProfile
+	EventEnabled
+	// EventDevice reports the touchpad going away or coming back.
+	EventDevice
+)
+
+// Event is a discrete occurrence, as opposed to the continuously updated
+// Status: a gesture fires even if it is the same one as last time.
+type Event struct {
+	Kind    EventKind
+	Name    string // gesture or profile name
+	Enabled bool
+	// Connected is set for EventDevice when the touchpad is back.
+	Connected bool
+}
+
+// statusTracker holds the current Status and fans out changes to followers.
+// The event loop writes to it; control socket connections read from it.
+type statusTracker struct {
+	mu        sync.Mutex
+	cur       Status
+	subs      map[chan Status]struct{}
+	listeners []func(Event)
+	pauses    map[string]bool
+}
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{
+		cur:    Status{Enabled: true, Profile: "default"},
+		subs:   make(map[chan Status]struct{}),
+		pauses: make(map[string]bool),
+	}
+}
+
+func (t *statusTracker) Get() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cur
+}
+
+// Active reports whether input should be translated: the user has not
+// disabled the driver and nothing has paused it.
+func (t *statusTracker) Active() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cur.Enabled && t.cur.Paused == ""
+}
+
+// Pause holds back input for the given reason until Resume is called with the
+// same reason. Reasons are independent, so one resuming does not undo another.
+func (t *statusTracker) Pause(reason string) {
+	t.setPause(reason, true)
+}
+
+func (t *statusTracker) Resume(reason string) {
+	t.setPause(reason, false)
+}
+
+func (t *statusTracker) setPause(reason string, on bool) {
+	t.mu.Lock()
+	if on {
+		t.pauses[reason] = true
+	} else {
+		delete(t.pauses, reason)
+	}
+	reasons := make([]string, 0, len(t.pauses))
+	for r := range t.pauses {
+		reasons = append(reasons, r)
+	}
+	t.mu.Unlock()
+
+	sort.Strings(reasons)
+	t.update(func(s *Status) { s.Paused = strings.Join(reasons, ",") })
+}
+
+// OnEvent registers fn to be called for every Event. Listeners run on the
+// caller's goroutine, which is usually the event loop, so they must not block.
+func (t *statusTracker) OnEvent(fn func(Event)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listeners = append(t.listeners, fn)
+}
+
+// update applies fn to the current status and notifies followers if anything
+// changed. Slow followers only ever see the latest value.
+func (t *statusTracker) update(fn func(*Status)) {
+	t.mu.Lock()
+	prev := t.cur
+	next := t.cur
+	fn(&next)
+	if next == prev {
+		t.mu.Unlock()
+		return
+	}
+	t.cur = next
+	for ch := range t.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- next
+	}
+	t.mu.Unlock()
+
+	if next.Enabled != prev.Enabled {
+		t.emit(Event{Kind: EventEnabled, Enabled: next.Enabled})
+	}
+	if next.Profile != prev.Profile {
+		t.emit(Event{Kind: EventProfile, Name: next.Profile})
+	}
+}
+
+func (t *statusTracker) emit(ev Event) {
+	t.mu.Lock()
+	listeners := t.listeners
+	t.mu.Unlock()
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+func (t *statusTracker) SetFingers(n int) {
+	t.update(func(s *Status) { s.Fingers = n })
+}
+
+func (t *statusTracker) SetGesture(name string) {
+	t.update(func(s *Status) { s.LastGesture = name })
+	t.emit(Event{Kind: EventGesture, Name: name})
+}
+
+// DeviceChanged announces that the touchpad was lost or reconnected.
+func (t *statusTracker) DeviceChanged(connected bool) {
+	t.emit(Event{Kind: EventDevice, Connected: connected})
+}
+
+func (t *statusTracker) SetIdle(idle bool) {
+	t.update(func(s *Status) { s.Idle = idle })
+}
+
+func (t *statusTracker) SetBatterySaver(on bool) {
+	t.update(func(s *Status) { s.BatterySaver = on })
+}
+
+func (t *statusTracker) SetOneFingerScroll(on bool) {
+	t.update(func(s *Status) { s.OneFingerScroll = on })
+}
+
+func (t *statusTracker) SetProfile(name, accel string) {
+	t.update(func(s *Status) { s.Profile, s.Accel = name, accel })
+}
+
+func (t *statusTracker) SetEnabled(on bool) {
+	t.update(func(s *Status) { s.Enabled = on })
+}
+
+// Subscribe returns a channel that receives the current status immediately and
+// every subsequent change, and a function to stop following.
+func (t *statusTracker) Subscribe() (<-chan Status, func()) {
+	ch := make(chan Status, 1)
+	t.mu.Lock()
+	t.subs[ch] = struct{}{}
+	ch <- t.cur
+	t.mu.Unlock()
+	return ch, func() {
+		t.mu.Lock()
+		delete(t.subs, ch)
+		t.mu.Unlock()
+	}
+}
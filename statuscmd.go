@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// runStatus implements the "status" subcommand: it queries the running driver
+// over the control socket and prints the result in a form status bars accept.
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	follow := fs.Bool("follow", false, "keep running and print a line on every change")
+	format := fs.String("format", "json", "output format: json, waybar or polybar")
+	socket := fs.String("socket", "", "control socket path (default: the user service's, else the system one)")
+	seat := fs.String("seat", "", "seat to report on (default: the first configured)")
+	fs.Parse(args)
+
+	switch *format {
+	case "json", "waybar", "polybar":
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	conn, err := dialControl(*socket)
+	if err != nil {
+		return fmt.Errorf("connect to driver: %w", err)
+	}
+	defer conn.Close()
+
+	cmd := "status"
+	if *follow {
+		cmd = "follow"
+	}
+	if err := json.NewEncoder(conn).Encode(controlRequest{Cmd: cmd, Seat: *seat}); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	dec := json.NewDecoder(conn)
+	for {
+		var resp controlResponse
+		if err := dec.Decode(&resp); err != nil {
+			return err
+		}
+		if !resp.OK || resp.Status == nil {
+			return fmt.Errorf("driver: %s", resp.Error)
+		}
+		out.WriteString(formatStatus(*resp.Status, *format))
+		out.WriteByte('\n')
+		out.Flush()
+		if !*follow {
+			return nil
+		}
+	}
+}
+
+func formatStatus(s Status, format string) string {
+	state := "enabled"
+	switch {
+	case !s.Enabled:
+		state = "disabled"
+	case s.Paused != "":
+		state = "paused"
+	}
+
+	switch format {
+	case "waybar":
+		tooltip := fmt.Sprintf("Touchpad %s\nProfile: %s\nFingers: %d", state, s.Profile, s.Fingers)
+		if s.Paused != "" {
+			tooltip += "\nPaused by: " + s.Paused
+		}
+		if s.LastGesture != "" {
+			tooltip += "\nLast gesture: " + s.LastGesture
+		}
+		text := s.Profile
+		switch {
+		case !s.Enabled:
+			text = "off"
+		case s.Paused != "":
+			text = "paused"
+		}
+		b, _ := json.Marshal(map[string]string{
+			"text":    text,
+			"alt":     state,
+			"class":   state,
+			"tooltip": tooltip,
+		})
+		return string(b)
+	case "polybar":
+		if !s.Enabled {
+			return "touchpad off"
+		}
+		if s.Paused != "" {
+			return "touchpad paused"
+		}
+		parts := []string{s.Profile}
+		if s.Fingers > 0 {
+			parts = append(parts, fmt.Sprintf("%d", s.Fingers))
+		}
+		if s.LastGesture != "" {
+			parts = append(parts, s.LastGesture)
+		}
+		return strings.Join(parts, " ")
+	default:
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+}
+
+// dialControl connects to path, or when empty to a user service's socket if
+// one is running and the system service's otherwise.
+func dialControl(path string) (net.Conn, error) {
+	if path != "" {
+		return net.Dial("unix", path)
+	}
+	if conn, err := net.Dial("unix", controlSocketPath()); err == nil {
+		return conn, nil
+	}
+	return net.Dial("unix", ControlSocketPath)
+}
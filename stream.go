@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"touchpad/engine"
+)
+
+// eventHub fans StreamEvents out to subscribers. Publishing never blocks: a
+// subscriber that falls behind misses events rather than stalling the loop.
+type eventHub struct {
+	mu    sync.Mutex
+	subs  map[chan engine.StreamEvent]struct{}
+	count atomic.Int32
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan engine.StreamEvent]struct{})}
+}
+
+// Active reports whether anyone is listening, so callers can skip building
+// events nobody will read.
+func (h *eventHub) Active() bool {
+	return h.count.Load() > 0
+}
+
+func (h *eventHub) Publish(ev engine.StreamEvent) {
+	if !h.Active() {
+		return
+	}
+	if ev.TimeUsec == 0 {
+		ev.TimeUsec = time.Now().UnixMicro()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (h *eventHub) Subscribe() (<-chan engine.StreamEvent, func()) {
+	ch := make(chan engine.StreamEvent, 256)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.count.Add(1)
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.count.Add(-1)
+		h.mu.Unlock()
+	}
+}
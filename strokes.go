@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"touchpad/engine"
+)
+
+// svgWidth is the width the SVG export is displayed at by default.
+const svgWidth = 800
+
+// StrokePoint is one sample of a contact, timed from the start of the capture.
+type StrokePoint struct {
+	TimeUsec int64 `json:"t_us"`
+	X        int32 `json:"x"`
+	Y        int32 `json:"y"`
+	P        int32 `json:"p"`
+}
+
+// Stroke is the path of one contact from touching down to lifting off.
+type Stroke struct {
+	Slot   int           `json:"slot"`
+	Points []StrokePoint `json:"points"`
+}
+
+// StrokeCapture is what the "strokes" subcommand exports, in touchpad units.
+type StrokeCapture struct {
+	Device      string   `json:"device"`
+	MaxX        int32    `json:"max_x"`
+	MaxY        int32    `json:"max_y"`
+	MaxPressure int32    `json:"max_pressure"`
+	Strokes     []Stroke `json:"strokes"`
+}
+
+// strokeRecorder turns the frames of the event stream into strokes. A slot
+// missing from a frame has lifted off, which ends its stroke.
+type strokeRecorder struct {
+	start  int64
+	open   map[int]*Stroke
+	done   []Stroke
+	frames int
+}
+
+func newStrokeRecorder() *strokeRecorder {
+	return &strokeRecorder{open: make(map[int]*Stroke)}
+}
+
+func (r *strokeRecorder) Frame(ev engine.StreamEvent) {
+	if r.frames == 0 {
+		r.start = ev.TimeUsec
+	}
+	r.frames++
+	seen := make(map[int]bool, len(ev.Contacts))
+	for _, ct := range ev.Contacts {
+		seen[ct.Slot] = true
+		s := r.open[ct.Slot]
+		if s == nil {
+			s = &Stroke{Slot: ct.Slot}
+			r.open[ct.Slot] = s
+		}
+		s.Points = append(s.Points, StrokePoint{ev.TimeUsec - r.start, ct.X, ct.Y, ct.P})
+	}
+	for slot, s := range r.open {
+		if !seen[slot] {
+			r.done = append(r.done, *s)
+			delete(r.open, slot)
+		}
+	}
+}
+
+// Strokes returns the finished strokes and, after them, the ones still in
+// progress.
+func (r *strokeRecorder) Strokes() []Stroke {
+	strokes := append([]Stroke(nil), r.done...)
+	for _, slot := range slices.Sorted(maps.Keys(r.open)) {
+		strokes = append(strokes, *r.open[slot])
+	}
+	return strokes
+}
+
+// Finished is how many strokes have ended so far.
+func (r *strokeRecorder) Finished() int { return len(r.done) }
+
+func (c StrokeCapture) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+// WriteSVG draws each stroke as a path over the touchpad's surface. Each
+// point's pressure and time stay available as data attributes, so the file
+// is enough to reproduce the input.
+func (c StrokeCapture) WriteSVG(w io.Writer) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d">`+"\n",
+		max(c.MaxX, 1), max(c.MaxY, 1), svgWidth, int64(svgWidth)*int64(max(c.MaxY, 1))/int64(max(c.MaxX, 1)))
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="white" stroke="gray"/>`+"\n")
+	stroke := max(c.MaxX/200, 1)
+	for _, s := range c.Strokes {
+		if len(s.Points) == 0 {
+			continue
+		}
+		var d, t, p strings.Builder
+		for i, pt := range s.Points {
+			if i == 0 {
+				fmt.Fprintf(&d, "M%d %d", pt.X, pt.Y)
+			} else {
+				fmt.Fprintf(&d, " L%d %d", pt.X, pt.Y)
+				t.WriteByte(' ')
+				p.WriteByte(' ')
+			}
+			fmt.Fprintf(&t, "%d", pt.TimeUsec)
+			fmt.Fprintf(&p, "%d", pt.P)
+		}
+		fmt.Fprintf(&b, `<path d="%s" fill="none" stroke="black" stroke-width="%d" stroke-linecap="round" stroke-linejoin="round" data-slot="%d" data-t-us="%s" data-pressure="%s"/>`+"\n",
+			d.String(), stroke, s.Slot, t.String(), p.String())
+	}
+	b.WriteString("</svg>\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// runStrokes implements the "strokes" subcommand: it records every contact's
+// path from the processed-event stream until interrupted, a time limit or a
+// number of strokes, then writes them as SVG or JSON.
+func runStrokes(args []string) error {
+	fs := flag.NewFlagSet("strokes", flag.ExitOnError)
+	format := fs.String("format", "svg", "output format: svg or json")
+	output := fs.String("o", "-", "output file, - for stdout")
+	duration := fs.Duration("duration", 0, "stop after this long (default: on Ctrl-C)")
+	count := fs.Int("count", 0, "stop after this many strokes have ended")
+	socket := fs.String("socket", "", "control socket path (default: the user service's, else the system one)")
+	seat := fs.String("seat", "", "seat to capture (default: the first configured)")
+	fs.Parse(args)
+	if *format != "svg" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	conn, err := dialControl(*socket)
+	if err != nil {
+		return fmt.Errorf("connect to driver: %w", err)
+	}
+	defer conn.Close()
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(conn)
+
+	if err := enc.Encode(controlRequest{Cmd: "device", Seat: *seat}); err != nil {
+		return err
+	}
+	var resp controlResponse
+	if err := dec.Decode(&resp); err != nil {
+		return err
+	}
+	if !resp.OK || resp.Device == nil {
+		return fmt.Errorf("driver: %s", resp.Error)
+	}
+	info := *resp.Device
+	if err := enc.Encode(controlRequest{Cmd: "events", Seat: *seat}); err != nil {
+		return err
+	}
+
+	// Stopping closes the connection, which ends the decode loop below.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	var timeout <-chan time.Time
+	if *duration > 0 {
+		timeout = time.After(*duration)
+	}
+	go func() {
+		select {
+		case <-sigs:
+		case <-timeout:
+		}
+		conn.Close()
+	}()
+	fmt.Fprintln(os.Stderr, "Recording strokes; press Ctrl-C to stop.")
+
+	rec := newStrokeRecorder()
+	for *count <= 0 || rec.Finished() < *count {
+		var ev engine.StreamEvent
+		if err := dec.Decode(&ev); err != nil {
+			break
+		}
+		if ev.Type == "frame" {
+			rec.Frame(ev)
+		}
+	}
+
+	capture := StrokeCapture{
+		Device:      info.Name,
+		MaxX:        info.MaxX,
+		MaxY:        info.MaxY,
+		MaxPressure: info.MaxPressure,
+		Strokes:     rec.Strokes(),
+	}
+	var buf bytes.Buffer
+	if *format == "svg" {
+		err = capture.WriteSVG(&buf)
+	} else {
+		err = capture.WriteJSON(&buf)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Captured %d strokes.\n", len(capture.Strokes))
+	if *output == "-" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return os.WriteFile(*output, buf.Bytes(), 0644)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+
+	"touchpad/device"
+)
+
+// The summary debug format (--debug-format summary) tells in words what the
+// driver makes of the touchpad, for tuning thresholds: when fingers land and
+// lift, with each contact's position and pressure, what the touch is taken
+// for, and each frame sent to the virtual devices as a move, a scroll in
+// wheel clicks, a click or tap and its button, or a key chord. Raw events
+// are left out.
+
+// touchSummary is what was last said about the touch on one touchpad, and
+// whether a finger is down on it as of the last raw event.
+type touchSummary struct {
+	fingers  int
+	mode     string
+	touching bool
+}
+
+// touch returns the touch on the touchpad at path. d.mu must be held.
+func (d *eventDump) touch(path string) *touchSummary {
+	st := d.touches[path]
+	if st == nil {
+		st = &touchSummary{}
+		d.touches[path] = st
+	}
+	return st
+}
+
+// summaryRaw notes the touchpad at path being touched and lifted as the
+// events come, ahead of the frame, so a button pressed as the finger lifts
+// can be told to be a tap.
+func (d *eventDump) summaryRaw(path string, ev evdev.InputEvent) {
+	if ev.Type != device.EV_KEY || ev.Code != evdev.BTN_TOUCH {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.touch(path).touching = ev.Value != 0
+}
+
+// summaryFrame prints the state of the touch on the touchpad at path when
+// the finger count or what the touch is taken for changes.
+func (d *eventDump) summaryFrame(path string, at time.Time, fingers int, mode string, slots *device.Slots) {
+	d.mu.Lock()
+	st := d.touch(path)
+	lifted := fingers == 0 && st.fingers != 0
+	changed := fingers != st.fingers || mode != st.mode
+	st.fingers, st.mode = fingers, mode
+	d.mu.Unlock()
+
+	source := filepath.Base(path)
+	switch {
+	case lifted:
+		d.line(source, "lift", at, "")
+		return
+	case !changed || fingers == 0:
+		return
+	}
+	switch mode {
+	case "palm":
+		d.line(source, "palm rejected", at, contacts(slots))
+		return
+	case "paused":
+		d.line(source, "paused", at, "touches are ignored")
+		return
+	}
+	d.line(source, fmt.Sprintf("%d-finger %s", fingers, mode), at, contacts(slots))
+}
+
+// contacts lists the active slots as "[slot] x,y pressure".
+func contacts(slots *device.Slots) string {
+	var parts []string
+	for i, s := range slots {
+		if s.Active {
+			parts = append(parts, fmt.Sprintf("[%d] %d,%d p%d", i, s.X, s.Y, s.P))
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+// summaryDevice returns the trace hook for the virtual device named device,
+// which the touchpad at path drives, in the summary format.
+func (d *eventDump) summaryDevice(dev, path string) func(typ, code uint16, value int32) {
+	var f libinputFrame
+	return func(typ, code uint16, value int32) {
+		switch typ {
+		case device.EV_REL:
+			switch code {
+			case device.REL_X:
+				f.dx += value
+			case device.REL_Y:
+				f.dy += value
+			case device.REL_WHEEL_HI_RES:
+				f.wheel += value
+			case device.REL_HWHEEL_HI_RES:
+				f.hwheel += value
+			}
+		case device.EV_ABS:
+			switch code {
+			case device.ABS_X:
+				f.absX, f.abs = value, true
+			case device.ABS_Y:
+				f.absY, f.abs = value, true
+			}
+		case device.EV_KEY:
+			f.keys = append(f.keys, keyChange{code, value})
+		case device.EV_SYN:
+			d.printSummary(dev, path, &f)
+			f = libinputFrame{}
+		}
+	}
+}
+
+func (d *eventDump) printSummary(dev, path string, f *libinputFrame) {
+	now := time.Now()
+	d.mu.Lock()
+	st := d.touch(path)
+	fingers, touching := st.fingers, st.touching
+	d.mu.Unlock()
+
+	if f.abs {
+		d.line(dev, "point", now, fmt.Sprintf("%d,%d", f.absX, f.absY))
+	}
+	if f.dx != 0 || f.dy != 0 {
+		d.line(dev, "move", now, fmt.Sprintf("%+d,%+d", f.dx, f.dy))
+	}
+	if f.wheel != 0 || f.hwheel != 0 {
+		var parts []string
+		if f.wheel != 0 {
+			parts = append(parts, fmt.Sprintf("%+.2f ticks", float64(f.wheel)/120))
+		}
+		if f.hwheel != 0 {
+			parts = append(parts, fmt.Sprintf("%+.2f ticks sideways", float64(f.hwheel)/120))
+		}
+		name := "scroll"
+		if fingers > 0 {
+			name = fmt.Sprintf("%d-finger scroll", fingers)
+		}
+		d.line(dev, name, now, strings.Join(parts, ", "))
+	}
+
+	var chord []string
+	for _, k := range f.keys {
+		if k.value > 1 {
+			continue
+		}
+		name := codeName(device.EV_KEY, k.code)
+		button := k.code >= evdev.BTN_MISC && k.code < evdev.KEY_OK
+		switch {
+		case button && k.value == 1 && !touching:
+			// Pressed with no finger down: the touch that just
+			// lifted was a tap.
+			d.line(dev, "tap", now, "→ "+name)
+		case button && k.value == 1:
+			d.line(dev, "press", now, name)
+		case button:
+			d.line(dev, "release", now, name)
+		case k.value == 1:
+			chord = append(chord, strings.TrimPrefix(name, "KEY_"))
+		}
+	}
+	if len(chord) > 0 {
+		name := "keys"
+		if fingers >= 3 {
+			name = fmt.Sprintf("%d-finger swipe", fingers)
+		}
+		d.line(dev, name, now, "→ "+strings.Join(chord, "+"))
+	}
+}
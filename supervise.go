@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"touchpad/device"
+	"touchpad/engine"
+)
+
+const (
+	// A seat that fails more than MaxRestarts times within RestartWindow
+	// is given up on.
+	MaxRestarts   = 5
+	RestartWindow = time.Minute
+
+	restartBackoffMin = 250 * time.Millisecond
+	restartBackoffMax = 8 * time.Second
+)
+
+// supervise keeps the seat's loop running across touchpad failures (an i2c
+// reset, a driver rebind, an unplug), reopening the device with exponential
+// backoff once it is back.
+// It only returns once failures come too quickly to be worth retrying, or
+// straight away if the loop crashed.
+func (s *seatInstance) supervise() error {
+	var failures []time.Time
+	backoff := restartBackoffMin
+	// lost is set from a failure until the touchpad is back, so a run of
+	// failed reopens is announced once.
+	lost := false
+
+	for {
+		started := time.Now()
+		err := s.run()
+		if errors.Is(err, engine.ErrCrashed) {
+			return err
+		}
+		now := time.Now()
+		if now.Sub(started) > RestartWindow {
+			backoff = restartBackoffMin
+		}
+
+		recent := failures[:0]
+		for _, t := range failures {
+			if now.Sub(t) < RestartWindow {
+				recent = append(recent, t)
+			}
+		}
+		failures = append(recent, now)
+		if len(failures) > MaxRestarts {
+			return fmt.Errorf("%w (giving up after %d failures within %v)", err, len(failures), RestartWindow)
+		}
+
+		slog.Warn("seat loop failed, restarting", "seat", s.cfg.Seat, "err", err, "backoff", backoff)
+		if !lost {
+			lost = true
+			s.status.DeviceChanged(false)
+		}
+		s.status.Pause("restart")
+		s.releaseAll()
+		s.pad.Close()
+		time.Sleep(backoff)
+		backoff = min(backoff*2, restartBackoffMax)
+
+		// The virtual devices stay, so clients never see the mouse go.
+		path := reopenTouchpad(s.pad, s.cfg.Device, s.cfg.Seat)
+		slog.Info("restarted", "seat", s.cfg.Seat, "device", path)
+		s.status.Resume("restart")
+		lost = false
+		s.status.DeviceChanged(true)
+	}
+}
+
+// HotplugRecheck is how often a missing touchpad is looked for even
+// without a change in /dev/input, in case a notification was missed or
+// inotify is unavailable.
+const HotplugRecheck = 5 * time.Second
+
+// reopenTouchpad waits for the touchpad to come back after resume or a bus
+// reset, however long it takes, and opens it. Rather than polling, it looks
+// again whenever a node appears in /dev/input.
+func reopenTouchpad(pad *device.Touchpad, keyword, seat string) string {
+	w, err := device.NewWatcher()
+	if err != nil {
+		slog.Debug("cannot watch for input devices", "err", err)
+	} else {
+		defer w.Close()
+	}
+	waiting := false
+	for {
+		path, err := device.Find(keyword, DeviceNameMustContain, seat)
+		if err == nil {
+			if err = pad.Open(path); err == nil {
+				if waiting {
+					sdNotify("STATUS=Touchpad on " + seat + " is back")
+				}
+				return path
+			}
+		}
+		if !waiting {
+			waiting = true
+			slog.Info("waiting for the touchpad to come back", "seat", seat, "err", err)
+			// systemctl status shows why nothing is happening.
+			sdNotify("STATUS=Waiting for the touchpad on " + seat)
+		}
+		if w != nil {
+			w.Wait(HotplugRecheck)
+		} else {
+			time.Sleep(250 * time.Millisecond)
+		}
+	}
+}
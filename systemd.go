@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"touchpad/engine"
+)
+
+// sdNotify sends a state string to the service manager. It is a no-op when
+// not started by systemd with Type=notify.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the WatchdogSec= configured for this process, or 0
+// if the watchdog is not enabled.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runWatchdog sends keepalives at half the configured interval for as long as
+// every event loop stays healthy. Once one wedges, keepalives stop and systemd
+// restarts the service. While dormant, with no loop doing anything, it asks
+// systemd for a much longer timeout instead.
+func runWatchdog(dormant *dormancy, hbs ...*engine.Heartbeat) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	tick := time.NewTicker(interval / 2)
+	for range tick.C {
+		if dormant.Dormant() {
+			tick.Stop()
+			sdNotify(fmt.Sprintf("WATCHDOG=1\nWATCHDOG_USEC=%d", DormantWatchdog.Microseconds()))
+			<-dormant.Wait()
+			sdNotify(fmt.Sprintf("WATCHDOG=1\nWATCHDOG_USEC=%d", interval.Microseconds()))
+			tick.Reset(interval / 2)
+			continue
+		}
+		healthy := true
+		for _, hb := range hbs {
+			healthy = healthy && hb.Healthy(interval/2)
+		}
+		if healthy {
+			sdNotify("WATCHDOG=1")
+		}
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+// touchscreenDeviceName names the device used in touchscreen output mode.
+func touchscreenDeviceName(base, seat string) string {
+	return virtualDeviceName(base, seat) + " Touchscreen"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	evdev "github.com/gvalkov/golang-evdev"
+	"golang.org/x/sys/unix"
+
+	"touchpad/device"
+	"touchpad/uinput"
+)
+
+// isTypingKey reports whether a press of code means the user is typing.
+// Modifiers don't, since they go with clicks and drags.
+func isTypingKey(code uint16) bool {
+	switch code {
+	case evdev.KEY_LEFTCTRL, evdev.KEY_RIGHTCTRL, evdev.KEY_LEFTSHIFT, evdev.KEY_RIGHTSHIFT,
+		evdev.KEY_LEFTALT, evdev.KEY_RIGHTALT, evdev.KEY_LEFTMETA, evdev.KEY_RIGHTMETA:
+		return false
+	}
+	return code < evdev.BTN_MISC
+}
+
+// isKeyboard reports whether dev types letters, as opposed to a device with
+// a few keys such as a power button or a lid switch.
+func isKeyboard(dev *evdev.InputDevice) bool {
+	keys := dev.CapabilitiesFlat[evdev.EV_KEY]
+	return slices.Contains(keys, evdev.KEY_A) && slices.Contains(keys, evdev.KEY_SPACE)
+}
+
+// watchTyping opens the keyboards on the seat, other than the driver's own
+// virtual ones (named from base), and notes the time of every typing key
+// pressed on them for disable_while_typing. They are read, without a grab,
+// by the seat's event loop.
+func (s *seatInstance) watchTyping(base string) error {
+	devices, _ := evdev.ListInputDevices()
+	for _, dev := range devices {
+		own := strings.HasPrefix(dev.Name, base) || strings.HasPrefix(dev.Name, uinput.AndroidName(base))
+		if own || !isKeyboard(dev) || device.Seat(dev.Fn) != s.cfg.Seat {
+			dev.File.Close()
+			continue
+		}
+		f := dev.File
+		fd := int(f.Fd())
+		if err := unix.SetNonblock(fd, true); err != nil {
+			f.Close()
+			continue
+		}
+		if err := s.loop.Add(fd, func() { s.readKeyboard(f) }); err != nil {
+			f.Close()
+			continue
+		}
+		s.keyboards = append(s.keyboards, f)
+		slog.Debug("watching keyboard for typing", "seat", s.cfg.Seat, "keyboard", dev.Name, "path", dev.Fn)
+	}
+	if len(s.keyboards) == 0 {
+		return errors.New("no keyboard on the seat")
+	}
+	return nil
+}
+
+// readKeyboard drains the keyboard f. A keyboard that goes away is dropped.
+func (s *seatInstance) readKeyboard(f *os.File) {
+	fd := int(f.Fd())
+	var buf [64 * device.EventSize]byte
+	for {
+		n, err := unix.Read(fd, buf[:])
+		if err == unix.EAGAIN || err == unix.EINTR {
+			return
+		}
+		if err != nil || n <= 0 {
+			slog.Debug("keyboard gone", "seat", s.cfg.Seat, "path", f.Name(), "err", err)
+			s.loop.Remove(fd)
+			f.Close()
+			return
+		}
+		for ev := buf[:n]; len(ev) >= device.EventSize; ev = ev[device.EventSize:] {
+			e := device.ParseEvent(ev)
+			// Autorepeat counts too: the key is still being held.
+			if e.Type == device.EV_KEY && e.Value != 0 && isTypingKey(e.Code) {
+				s.lastTyped.Store(time.Now().UnixNano())
+			}
+		}
+	}
+}
+
+// Typing reports whether a key was typed less than window before t.
+func (s *seatInstance) Typing(t time.Time, window time.Duration) bool {
+	last := s.lastTyped.Load()
+	return last != 0 && t.UnixNano()-last < int64(window)
+}
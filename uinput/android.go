@@ -0,0 +1,35 @@
+package uinput
+
+import (
+	"os"
+	"strings"
+)
+
+// Paths are where the uinput node may be: /dev/uinput normally, the others
+// on some older Android and vendor kernels.
+var Paths = []string{"/dev/uinput", "/dev/input/uinput", "/dev/misc/uinput"}
+
+// Path returns the first uinput node that exists, or /dev/uinput for the
+// error message when none does.
+func Path() string {
+	for _, path := range Paths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return Paths[0]
+}
+
+// AndroidName reduces name to the characters Android keeps when it looks up
+// input device configuration and key layout files by device name, so files
+// for the virtual devices can be named after them, and labelled for
+// SELinux, without guessing.
+func AndroidName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
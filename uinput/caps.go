@@ -0,0 +1,34 @@
+package uinput
+
+import "touchpad/device"
+
+// TabletCaps describes an absolute pointer covering the touchpad's axis
+// ranges, so the compositor maps the whole pad onto the screen. It keeps the
+// wheels for two-finger scrolling.
+func TabletCaps(info device.Info) Caps {
+	return Caps{
+		Rels: []int{device.REL_WHEEL, device.REL_HWHEEL, device.REL_WHEEL_HI_RES, device.REL_HWHEEL_HI_RES},
+		Keys: []int{device.BTN_LEFT, device.BTN_RIGHT, device.BTN_MIDDLE, device.BTN_SIDE, device.BTN_EXTRA},
+		Abs: []AbsAxis{
+			{device.ABS_X, device.AbsInfo{Minimum: info.MinX, Maximum: info.MaxX}},
+			{device.ABS_Y, device.AbsInfo{Minimum: info.MinY, Maximum: info.MaxY}},
+		},
+	}
+}
+
+// TouchscreenCaps describes a direct-touch multitouch screen with the
+// touchpad's axis ranges.
+func TouchscreenCaps(info device.Info) Caps {
+	return Caps{
+		Keys:  []int{device.BTN_TOUCH},
+		Props: []int{device.INPUT_PROP_DIRECT},
+		Abs: []AbsAxis{
+			{device.ABS_X, device.AbsInfo{Minimum: info.MinX, Maximum: info.MaxX}},
+			{device.ABS_Y, device.AbsInfo{Minimum: info.MinY, Maximum: info.MaxY}},
+			{device.ABS_MT_SLOT, device.AbsInfo{Maximum: device.MaxSlots - 1}},
+			{device.ABS_MT_TRACKING_ID, device.AbsInfo{Maximum: 0xffff}},
+			{device.ABS_MT_POSITION_X, device.AbsInfo{Minimum: info.MinX, Maximum: info.MaxX}},
+			{device.ABS_MT_POSITION_Y, device.AbsInfo{Minimum: info.MinY, Maximum: info.MaxY}},
+		},
+	}
+}
@@ -0,0 +1,517 @@
+// Package uinput creates the driver's virtual devices and writes their
+// events.
+package uinput
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"touchpad/device"
+)
+
+const (
+	UINPUT_MAX_NAME_SIZE = 80
+
+	UI_SET_EVBIT   = 0x40045564
+	UI_SET_KEYBIT  = 0x40045565
+	UI_SET_RELBIT  = 0x40045566
+	UI_SET_ABSBIT  = 0x40045567
+	UI_SET_PROPBIT = 0x4004556e
+	UI_DEV_CREATE  = 0x5501
+	UI_DEV_DESTROY = 0x5502
+	UI_DEV_SETUP   = 0x405c5503 // _IOW('U', 3, struct uinput_setup)
+	UI_ABS_SETUP   = 0x401c5504 // _IOW('U', 4, struct uinput_abs_setup)
+	UI_GET_VERSION = 0x8004552d // _IOR('U', 45, unsigned int)
+
+	// UINPUT_VERSION_SETUP is the uinput version that brought UI_DEV_SETUP
+	// and UI_ABS_SETUP, in Linux 4.5.
+	UINPUT_VERSION_SETUP = 5
+)
+
+// uinputSetup mirrors struct uinput_setup, for UI_DEV_SETUP.
+type uinputSetup struct {
+	ID         ID
+	Name       [UINPUT_MAX_NAME_SIZE]byte
+	EffectsMax uint32
+}
+
+// uinputAbsSetup mirrors struct uinput_abs_setup, for UI_ABS_SETUP.
+type uinputAbsSetup struct {
+	Code uint16
+	_    uint16
+	Info device.AbsInfo
+}
+
+// uinputUserDev is the legacy setup record written to the device on kernels
+// without UI_DEV_SETUP.
+type uinputUserDev struct {
+	Name       [UINPUT_MAX_NAME_SIZE]byte
+	ID         ID
+	EffectsMax uint32
+	Absmax     [64]int32
+	Absmin     [64]int32
+	Absfuzz    [64]int32
+	Absflat    [64]int32
+}
+
+// ID is the bus, vendor, product and version a device reports.
+type ID struct {
+	Bustype uint16
+	Vendor  uint16
+	Product uint16
+	Version uint16
+}
+
+// Device is a virtual input device. Events are queued as they are written
+// and handed to a writer goroutine a batch at a time by Flush.
+type Device struct {
+	sink io.WriteCloser
+	name string
+	// node is the device's /dev/input/event* path, once known.
+	node string
+
+	// mu serializes writers; held tracks keys and buttons currently down,
+	// and since when, so they can be released when input stops unexpectedly.
+	mu   sync.Mutex
+	held map[uint16]time.Time
+
+	// Trace, if set, sees every event as it is queued (--debug-events).
+	Trace func(typ, code uint16, value int32)
+
+	// pending collects events until Flush hands them to the writer, which
+	// writes each buffer in a single syscall; unsynced is set while it ends
+	// in something other than SYN_REPORT.
+	pending  []byte
+	unsynced bool
+	closed   bool
+
+	// stamp is the kernel time of the oldest input behind pending.
+	stamp time.Time
+
+	// out and free carry buffers to the writer goroutine and back. With
+	// all of them in flight, Flush waits for the writer.
+	out  chan outBuffer
+	free chan []byte
+
+	latency latencyRecorder
+	// done is closed once the writer has destroyed the device.
+	done chan struct{}
+
+	// writeErrors counts failed writes and dropped the events lost with
+	// them.
+	writeErrors atomic.Uint64
+	dropped     atomic.Uint64
+}
+
+// outBuffer is a flushed batch of events on its way to the writer.
+type outBuffer struct {
+	data  []byte
+	stamp time.Time
+}
+
+// WriteQueue is how many flushed buffers may wait for the uinput writer.
+const WriteQueue = 8
+
+// A write uinput refuses with EAGAIN is retried WriteRetries times, waiting
+// twice as long each time from writeRetryDelay, before its events are
+// dropped. Meanwhile further buffers queue up behind it.
+const (
+	WriteRetries    = 5
+	writeRetryDelay = 500 * time.Microsecond
+)
+
+func ioctl(fd uintptr, request uintptr, val uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, val)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func ioctlInt(fd uintptr, request uintptr, val int) error {
+	return ioctl(fd, request, uintptr(val))
+}
+
+// Caps is the capability set a virtual device advertises.
+type Caps struct {
+	Rels, Keys, Props []int
+	Abs               []AbsAxis
+}
+
+// AbsAxis is an absolute axis of a virtual device and its range.
+type AbsAxis struct {
+	Code int
+	Info device.AbsInfo
+}
+
+// MouseCaps are a relative pointer's. The gesture keys live on a device of
+// their own, so compositors classify each correctly instead of seeing a
+// mouse with a keyboard attached.
+var MouseCaps = Caps{
+	Rels:  []int{device.REL_X, device.REL_Y, device.REL_WHEEL, device.REL_HWHEEL, device.REL_WHEEL_HI_RES, device.REL_HWHEEL_HI_RES},
+	Keys:  []int{device.BTN_LEFT, device.BTN_RIGHT, device.BTN_MIDDLE, device.BTN_SIDE, device.BTN_EXTRA},
+	Props: []int{device.INPUT_PROP_POINTER},
+}
+
+// Create creates a device called name with the identity id and the
+// capabilities caps, and waits until it is ready for events. An error
+// opening the uinput node is the *fs.PathError from the open.
+func Create(name string, id ID, caps Caps) (*Device, error) {
+	if device.OnAndroid() {
+		name = AndroidName(name)
+	}
+	f, err := os.OpenFile(Path(), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := f.Fd()
+
+	evbits := []int{device.EV_KEY, device.EV_SYN}
+	if len(caps.Rels) > 0 {
+		evbits = append(evbits, device.EV_REL)
+	}
+	if len(caps.Abs) > 0 {
+		evbits = append(evbits, device.EV_ABS)
+	}
+	for _, ev := range evbits {
+		if err := ioctlInt(fd, UI_SET_EVBIT, ev); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("set evbit %d: %w", ev, err)
+		}
+	}
+
+	for _, rel := range caps.Rels {
+		if err := ioctlInt(fd, UI_SET_RELBIT, rel); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("set relbit %d: %w", rel, err)
+		}
+	}
+
+	for _, a := range caps.Abs {
+		if err := ioctlInt(fd, UI_SET_ABSBIT, a.Code); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("set absbit %d: %w", a.Code, err)
+		}
+	}
+
+	for _, key := range caps.Keys {
+		if err := ioctlInt(fd, UI_SET_KEYBIT, key); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("set keybit %d: %w", key, err)
+		}
+	}
+
+	for _, prop := range caps.Props {
+		if err := ioctlInt(fd, UI_SET_PROPBIT, prop); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("set propbit %d: %w", prop, err)
+		}
+	}
+
+	if err := setupDevice(f, name, id, caps.Abs); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := ioctl(fd, UI_DEV_CREATE, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("dev create: %w", err)
+	}
+
+	node, err := waitReady(fd)
+	if err != nil {
+		slog.Warn("virtual device may not be ready", "device", name, "err", err)
+	}
+	return New(uinputSink{f}, name, node), nil
+}
+
+// uinputSink is a created uinput device.
+type uinputSink struct {
+	f *os.File
+}
+
+func (u uinputSink) Write(data []byte) (int, error) { return u.f.Write(data) }
+
+func (u uinputSink) Close() error {
+	ioctl(u.f.Fd(), UI_DEV_DESTROY, 0)
+	return u.f.Close()
+}
+
+// New starts the writer for a device writing to sink. A nil sink makes a
+// device that goes nowhere, for --dry-run; only its Trace sees what it is
+// sent.
+func New(sink io.WriteCloser, name, node string) *Device {
+	v := &Device{
+		sink:    sink,
+		name:    name,
+		node:    node,
+		held:    make(map[uint16]time.Time),
+		pending: make([]byte, 0, 64*device.EventSize),
+		out:     make(chan outBuffer, WriteQueue),
+		free:    make(chan []byte, WriteQueue),
+		done:    make(chan struct{}),
+	}
+	for range WriteQueue - 1 {
+		v.free <- make([]byte, 0, 64*device.EventSize)
+	}
+	go v.writer()
+	return v
+}
+
+// setupDevice declares the device's name, identity and axis ranges with
+// UI_DEV_SETUP and UI_ABS_SETUP, falling back to writing a uinput_user_dev on
+// kernels whose uinput is older than those ioctls. A kernel that claims a new
+// enough version but rejects the ioctl with EINVAL gets the fallback too.
+func setupDevice(f *os.File, name string, id ID, abs []AbsAxis) error {
+	if version, err := uinputVersion(f); err != nil || version < UINPUT_VERSION_SETUP {
+		// UI_GET_VERSION itself is missing before 3.15.
+		slog.Debug("using the legacy uinput setup", "version", version, "err", err)
+		return setupDeviceLegacy(f, name, id, abs)
+	}
+	setup := uinputSetup{ID: id}
+	copy(setup.Name[:UINPUT_MAX_NAME_SIZE-1], name)
+	err := ioctl(f.Fd(), UI_DEV_SETUP, uintptr(unsafe.Pointer(&setup)))
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTTY) {
+		return setupDeviceLegacy(f, name, id, abs)
+	}
+	if err != nil {
+		return fmt.Errorf("dev setup: %w", err)
+	}
+	for _, a := range abs {
+		as := uinputAbsSetup{Code: uint16(a.Code), Info: a.Info}
+		if err := ioctl(f.Fd(), UI_ABS_SETUP, uintptr(unsafe.Pointer(&as))); err != nil {
+			return fmt.Errorf("abs setup %d: %w", a.Code, err)
+		}
+	}
+	return nil
+}
+
+// uinputVersion returns the version of the kernel's uinput protocol.
+func uinputVersion(f *os.File) (uint32, error) {
+	var version uint32
+	err := ioctl(f.Fd(), UI_GET_VERSION, uintptr(unsafe.Pointer(&version)))
+	return version, err
+}
+
+func setupDeviceLegacy(f *os.File, name string, id ID, abs []AbsAxis) error {
+	dev := uinputUserDev{ID: id}
+	copy(dev.Name[:UINPUT_MAX_NAME_SIZE-1], name)
+	for _, a := range abs {
+		dev.Absmin[a.Code] = a.Info.Minimum
+		dev.Absmax[a.Code] = a.Info.Maximum
+		dev.Absfuzz[a.Code] = a.Info.Fuzz
+		dev.Absflat[a.Code] = a.Info.Flat
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.NativeEndian, &dev)
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write dev info: %w", err)
+	}
+	return nil
+}
+
+// writer is the device's output stage, so a slow write to uinput holds up
+// neither reading nor the state machine until the queue is full.
+func (v *Device) writer() {
+	// failing is set from a failed write until one succeeds, so a broken
+	// device logs once rather than for every report.
+	failing := false
+	for buf := range v.out {
+		if err := v.writeAll(buf.data); err != nil {
+			v.writeErrors.Add(1)
+			v.dropped.Add(uint64(len(buf.data) / device.EventSize))
+			if !failing {
+				slog.Error("writing to virtual device failed, dropping events", "device", v.name, "err", err)
+				failing = true
+			}
+		} else {
+			if failing {
+				slog.Info("writing to virtual device works again", "device", v.name, "dropped", v.dropped.Load())
+				failing = false
+			}
+			if !buf.stamp.IsZero() {
+				v.latency.Record(time.Since(buf.stamp))
+			}
+		}
+		v.free <- buf.data[:0]
+	}
+	if v.sink != nil {
+		v.sink.Close()
+	}
+	close(v.done)
+}
+
+// writeAll writes data completely, retrying while uinput reports EAGAIN.
+func (v *Device) writeAll(data []byte) error {
+	if v.sink == nil {
+		return nil
+	}
+	delay := writeRetryDelay
+	for retries := 0; len(data) > 0; {
+		n, err := v.sink.Write(data)
+		data = data[n:]
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, syscall.EAGAIN) || retries == WriteRetries {
+			return err
+		}
+		retries++
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return nil
+}
+
+func (v *Device) WriteEvent(typ uint16, code uint16, value int32) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if typ == device.EV_KEY {
+		if value == 0 {
+			delete(v.held, code)
+		} else if _, ok := v.held[code]; !ok {
+			v.held[code] = time.Now()
+		}
+	}
+	v.write(typ, code, value)
+}
+
+// write queues an event. The time is left zero; the input core stamps events
+// as uinput delivers them.
+func (v *Device) write(typ uint16, code uint16, value int32) {
+	if v.Trace != nil {
+		v.Trace(typ, code, value)
+	}
+	var ev [device.EventSize]byte
+	device.PutEvent(ev[:], typ, code, value)
+	v.pending = append(v.pending, ev[:]...)
+	v.unsynced = typ != device.EV_SYN
+}
+
+func (v *Device) flush() {
+	if len(v.pending) == 0 || v.closed {
+		// Input that produced no output has no latency to measure.
+		v.stamp = time.Time{}
+		return
+	}
+	v.out <- outBuffer{v.pending, v.stamp}
+	v.pending = <-v.free
+	v.stamp = time.Time{}
+}
+
+// Stamp notes that the queued events stem from input the kernel timestamped
+// at t, for latency measurement.
+func (v *Device) Stamp(t time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stamp.IsZero() || t.Before(v.stamp) {
+		v.stamp = t
+	}
+}
+
+// Flush writes everything queued since the last Flush.
+func (v *Device) Flush() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.flush()
+}
+
+// ReleaseAll sends key-up for everything still held down.
+func (v *Device) ReleaseAll() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.held) == 0 {
+		return
+	}
+	for code := range v.held {
+		v.write(device.EV_KEY, code, 0)
+	}
+	v.write(device.EV_SYN, device.SYN_REPORT, 0)
+	v.flush()
+	clear(v.held)
+}
+
+// ReleaseStuck sends key-up for everything held down since before cutoff and
+// returns what it released.
+func (v *Device) ReleaseStuck(cutoff time.Time) []uint16 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var stuck []uint16
+	for code, since := range v.held {
+		if since.Before(cutoff) {
+			stuck = append(stuck, code)
+			v.write(device.EV_KEY, code, 0)
+			delete(v.held, code)
+		}
+	}
+	if len(stuck) > 0 {
+		v.write(device.EV_SYN, device.SYN_REPORT, 0)
+		v.flush()
+	}
+	return stuck
+}
+
+// Holding reports whether any key or button is down.
+func (v *Device) Holding() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.held) > 0
+}
+
+// syn ends the current report, unless nothing was queued since the last one.
+func (v *Device) Syn() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.unsynced {
+		v.write(device.EV_SYN, device.SYN_REPORT, 0)
+	}
+}
+
+// Close ends the current report, waits for the writer to write everything
+// queued and destroys the device, so no ghost device is left behind.
+func (v *Device) Close() {
+	v.mu.Lock()
+	if v.closed {
+		v.mu.Unlock()
+		return
+	}
+	if v.unsynced {
+		v.write(device.EV_SYN, device.SYN_REPORT, 0)
+	}
+	v.flush()
+	v.closed = true
+	close(v.out)
+	v.mu.Unlock()
+	<-v.done
+}
+
+// Name is the name the device was created with.
+func (v *Device) Name() string {
+	return v.name
+}
+
+// WriteErrors counts the writes that failed after retrying, and Dropped the
+// events lost with them.
+func (v *Device) WriteErrors() uint64 {
+	return v.writeErrors.Load()
+}
+
+func (v *Device) Dropped() uint64 {
+	return v.dropped.Load()
+}
+
+// Latency summarizes the device's recent output latency.
+func (v *Device) Latency() LatencySummary {
+	return v.latency.Summary()
+}
@@ -0,0 +1,53 @@
+package uinput
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// LatencySamples is how many recent frames latency percentiles are taken
+// over.
+const LatencySamples = 1024
+
+// LatencySummary describes the time from the kernel timestamping a touchpad
+// report to the resulting events being written to uinput.
+type LatencySummary struct {
+	Samples int   `json:"samples"`
+	P50Usec int64 `json:"p50_usec"`
+	P90Usec int64 `json:"p90_usec"`
+	P99Usec int64 `json:"p99_usec"`
+	MaxUsec int64 `json:"max_usec"`
+}
+
+// latencyRecorder keeps the last LatencySamples latencies in a ring.
+type latencyRecorder struct {
+	mu      sync.Mutex
+	samples [LatencySamples]time.Duration
+	n, next int
+}
+
+func (r *latencyRecorder) Record(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % LatencySamples
+	r.n = min(r.n+1, LatencySamples)
+}
+
+func (r *latencyRecorder) Summary() LatencySummary {
+	r.mu.Lock()
+	sorted := slices.Clone(r.samples[:r.n])
+	r.mu.Unlock()
+
+	s := LatencySummary{Samples: len(sorted)}
+	if len(sorted) == 0 {
+		return s
+	}
+	slices.Sort(sorted)
+	at := func(q float64) int64 {
+		return sorted[int(q*float64(len(sorted)-1))].Microseconds()
+	}
+	s.P50Usec, s.P90Usec, s.P99Usec, s.MaxUsec = at(0.5), at(0.9), at(0.99), at(1)
+	return s
+}
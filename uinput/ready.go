@@ -0,0 +1,69 @@
+package uinput
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+const (
+	// ReadyTimeout bounds how long a new virtual device is waited for.
+	ReadyTimeout = 2 * time.Second
+	readyPoll    = 5 * time.Millisecond
+)
+
+// uiGetSysname is UI_GET_SYSNAME(len): _IOC(_IOC_READ, 'U', 44, len).
+func uiGetSysname(n uintptr) uintptr {
+	return 2<<30 | n<<16 | 'U'<<8 | 44
+}
+
+// waitReady waits until the device just created on the uinput fd is usable:
+// its event node exists and udev has processed it, which is when libinput
+// and compositors hear about it. Events sent before then would be lost. It
+// returns the event node's path.
+func waitReady(fd uintptr) (string, error) {
+	var buf [64]byte
+	if err := ioctl(fd, uiGetSysname(uintptr(len(buf))), uintptr(unsafe.Pointer(&buf))); err != nil {
+		// Kernels before 3.15 can't say; fall back to a fixed delay.
+		time.Sleep(200 * time.Millisecond)
+		return "", fmt.Errorf("UI_GET_SYSNAME: %w", err)
+	}
+	sysname := strings.TrimRight(string(buf[:]), "\x00")
+	sysDir := filepath.Join("/sys/devices/virtual/input", sysname)
+
+	deadline := time.Now().Add(ReadyTimeout)
+	for ; time.Now().Before(deadline); time.Sleep(readyPoll) {
+		events, _ := filepath.Glob(filepath.Join(sysDir, "event*"))
+		if len(events) == 0 {
+			continue
+		}
+		node := filepath.Join("/dev/input", filepath.Base(events[0]))
+		if _, err := os.Stat(node); err != nil {
+			continue
+		}
+		if !udevProcessed(events[0]) {
+			continue
+		}
+		return node, nil
+	}
+	return "", errors.New("timed out waiting for " + sysname)
+}
+
+// udevProcessed reports whether udev has finished with the event device at
+// sysPath, going by its database entry. Without udev there is nothing to
+// wait for.
+func udevProcessed(sysPath string) bool {
+	if _, err := os.Stat("/run/udev/control"); err != nil {
+		return true
+	}
+	dev, err := os.ReadFile(filepath.Join(sysPath, "dev"))
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat("/run/udev/data/c" + strings.TrimSpace(string(dev)))
+	return err == nil
+}